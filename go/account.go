@@ -0,0 +1,262 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	pb "github.com/hyperledger/fabric/protos/peer"
+)
+
+const (
+	AccountPending = "pending"
+	AccountActive  = "active"
+	AccountFrozen  = "frozen"
+	AccountClosed  = "closed"
+)
+
+var accountStatus = []string{AccountPending, AccountActive, AccountFrozen, AccountClosed}
+
+// Account account registry, only active accounts may trade.
+// Assets are held by account names, the creator identity bound to an account acts for it.
+type Account struct {
+	DocType    string `json:"docType,omitempty"`
+	UUID       string `json:"uuid"`
+	Name       string `json:"name"`
+	Status     string `json:"status"`
+	KYCLevel   int    `json:"kycLevel"`
+	UpdateTime int64  `json:"updateTime"`
+	UpdatedBy  string `json:"updatedBy"`
+	PublicKey  string `json:"publicKey,omitempty"`
+	Nonce      int64  `json:"nonce,omitempty"`
+	Identity   string `json:"identity,omitempty"`
+}
+
+func (c *ExchangeChaincode) putAccount(account *Account) error {
+	if account.UUID == "" {
+		account.UUID = GenerateUUID()
+	}
+	account.DocType = DocAccount
+	r, err := json.Marshal(account)
+	if err != nil {
+		return err
+	}
+
+	err = c.stub.PutState(account.UUID, r)
+	if err != nil {
+		return err
+	}
+
+	err = c.putCompositeValue("Account~name~uuid", []string{account.Name, account.UUID})
+	if err != nil {
+		return err
+	}
+
+	if account.Identity != "" {
+		err = c.putCompositeValue("Account~identity~uuid", []string{account.Identity, account.UUID})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (c *ExchangeChaincode) getAccountByIdentity(identity string) (*Account, error) {
+	bb, err := c.getCompositeValue("Account~identity~uuid", []string{identity}, 1)
+	if err != nil {
+		return nil, err
+	}
+	if len(bb) == 0 {
+		return nil, nil
+	}
+
+	account := new(Account)
+	err = json.Unmarshal(bb[0], account)
+	if err != nil {
+		return nil, err
+	}
+	return account, nil
+}
+
+// getCallerAccount returns the name of the account bound to the tx creator.
+// Roles, issuers and escrow approvers are creator identities, everything holding assets is an account.
+func (c *ExchangeChaincode) getCallerAccount() (string, error) {
+	caller, err := c.getCaller()
+	if err != nil {
+		return "", err
+	}
+
+	account, err := c.getAccountByIdentity(caller)
+	if err != nil {
+		return "", fmt.Errorf("Failed retrieving the account of [%s]: [%s]", caller, err)
+	}
+	if account == nil {
+		return "", fmt.Errorf("The identity [%s] is not bound to an account", caller)
+	}
+	return account.Name, nil
+}
+
+func (c *ExchangeChaincode) getAccount(name string) (*Account, error) {
+	bb, err := c.getCompositeValue("Account~name~uuid", []string{name}, 1)
+	if err != nil {
+		return nil, err
+	}
+	if len(bb) == 0 {
+		return nil, nil
+	}
+
+	account := new(Account)
+	err = json.Unmarshal(bb[0], account)
+	if err != nil {
+		return nil, err
+	}
+	return account, nil
+}
+
+// checkAccountActive check the account is registered and active
+func (c *ExchangeChaincode) checkAccountActive(name string) (error, ErrType) {
+	account, err := c.getAccount(name)
+	if err != nil {
+		return fmt.Errorf("Failed retrieving account [%s]: [%s]", name, err), CheckErr
+	}
+	if account == nil || account.UUID == "" {
+		return fmt.Errorf("The account [%s] is not registered", name), CheckErr
+	}
+	if account.Status != AccountActive {
+		return fmt.Errorf("The account [%s] is %s", name, account.Status), CheckErr
+	}
+	return nil, ErrType("")
+}
+
+// setAccountStatus set status and kyc level of an account, requires the compliance role
+// args: account, status, kyc level
+func (c *ExchangeChaincode) setAccountStatus() pb.Response {
+	myLogger.Debug("Set Account Status...")
+
+	if len(c.args) != 3 {
+		return shim.Error("Incorrect number of arguments. Expecting 3")
+	}
+
+	name := c.args[0]
+	status := c.args[1]
+	if !containsString(accountStatus, status) {
+		return shim.Error(fmt.Sprintf("Unknown account status [%s]", status))
+	}
+	kycLevel, err := strconv.Atoi(c.args[2])
+	if err != nil || kycLevel < 0 {
+		return shim.Error("The kyc level must be >= 0")
+	}
+
+	caller, err := c.checkRole(RoleCompliance)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	account, err := c.getAccount(name)
+	if err != nil {
+		myLogger.Errorf("setAccountStatus error1:%s", err)
+		return shim.Error(fmt.Sprintf("Failed retrieving account [%s]: [%s]", name, err))
+	}
+	if account == nil {
+		account = &Account{Name: name}
+	}
+	if account.Status == AccountClosed {
+		return shim.Error(fmt.Sprintf("The account [%s] is closed", name))
+	}
+
+	account.Status = status
+	account.KYCLevel = kycLevel
+	account.UpdateTime = c.txTime()
+	account.UpdatedBy = caller
+	err = c.putAccount(account)
+	if err != nil {
+		myLogger.Errorf("setAccountStatus error2:%s", err)
+		return shim.Error(err.Error())
+	}
+
+	myLogger.Debug("Set Account Status...done")
+	return shim.Success(nil)
+}
+
+// setAccountIdentity bind the creator identity acting for an account, requires the compliance role.
+// An empty identity unbinds the account.
+// args: account, identity (mspid/common name)
+func (c *ExchangeChaincode) setAccountIdentity() pb.Response {
+	myLogger.Debug("Set Account Identity...")
+
+	if len(c.args) != 2 {
+		return shim.Error("Incorrect number of arguments. Expecting 2")
+	}
+	name := c.args[0]
+	identity := c.args[1]
+
+	caller, err := c.checkRole(RoleCompliance)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	account, err := c.getAccount(name)
+	if err != nil {
+		myLogger.Errorf("setAccountIdentity error1:%s", err)
+		return shim.Error(fmt.Sprintf("Failed retrieving account [%s]: [%s]", name, err))
+	}
+	if account == nil {
+		return shim.Error(fmt.Sprintf("The account [%s] is not registered", name))
+	}
+
+	if identity != "" {
+		bound, err := c.getAccountByIdentity(identity)
+		if err != nil {
+			myLogger.Errorf("setAccountIdentity error2:%s", err)
+			return shim.Error(err.Error())
+		}
+		if bound != nil && bound.UUID != account.UUID {
+			return shim.Error(fmt.Sprintf("The identity [%s] is bound to account [%s]", identity, bound.Name))
+		}
+	}
+	if account.Identity != "" {
+		err = c.delCompositeValue("Account~identity~uuid", []string{account.Identity, account.UUID})
+		if err != nil {
+			myLogger.Errorf("setAccountIdentity error3:%s", err)
+			return shim.Error(err.Error())
+		}
+	}
+
+	account.Identity = identity
+	account.UpdateTime = c.txTime()
+	account.UpdatedBy = caller
+	err = c.putAccount(account)
+	if err != nil {
+		myLogger.Errorf("setAccountIdentity error4:%s", err)
+		return shim.Error(err.Error())
+	}
+
+	myLogger.Debug("Set Account Identity...done")
+	return shim.Success(nil)
+}
+
+// queryAccount
+// args: account
+func (c *ExchangeChaincode) queryAccount() pb.Response {
+	myLogger.Debug("queryAccount...")
+
+	if len(c.args) != 1 {
+		return shim.Error("Incorrect number of arguments. Expecting 1")
+	}
+
+	account, err := c.getAccount(c.args[0])
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if account == nil {
+		return shim.Error(NoDataErr.Error())
+	}
+
+	payload, err := json.Marshal(account)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	return shim.Success(payload)
+}
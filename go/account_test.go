@@ -0,0 +1,93 @@
+package main
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+func TestTransferByBoundIdentity(t *testing.T) {
+	e := newTestEnv(t)
+	e.openAccount("alice")
+	e.openAccount("bob")
+	e.fund("alice", "EUR", 100)
+
+	// an identity without an account can't spend
+	if msg := e.mustFail("mallory", "transfer", "EUR", "bob", "10", ""); !strings.Contains(msg, "not bound") {
+		t.Fatalf("unbound transfer: %s", msg)
+	}
+
+	e.mustInvoke("alice", "transfer", "EUR", "bob", "10", "")
+	if got := e.asset("bob", "EUR").Count; got != 10 {
+		t.Fatalf("bob EUR: got %d, expecting 10", got)
+	}
+
+	// an identity acts for one account only
+	e.mustFail("root", "setAccountIdentity", "bob", "Org1MSP/alice")
+	e.mustFail("alice", "setAccountIdentity", "alice", "Org1MSP/mallory")
+
+	// rebinding moves the spending right
+	e.mustInvoke("root", "setAccountIdentity", "alice", "Org1MSP/alice2")
+	e.mustFail("alice", "transfer", "EUR", "bob", "10", "")
+	e.mustInvoke("alice2", "transfer", "EUR", "bob", "10", "")
+	if got := e.asset("alice", "EUR").Count; got != 80 {
+		t.Fatalf("alice EUR: got %d, expecting 80", got)
+	}
+}
+
+func TestInitWithoutCreator(t *testing.T) {
+	stub := shim.NewMockStub("exchange", new(ExchangeChaincode))
+	stub.MockTransactionStart("init")
+	r := new(ExchangeChaincode).Init(stub)
+	stub.MockTransactionEnd("init")
+	if r.Status == shim.OK {
+		t.Fatal("Init without a creator must fail")
+	}
+}
+
+func TestMigrateAccounts(t *testing.T) {
+	e := newTestEnv(t)
+	e.openAccount("alice")
+
+	// holders written before the registry
+	c := &ExchangeChaincode{stub: e.stub}
+	e.stub.MockTransactionStart("legacy")
+	for _, owner := range []string{"old1", "old2", "old3"} {
+		err := c.putAsset(&Asset{Owner: owner, Currency: "EUR", Count: 10})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	e.stub.MockTransactionEnd("legacy")
+	e.fund("alice", "EUR", 10)
+
+	e.mustFail("alice", "migrate", MigrateAccounts, "", "2")
+
+	var pages, updated int
+	bookmark := ""
+	for {
+		result := new(MigrateResult)
+		err := json.Unmarshal(e.mustInvoke("root", "migrate", MigrateAccounts, bookmark, "2"), result)
+		if err != nil {
+			t.Fatal(err)
+		}
+		pages++
+		updated += result.Updated
+		bookmark = result.Bookmark
+		if bookmark == "" {
+			break
+		}
+	}
+	if pages != 2 || updated != 3 {
+		t.Fatalf("got %d pages and %d accounts, expecting 2 and 3", pages, updated)
+	}
+
+	for _, owner := range []string{"old1", "old2", "old3", "alice"} {
+		err, _ := c.checkAccountActive(owner)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+}
@@ -0,0 +1,412 @@
+package main
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/hyperledger/fabric/bccsp"
+	"github.com/hyperledger/fabric/bccsp/factory"
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	pb "github.com/hyperledger/fabric/protos/peer"
+)
+
+// transient keys of a confidential exchange, the transient map is not written to the ledger
+const (
+	TransientOrders = "orders" // exchange orders, same format as the exchange argument
+	TransientKey    = "key"    // AES key (16, 24 or 32 bytes) sealing the orders
+)
+
+// SealedOrder order pair of a confidential exchange, stored under both order uuids instead of the orders.
+// Ciphertext is AES-GCM of the json of the pair with the order uuid as additional data.
+type SealedOrder struct {
+	DocType    string `json:"docType,omitempty"`
+	UUID       string `json:"uuid"`
+	Nonce      string `json:"nonce"`
+	Ciphertext string `json:"ciphertext"`
+	SealedTime int64  `json:"sealedTime"`
+}
+
+// SealedFills RawFills of a raw order of a confidential exchange, stored under SealedFills~mac where mac is the
+// HMAC-SHA256 of the owner, currencies and raw order under the transient key. Ciphertext is AES-GCM of the json of
+// the fills with the mac as additional data, so the fills of a raw order must be sealed with the same key.
+type SealedFills struct {
+	DocType    string `json:"docType,omitempty"`
+	Nonce      string `json:"nonce"`
+	Ciphertext string `json:"ciphertext"`
+}
+
+// SealKey binding of a raw order to the key of its first sealed fill, stored under SealKey~owner~currency~raw.
+// Mac is the HMAC-SHA256 of the raw order under the key. The fills sealed with another key or in the clear
+// could not be summed with the sealed ones, so they are rejected.
+type SealKey struct {
+	DocType string `json:"docType,omitempty"`
+	Mac     string `json:"mac"`
+}
+
+// sealedPair plaintext of a SealedOrder
+type sealedPair struct {
+	BuyOrder  *Order `json:"buyOrder"`
+	SellOrder *Order `json:"sellOrder"`
+}
+
+func sha256Digest(msg []byte) ([]byte, error) {
+	return factory.GetDefault().Hash(msg, &bccsp.SHA256Opts{})
+}
+
+// sealNonce nonce of a record sealed by the tx, the same on every endorser.
+// The tx id and the record id make it unique per key.
+func (c *ExchangeChaincode) sealNonce(aead cipher.AEAD, id string) ([]byte, error) {
+	seed, err := sha256Digest([]byte(c.stub.GetTxID() + "\x00" + id))
+	if err != nil {
+		return nil, err
+	}
+	return seed[:aead.NonceSize()], nil
+}
+
+// sealedFillsMac index of the fills of a raw order, the owner is not readable without the key
+func sealedFillsMac(key []byte, owner, srcCurrency, desCurrency, rawOrder string) string {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(owner + "\x00" + srcCurrency + "\x00" + desCurrency + "\x00" + rawOrder))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// sealKeyMac mac binding the key to the raw order, apart from the mac of its fills
+func sealKeyMac(key []byte, owner, currency, rawOrder string) string {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte("key\x00" + owner + "\x00" + currency + "\x00" + rawOrder))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// getSealKey the key binding of the raw order, nil before its first sealed fill
+func (c *ExchangeChaincode) getSealKey(owner, currency, rawOrder string) (*SealKey, error) {
+	stateKey, err := c.stub.CreateCompositeKey("SealKey~owner~currency~raw", []string{owner, currency, rawOrder})
+	if err != nil {
+		return nil, err
+	}
+	keyByte, err := c.stub.GetState(stateKey)
+	if err != nil {
+		return nil, err
+	}
+	if keyByte == nil {
+		return nil, nil
+	}
+
+	sealKey := new(SealKey)
+	err = json.Unmarshal(keyByte, sealKey)
+	if err != nil {
+		return nil, err
+	}
+	return sealKey, nil
+}
+
+// checkSealKey check a raw order with sealed fills is filled with the key of its first sealed fill
+func (c *ExchangeChaincode) checkSealKey(order *Order) (error, ErrType) {
+	sealKey, err := c.getSealKey(order.Account, order.SrcCurrency, order.RawUUID)
+	if err != nil {
+		return fmt.Errorf("Failed retrieving the key of order [%s]: [%s]", order.RawUUID, err), WorldStateErr
+	}
+	if sealKey == nil {
+		return nil, ErrType("")
+	}
+	if c.sealKey == nil {
+		return fmt.Errorf("The fills of order [%s] are sealed, it can't be filled in the clear", order.RawUUID), CheckErr
+	}
+	mac := sealKeyMac(c.sealKey, order.Account, order.SrcCurrency, order.RawUUID)
+	if !hmac.Equal([]byte(mac), []byte(sealKey.Mac)) {
+		return fmt.Errorf("The fills of order [%s] are sealed with another key", order.RawUUID), CheckErr
+	}
+	return nil, ErrType("")
+}
+
+// putSealKey bind the key to the raw order on its first sealed fill
+func (c *ExchangeChaincode) putSealKey(key []byte, order *Order) error {
+	sealKey, err := c.getSealKey(order.Account, order.SrcCurrency, order.RawUUID)
+	if err != nil || sealKey != nil {
+		return err
+	}
+
+	keyJson, err := json.Marshal(&SealKey{
+		DocType: DocSealKey,
+		Mac:     sealKeyMac(key, order.Account, order.SrcCurrency, order.RawUUID),
+	})
+	if err != nil {
+		return err
+	}
+	stateKey, err := c.stub.CreateCompositeKey("SealKey~owner~currency~raw", []string{order.Account, order.SrcCurrency, order.RawUUID})
+	if err != nil {
+		return err
+	}
+	return c.stub.PutState(stateKey, keyJson)
+}
+
+// getSealedFills open the fills of the raw order sealed with key, empty before its first fill
+func (c *ExchangeChaincode) getSealedFills(key []byte, owner, srcCurrency, desCurrency, rawOrder string) (*RawFills, error) {
+	mac := sealedFillsMac(key, owner, srcCurrency, desCurrency, rawOrder)
+	stateKey, err := c.stub.CreateCompositeKey("SealedFills~mac", []string{mac})
+	if err != nil {
+		return nil, err
+	}
+	sealedByte, err := c.stub.GetState(stateKey)
+	if err != nil {
+		return nil, err
+	}
+	fills := new(RawFills)
+	if sealedByte == nil {
+		return fills, nil
+	}
+
+	sealed := new(SealedFills)
+	err = json.Unmarshal(sealedByte, sealed)
+	if err != nil {
+		return nil, err
+	}
+	plaintext, err := openSealed(key, sealed.Nonce, sealed.Ciphertext, mac)
+	if err != nil {
+		return nil, err
+	}
+	err = json.Unmarshal(plaintext, fills)
+	if err != nil {
+		return nil, err
+	}
+	return fills, nil
+}
+
+// addSealedFill add the fill of the order to the sealed fills of its raw order
+func (c *ExchangeChaincode) addSealedFill(key []byte, order *Order) error {
+	fills, err := c.getSealedFills(key, order.Account, order.SrcCurrency, order.DesCurrency, order.RawUUID)
+	if err != nil {
+		return err
+	}
+	fills.FinalCost += order.FinalCost
+	fills.DesCount += order.DesCount
+	fills.Fills++
+	plaintext, err := json.Marshal(fills)
+	if err != nil {
+		return err
+	}
+
+	aead, err := newGCM(key)
+	if err != nil {
+		return err
+	}
+	mac := sealedFillsMac(key, order.Account, order.SrcCurrency, order.DesCurrency, order.RawUUID)
+	nonce, err := c.sealNonce(aead, mac)
+	if err != nil {
+		return err
+	}
+
+	sealedJson, err := json.Marshal(&SealedFills{
+		DocType:    DocSealedFills,
+		Nonce:      hex.EncodeToString(nonce),
+		Ciphertext: base64.StdEncoding.EncodeToString(aead.Seal(nil, nonce, plaintext, []byte(mac))),
+	})
+	if err != nil {
+		return err
+	}
+	stateKey, err := c.stub.CreateCompositeKey("SealedFills~mac", []string{mac})
+	if err != nil {
+		return err
+	}
+	return c.stub.PutState(stateKey, sealedJson)
+}
+
+// getTransientOrders returns the orders and the key passed in the transient map, nil when the exchange is public
+func (c *ExchangeChaincode) getTransientOrders() ([]byte, []byte, error) {
+	transient, err := c.stub.GetTransient()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	orders := transient[TransientOrders]
+	if len(orders) == 0 {
+		return nil, nil, nil
+	}
+	key := transient[TransientKey]
+	if len(key) == 0 {
+		return nil, nil, errors.New("The transient key is missing")
+	}
+	return orders, key, nil
+}
+
+// newGCM AES-GCM with the transient key
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// putSealedTxLog the confidential counterpart of putTxLog.
+// Only the sealed pair is stored, balances are still settled by execTx and stay in the clear on the assets
+// and in the statements.
+func (c *ExchangeChaincode) putSealedTxLog(key []byte, buyOrder, sellOrder *Order) error {
+	buyOrder.DocType = DocOrder
+	sellOrder.DocType = DocOrder
+	plaintext, err := json.Marshal(&sealedPair{BuyOrder: buyOrder, SellOrder: sellOrder})
+	if err != nil {
+		return err
+	}
+
+	aead, err := newGCM(key)
+	if err != nil {
+		return err
+	}
+
+	for _, order := range []*Order{buyOrder, sellOrder} {
+		uuid := order.UUID
+		nonce, err := c.sealNonce(aead, uuid)
+		if err != nil {
+			return err
+		}
+
+		sealed := &SealedOrder{
+			DocType:    DocSealedOrder,
+			UUID:       uuid,
+			Nonce:      hex.EncodeToString(nonce),
+			Ciphertext: base64.StdEncoding.EncodeToString(aead.Seal(nil, nonce, plaintext, []byte(uuid))),
+			SealedTime: c.txTime(),
+		}
+		sealedJson, err := json.Marshal(sealed)
+		if err != nil {
+			return err
+		}
+		err = c.stub.PutState(uuid, sealedJson)
+		if err != nil {
+			return err
+		}
+
+		// computeBalance sums the fills of the raw order
+		err = c.addSealedFill(key, order)
+		if err != nil {
+			return err
+		}
+		err = c.putSealKey(key, order)
+		if err != nil {
+			return err
+		}
+
+		// the movements are in the clear on the assets, the counterparty stays in the sealed pair
+		err = c.putFillStatements(order, "")
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (c *ExchangeChaincode) getSealedOrder(uuid string) (*SealedOrder, error) {
+	sealedByte, err := c.stub.GetState(uuid)
+	if err != nil {
+		return nil, err
+	}
+	if sealedByte == nil {
+		return nil, nil
+	}
+
+	sealed := new(SealedOrder)
+	err = json.Unmarshal(sealedByte, sealed)
+	if err != nil {
+		return nil, err
+	}
+	if sealed.DocType != DocSealedOrder {
+		return nil, nil
+	}
+	return sealed, nil
+}
+
+// openSealed decrypt a sealed record, the tag authenticates it with its additional data
+func openSealed(key []byte, nonceHex, ciphertextBase64, data string) ([]byte, error) {
+	aead, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	nonce, err := hex.DecodeString(nonceHex)
+	if err != nil {
+		return nil, err
+	}
+	if len(nonce) != aead.NonceSize() {
+		return nil, errors.New("The nonce of the sealed record is invalid")
+	}
+	ciphertext, err := base64.StdEncoding.DecodeString(ciphertextBase64)
+	if err != nil {
+		return nil, err
+	}
+
+	plaintext, err := aead.Open(nil, nonce, ciphertext, []byte(data))
+	if err != nil {
+		return nil, errors.New("Failed decrypting the sealed record, wrong key")
+	}
+	return plaintext, nil
+}
+
+// openSealedOrder decrypt the sealed pair
+func openSealedOrder(key []byte, sealed *SealedOrder) (*sealedPair, error) {
+	plaintext, err := openSealed(key, sealed.Nonce, sealed.Ciphertext, sealed.UUID)
+	if err != nil {
+		return nil, err
+	}
+
+	pair := new(sealedPair)
+	err = json.Unmarshal(plaintext, pair)
+	if err != nil {
+		return nil, err
+	}
+	return pair, nil
+}
+
+// querySealedOrder decrypt the order pair of a confidential exchange with the key in the transient map.
+// Only the accounts of the pair and admins can read it.
+// args: order uuid
+func (c *ExchangeChaincode) querySealedOrder() pb.Response {
+	myLogger.Debug("querySealedOrder...")
+
+	if len(c.args) != 1 {
+		return shim.Error("Incorrect number of arguments. Expecting 1")
+	}
+
+	transient, err := c.stub.GetTransient()
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	key := transient[TransientKey]
+	if len(key) == 0 {
+		return shim.Error("The transient key is missing")
+	}
+
+	sealed, err := c.getSealedOrder(c.args[0])
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if sealed == nil {
+		return shim.Error(fmt.Sprintf("The sealed order [%s] does not exist", c.args[0]))
+	}
+
+	pair, err := openSealedOrder(key, sealed)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	// an unbound caller needs a role
+	owner, _ := c.getCallerAccount()
+	if owner == "" || owner != pair.BuyOrder.Account && owner != pair.SellOrder.Account {
+		_, err = c.checkRole()
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+	}
+
+	payload, err := json.Marshal(pair)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	return shim.Success(payload)
+}
@@ -0,0 +1,159 @@
+package main
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+func TestSealedFills(t *testing.T) {
+	e := newTestEnv(t)
+	e.openAccount("alice")
+	e.openAccount("bob")
+	e.fund("alice", "EUR", 1000)
+	e.fund("bob", "BTC", 1000)
+	e.lockOrder("alice", "EUR", "r1", 100)
+	e.lockOrder("bob", "BTC", "s1", 30)
+	e.lockOrder("bob", "BTC", "s2", 40)
+
+	key := []byte("0123456789abcdef")
+	exchange := func(matches []map[string]Order) *BatchResult {
+		b, err := json.Marshal(matches)
+		if err != nil {
+			t.Fatal(err)
+		}
+		r := e.invokeTransient("root", map[string][]byte{TransientOrders: b, TransientKey: key}, "exchange")
+		if r.Status != shim.OK {
+			t.Fatalf("exchange: %s", r.Message)
+		}
+		return e.batch("chaincode_exchange")
+	}
+
+	// a partial fill then the last fill of the buy-all raw order
+	first := match("alice", "bob", "EUR", "BTC", 30, 30, "1")
+	first[0]["buyOrder"] = Order{UUID: "r1-1", RawUUID: "r1", Account: "alice", SrcCurrency: "EUR", SrcCount: 100,
+		DesCurrency: "BTC", DesCount: 30, FinalCost: 30, IsBuyAll: true}
+	if batch := exchange(first); len(batch.Success) != 1 {
+		t.Fatalf("first fill: %+v", batch)
+	}
+	last := match("alice", "bob", "EUR", "BTC", 40, 40, "2")
+	last[0]["buyOrder"] = Order{UUID: "r1", RawUUID: "r1", Account: "alice", SrcCurrency: "EUR", SrcCount: 100,
+		DesCurrency: "BTC", DesCount: 40, FinalCost: 40, IsBuyAll: true}
+	if batch := exchange(last); len(batch.Success) != 1 {
+		t.Fatalf("last fill: %+v", batch)
+	}
+
+	// the rest of the lock is released
+	if a := e.asset("alice", "EUR"); a.Count != 930 || a.LockCount != 0 {
+		t.Fatalf("alice EUR: %+v", a)
+	}
+
+	// no cleartext owner index, no amount in the clear
+	if got := e.indexedUUIDs("Order~owner~src~des~raw~uuid", "alice"); len(got) != 0 {
+		t.Fatalf("cleartext index of the sealed fills: %v", got)
+	}
+	sealed, err := e.stub.GetState("r1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(sealed), "finalCost") || strings.Contains(string(sealed), "hash") {
+		t.Fatalf("sealed order: %s", sealed)
+	}
+
+	r := e.invokeTransient("alice", map[string][]byte{TransientKey: key}, "querySealedOrder", "r1")
+	if r.Status != shim.OK || !strings.Contains(string(r.Payload), `"finalCost":40`) {
+		t.Fatalf("querySealedOrder: %s %s", r.Message, r.Payload)
+	}
+}
+
+func TestSealKeyBinding(t *testing.T) {
+	e := newTestEnv(t)
+	e.openAccount("alice")
+	e.openAccount("bob")
+	e.fund("alice", "EUR", 1000)
+	e.fund("bob", "BTC", 1000)
+	e.lockOrder("alice", "EUR", "r1", 100)
+	for _, s := range []string{"s1", "s2", "s3", "s4"} {
+		e.lockOrder("bob", "BTC", s, 10)
+	}
+
+	exchange := func(key []byte, suffix string) *BatchResult {
+		matches := match("alice", "bob", "EUR", "BTC", 25, 10, suffix)
+		matches[0]["buyOrder"] = Order{UUID: "r1-" + suffix, RawUUID: "r1", Account: "alice", SrcCurrency: "EUR",
+			SrcCount: 100, DesCurrency: "BTC", DesCount: 10, FinalCost: 25}
+		b, err := json.Marshal(matches)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if key == nil {
+			e.mustInvoke("root", "exchange", string(b))
+		} else {
+			r := e.invokeTransient("root", map[string][]byte{TransientOrders: b, TransientKey: key}, "exchange")
+			if r.Status != shim.OK {
+				t.Fatalf("exchange: %s", r.Message)
+			}
+		}
+		return e.batch("chaincode_exchange")
+	}
+
+	key := []byte("0123456789abcdef")
+	if batch := exchange(key, "1"); len(batch.Success) != 1 {
+		t.Fatalf("first fill: %+v", batch)
+	}
+
+	// the fills sealed with another key or in the clear would not be counted with the first one
+	if batch := exchange([]byte("fedcba9876543210"), "2"); len(batch.Fail) != 1 || !strings.Contains(batch.Fail[0].Info, "another key") {
+		t.Fatalf("fill with another key: %+v", batch)
+	}
+	if batch := exchange(nil, "3"); len(batch.Fail) != 1 || !strings.Contains(batch.Fail[0].Info, "sealed") {
+		t.Fatalf("fill in the clear: %+v", batch)
+	}
+	if batch := exchange(key, "4"); len(batch.Success) != 1 {
+		t.Fatalf("second fill: %+v", batch)
+	}
+
+	c := &ExchangeChaincode{stub: e.stub, sealKey: key}
+	fills, err := c.getRawFills("alice", "EUR", "BTC", "r1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fills.FinalCost != 50 || fills.Fills != 2 {
+		t.Fatalf("fills of r1: %+v", fills)
+	}
+}
+
+func TestSealedFillStatements(t *testing.T) {
+	e := newTestEnv(t)
+	e.now = 1500000000
+	e.openAccount("alice")
+	e.openAccount("bob")
+	e.fund("alice", "EUR", 1000)
+	e.fund("bob", "BTC", 1000)
+	e.lockOrder("alice", "EUR", "b1", 100)
+	e.lockOrder("bob", "BTC", "s1", 40)
+
+	e.now += 100
+	b, err := json.Marshal(match("alice", "bob", "EUR", "BTC", 100, 40, "1"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := e.invokeTransient("root", map[string][]byte{TransientOrders: b, TransientKey: []byte("0123456789abcdef")}, "exchange")
+	if r.Status != shim.OK {
+		t.Fatalf("exchange: %s", r.Message)
+	}
+
+	// the opening is derived from the asset, the sealed fill must be in the statement
+	statement := new(Statement)
+	err = json.Unmarshal(e.mustInvoke("alice", "queryStatement", "alice", "EUR", itoa(e.now-50), itoa(e.now+50)), statement)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if statement.Opening.Balance != 900 || statement.Opening.LockBalance != 100 || statement.Closing.LockBalance != 0 {
+		t.Fatalf("statement: %+v", statement)
+	}
+	if len(statement.Entries) != 1 || statement.Entries[0].Kind != StatementFill || statement.Entries[0].Counterparty != "" {
+		t.Fatalf("sealed fill entries: %+v", statement.Entries)
+	}
+}
@@ -0,0 +1,418 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	pb "github.com/hyperledger/fabric/protos/peer"
+)
+
+const (
+	EscrowPending  = "pending"
+	EscrowReleased = "released"
+	EscrowRefunded = "refunded"
+)
+
+// Escrow escrow record, the amount stays in the owner's LockCount until released to the beneficiary or refunded
+type Escrow struct {
+	DocType     string   `json:"docType,omitempty"`
+	UUID        string   `json:"uuid"`
+	Owner       string   `json:"owner"`
+	Currency    string   `json:"currency"`
+	Amount      int64    `json:"amount"`
+	Beneficiary string   `json:"beneficiary"`
+	Approvers   []string `json:"approvers"`
+	Threshold   int      `json:"threshold"`
+	Approvals   []string `json:"approvals"`
+	Deadline    int64    `json:"deadline"`
+	Status      string   `json:"status"`
+	CreateTime  int64    `json:"createTime"`
+	FinishTime  int64    `json:"finishTime"`
+}
+
+func (c *ExchangeChaincode) putEscrow(escrow *Escrow) error {
+	if escrow.UUID == "" {
+		escrow.UUID = GenerateUUID()
+	}
+	escrow.DocType = DocEscrow
+	r, err := json.Marshal(escrow)
+	if err != nil {
+		return err
+	}
+
+	err = c.stub.PutState(escrow.UUID, r)
+	if err != nil {
+		return err
+	}
+
+	err = c.putCompositeValue("Escrow~owner~uuid", []string{escrow.Owner, escrow.UUID})
+	if err != nil {
+		return err
+	}
+
+	err = c.putCompositeValue("Escrow~beneficiary~uuid", []string{escrow.Beneficiary, escrow.UUID})
+	if err != nil {
+		return err
+	}
+
+	for _, approver := range escrow.Approvers {
+		err = c.putCompositeValue("Escrow~approver~uuid", []string{approver, escrow.UUID})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (c *ExchangeChaincode) getEscrow(key string) (*Escrow, error) {
+	escrowByte, err := c.stub.GetState(key)
+	if err != nil {
+		return nil, err
+	}
+	if escrowByte == nil {
+		return nil, nil
+	}
+
+	escrow := new(Escrow)
+	err = json.Unmarshal(escrowByte, escrow)
+	if err != nil {
+		return nil, err
+	}
+	return escrow, nil
+}
+
+func (c *ExchangeChaincode) getEscrowsByIndex(indexName, user string) ([]*Escrow, error) {
+	bb, err := c.getCompositeValue(indexName, []string{user}, 1)
+	if err != nil {
+		return nil, err
+	}
+
+	var escrows []*Escrow
+	for _, v := range bb {
+		escrow := new(Escrow)
+		err = json.Unmarshal(v, escrow)
+		if err != nil {
+			return nil, err
+		}
+		escrows = append(escrows, escrow)
+	}
+	return escrows, nil
+}
+
+func (c *ExchangeChaincode) getPendingEscrow(id string) (*Escrow, error) {
+	escrow, err := c.getEscrow(id)
+	if err != nil {
+		return nil, fmt.Errorf("Failed retrieving escrow [%s]: [%s]", id, err)
+	}
+	if escrow == nil || escrow.UUID == "" {
+		return nil, fmt.Errorf("The escrow [%s] does not exist", id)
+	}
+	if escrow.Status != EscrowPending {
+		return nil, fmt.Errorf("The escrow [%s] is %s", id, escrow.Status)
+	}
+	return escrow, nil
+}
+
+func (c *ExchangeChaincode) setEscrowEvent(escrow *Escrow) error {
+	payload, err := json.Marshal(escrow)
+	if err != nil {
+		return err
+	}
+	return c.stub.SetEvent("chaincode_escrow", payload)
+}
+
+func containsString(ss []string, s string) bool {
+	for _, v := range ss {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+// createEscrow lock owner asset into an escrow released by M of N approvers, the owner is the account bound to the caller
+// args: json{owner, currency, amount, beneficiary, approvers, threshold, deadline}
+func (c *ExchangeChaincode) createEscrow() pb.Response {
+	myLogger.Debug("Create Escrow...")
+
+	if len(c.args) != 1 {
+		return shim.Error("Incorrect number of arguments. Expecting 1")
+	}
+
+	escrow := new(Escrow)
+	err := json.Unmarshal([]byte(c.args[0]), escrow)
+	if err != nil {
+		myLogger.Errorf("createEscrow error1:%s", err)
+		return shim.Error(fmt.Sprintf("Failed unmarshalling escrow data: [%s]", err))
+	}
+
+	now := c.txTime()
+	if escrow.Amount <= 0 {
+		return shim.Error("The escrow amount must be > 0")
+	}
+	if escrow.Beneficiary == "" || escrow.Beneficiary == escrow.Owner {
+		return shim.Error("The escrow beneficiary is invalid")
+	}
+	var approvers []string
+	for _, v := range escrow.Approvers {
+		if v != "" && !containsString(approvers, v) {
+			approvers = append(approvers, v)
+		}
+	}
+	if escrow.Threshold <= 0 || escrow.Threshold > len(approvers) {
+		return shim.Error(fmt.Sprintf("The escrow threshold must be between 1 and %d", len(approvers)))
+	}
+	if escrow.Deadline <= now {
+		return shim.Error("The escrow deadline must be later than the tx time")
+	}
+
+	caller, err := c.getCallerAccount()
+	if err != nil {
+		myLogger.Errorf("createEscrow error4:%s", err)
+		return shim.Error(err.Error())
+	}
+	if caller != escrow.Owner {
+		return shim.Error(fmt.Sprintf("The escrow owner [%s] is not the account of the caller", escrow.Owner))
+	}
+	err, _ = c.checkAccountActive(escrow.Owner)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	err, _ = c.checkAccountActive(escrow.Beneficiary)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	err, _ = c.checkCurrencyOpen(escrow.Currency, PauseTransfer)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	escrow.UUID = GenerateUUID()
+	escrow.Approvers = approvers
+	escrow.Approvals = nil
+	escrow.Status = EscrowPending
+	escrow.CreateTime = now
+	escrow.FinishTime = 0
+
+	err, errType := c.lockOrUnlockBalance(escrow.Owner, escrow.Currency, escrow.UUID, escrow.Amount, true)
+	if errType == WorldStateErr {
+		myLogger.Errorf("createEscrow error2:%s", err)
+		return shim.Error(err.Error())
+	} else if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	err = c.putEscrow(escrow)
+	if err != nil {
+		myLogger.Errorf("createEscrow error3:%s", err)
+		return shim.Error(err.Error())
+	}
+
+	err = c.setEscrowEvent(escrow)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	myLogger.Debug("Create Escrow...done")
+	return shim.Success([]byte(escrow.UUID))
+}
+
+// approveEscrow approve the escrow as the tx creator, who must be one of the approvers
+// args: escrow id
+func (c *ExchangeChaincode) approveEscrow() pb.Response {
+	myLogger.Debug("Approve Escrow...")
+
+	if len(c.args) != 1 {
+		return shim.Error("Incorrect number of arguments. Expecting 1")
+	}
+
+	escrow, err := c.getPendingEscrow(c.args[0])
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	caller, err := c.getCaller()
+	if err != nil {
+		myLogger.Errorf("approveEscrow error1:%s", err)
+		return shim.Error(err.Error())
+	}
+	if !containsString(escrow.Approvers, caller) {
+		return shim.Error(fmt.Sprintf("[%s] is not an approver of the escrow", caller))
+	}
+	if containsString(escrow.Approvals, caller) {
+		return shim.Success(nil)
+	}
+
+	escrow.Approvals = append(escrow.Approvals, caller)
+	err = c.putEscrow(escrow)
+	if err != nil {
+		myLogger.Errorf("approveEscrow error2:%s", err)
+		return shim.Error(err.Error())
+	}
+
+	myLogger.Debug("Approve Escrow...done")
+	return shim.Success(nil)
+}
+
+// releaseEscrow move the escrow amount to the beneficiary once the threshold of approvals is reached
+// args: escrow id
+func (c *ExchangeChaincode) releaseEscrow() pb.Response {
+	myLogger.Debug("Release Escrow...")
+
+	if len(c.args) != 1 {
+		return shim.Error("Incorrect number of arguments. Expecting 1")
+	}
+
+	escrow, err := c.getPendingEscrow(c.args[0])
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if len(escrow.Approvals) < escrow.Threshold {
+		return shim.Error(fmt.Sprintf("The escrow has %d of %d approvals", len(escrow.Approvals), escrow.Threshold))
+	}
+
+	// refunds stay allowed so that a paused currency can be returned to the owner
+	err, _ = c.checkCurrencyOpen(escrow.Currency, PauseTransfer)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	err, errType := c.settleLocked(escrow.Owner, escrow.Currency, escrow.UUID, escrow.Amount, escrow.Beneficiary)
+	if errType == WorldStateErr {
+		myLogger.Errorf("releaseEscrow error1:%s", err)
+		return shim.Error(err.Error())
+	} else if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	escrow.Status = EscrowReleased
+	escrow.FinishTime = c.txTime()
+	err = c.putEscrow(escrow)
+	if err != nil {
+		myLogger.Errorf("releaseEscrow error2:%s", err)
+		return shim.Error(err.Error())
+	}
+
+	err = c.setEscrowEvent(escrow)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	myLogger.Debug("Release Escrow...done")
+	return shim.Success(nil)
+}
+
+// refundEscrow unlock the escrow amount back to the owner after the deadline if it was not approved
+// args: escrow id
+func (c *ExchangeChaincode) refundEscrow() pb.Response {
+	myLogger.Debug("Refund Escrow...")
+
+	if len(c.args) != 1 {
+		return shim.Error("Incorrect number of arguments. Expecting 1")
+	}
+
+	escrow, err := c.getPendingEscrow(c.args[0])
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	now := c.txTime()
+	if now < escrow.Deadline {
+		return shim.Error(fmt.Sprintf("The escrow [%s] is not past the deadline", escrow.UUID))
+	}
+	if len(escrow.Approvals) >= escrow.Threshold {
+		return shim.Error(fmt.Sprintf("The escrow [%s] is approved", escrow.UUID))
+	}
+
+	err, errType := c.lockOrUnlockBalance(escrow.Owner, escrow.Currency, escrow.UUID, escrow.Amount, false)
+	if errType == WorldStateErr {
+		myLogger.Errorf("refundEscrow error1:%s", err)
+		return shim.Error(err.Error())
+	} else if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	escrow.Status = EscrowRefunded
+	escrow.FinishTime = now
+	err = c.putEscrow(escrow)
+	if err != nil {
+		myLogger.Errorf("refundEscrow error2:%s", err)
+		return shim.Error(err.Error())
+	}
+
+	err = c.setEscrowEvent(escrow)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	myLogger.Debug("Refund Escrow...done")
+	return shim.Success(nil)
+}
+
+// queryEscrow
+func (c *ExchangeChaincode) queryEscrow() pb.Response {
+	myLogger.Debug("queryEscrow...")
+
+	if len(c.args) != 1 {
+		return shim.Error("Incorrect number of arguments. Expecting 1")
+	}
+
+	escrow, err := c.getEscrow(c.args[0])
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if escrow == nil {
+		return shim.Error(NoDataErr.Error())
+	}
+
+	payload, err := json.Marshal(escrow)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	return shim.Success(payload)
+}
+
+// queryMyEscrow
+// args: user
+func (c *ExchangeChaincode) queryMyEscrow() pb.Response {
+	myLogger.Debug("queryMyEscrow...")
+
+	if len(c.args) != 1 {
+		return shim.Error("Incorrect number of arguments. Expecting 1")
+	}
+	user := c.args[0]
+
+	owned, err := c.getEscrowsByIndex("Escrow~owner~uuid", user)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	beneficiary, err := c.getEscrowsByIndex("Escrow~beneficiary~uuid", user)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	approver, err := c.getEscrowsByIndex("Escrow~approver~uuid", user)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	escrows := &struct {
+		Owned       []*Escrow `json:"owned"`
+		Beneficiary []*Escrow `json:"beneficiary"`
+		Approver    []*Escrow `json:"approver"`
+	}{
+		Owned:       owned,
+		Beneficiary: beneficiary,
+		Approver:    approver,
+	}
+
+	payload, err := json.Marshal(escrows)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	return shim.Success(payload)
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func escrowArgs(t *testing.T, owner string, amount, deadline int64) string {
+	b, err := json.Marshal(map[string]interface{}{
+		"owner":       owner,
+		"currency":    "EUR",
+		"amount":      amount,
+		"beneficiary": "bob",
+		"approvers":   []string{"Org1MSP/judge1", "Org1MSP/judge2", "Org1MSP/judge3"},
+		"threshold":   2,
+		"deadline":    deadline,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(b)
+}
+
+func TestEscrow(t *testing.T) {
+	e := newTestEnv(t)
+	e.now = 1500000000
+	e.openAccount("alice")
+	e.openAccount("bob")
+	e.fund("alice", "EUR", 100)
+
+	// only the owner account locks its asset
+	if msg := e.mustFail("bob", "createEscrow", escrowArgs(t, "alice", 40, e.now+100)); !strings.Contains(msg, "not the account of the caller") {
+		t.Fatalf("escrow by another account: %s", msg)
+	}
+	e.mustFail("alice", "createEscrow", escrowArgs(t, "alice", 400, e.now+100))
+
+	id := string(e.mustInvoke("alice", "createEscrow", escrowArgs(t, "alice", 40, e.now+100)))
+	if a := e.asset("alice", "EUR"); a.Count != 60 || a.LockCount != 40 {
+		t.Fatalf("alice EUR after create: %+v", a)
+	}
+
+	e.mustFail("mallory", "approveEscrow", id)
+	e.mustInvoke("judge1", "approveEscrow", id)
+	e.mustFail("bob", "releaseEscrow", id)
+	e.mustInvoke("judge2", "approveEscrow", id)
+	e.mustFail("alice", "refundEscrow", id)
+	e.mustInvoke("bob", "releaseEscrow", id)
+	if got := e.asset("bob", "EUR").Count; got != 40 {
+		t.Fatalf("bob EUR after release: got %d, expecting 40", got)
+	}
+
+	// refund after the deadline without approvals
+	id = string(e.mustInvoke("alice", "createEscrow", escrowArgs(t, "alice", 10, e.now+100)))
+	e.mustInvoke("judge1", "approveEscrow", id)
+	e.mustFail("alice", "refundEscrow", id)
+	e.now += 200
+	e.mustInvoke("alice", "refundEscrow", id)
+	if a := e.asset("alice", "EUR"); a.Count != 60 || a.LockCount != 0 {
+		t.Fatalf("alice EUR after refund: %+v", a)
+	}
+
+	// inactive accounts and paused transfers can't be escrowed
+	e.mustInvoke("root", "setAccountStatus", "bob", AccountFrozen, "1")
+	e.mustFail("alice", "createEscrow", escrowArgs(t, "alice", 10, e.now+100))
+	e.mustInvoke("root", "setAccountStatus", "bob", AccountActive, "1")
+	e.mustInvoke("root", "setCurrencyPause", "EUR", PauseTransfer, "true")
+	if msg := e.mustFail("alice", "createEscrow", escrowArgs(t, "alice", 10, e.now+100)); !strings.Contains(msg, "paused") {
+		t.Fatalf("escrow of a paused currency: %s", msg)
+	}
+}
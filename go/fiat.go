@@ -0,0 +1,539 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	pb "github.com/hyperledger/fabric/protos/peer"
+)
+
+const (
+	FiatActionDeposit  = "deposit"
+	FiatActionWithdraw = "withdraw"
+	FiatActionConfirm  = "confirm"
+	FiatActionReject   = "reject"
+
+	WithdrawalPending   = "pending"
+	WithdrawalConfirmed = "confirmed"
+	WithdrawalRejected  = "rejected"
+)
+
+// Withdrawal withdrawal of a base currency, the amount stays in the owner's WithdrawCount until the custodian settles it
+type Withdrawal struct {
+	DocType     string `json:"docType,omitempty"`
+	UUID        string `json:"uuid"`
+	Owner       string `json:"owner"`
+	Currency    string `json:"currency"`
+	Amount      int64  `json:"amount"`
+	BankRef     string `json:"bankRef"`
+	Status      string `json:"status"`
+	Custodian   string `json:"custodian"`
+	SettleRef   string `json:"settleRef"`
+	RequestTime int64  `json:"requestTime"`
+	SettleTime  int64  `json:"settleTime"`
+}
+
+// FiatLog reconciliation log of deposits and withdrawals with the bank reference
+type FiatLog struct {
+	DocType    string `json:"docType,omitempty"`
+	UUID       string `json:"uuid"`
+	Action     string `json:"action"`
+	Owner      string `json:"owner"`
+	Currency   string `json:"currency"`
+	Amount     int64  `json:"amount"`
+	BankRef    string `json:"bankRef"`
+	Withdrawal string `json:"withdrawal"`
+	Operator   string `json:"operator"`
+	LogTime    int64  `json:"logTime"`
+}
+
+func (c *ExchangeChaincode) putWithdrawal(withdrawal *Withdrawal) error {
+	if withdrawal.UUID == "" {
+		withdrawal.UUID = GenerateUUID()
+	}
+	withdrawal.DocType = DocWithdrawal
+	r, err := json.Marshal(withdrawal)
+	if err != nil {
+		return err
+	}
+
+	err = c.stub.PutState(withdrawal.UUID, r)
+	if err != nil {
+		return err
+	}
+
+	return c.putCompositeValue("Withdrawal~owner~uuid", []string{withdrawal.Owner, withdrawal.UUID})
+}
+
+func (c *ExchangeChaincode) getWithdrawal(key string) (*Withdrawal, error) {
+	withdrawalByte, err := c.stub.GetState(key)
+	if err != nil {
+		return nil, err
+	}
+	if withdrawalByte == nil {
+		return nil, nil
+	}
+
+	withdrawal := new(Withdrawal)
+	err = json.Unmarshal(withdrawalByte, withdrawal)
+	if err != nil {
+		return nil, err
+	}
+	return withdrawal, nil
+}
+
+func (c *ExchangeChaincode) getPendingWithdrawal(id string) (*Withdrawal, error) {
+	withdrawal, err := c.getWithdrawal(id)
+	if err != nil {
+		return nil, fmt.Errorf("Failed retrieving withdrawal [%s]: [%s]", id, err)
+	}
+	if withdrawal == nil || withdrawal.UUID == "" {
+		return nil, fmt.Errorf("The withdrawal [%s] does not exist", id)
+	}
+	if withdrawal.Status != WithdrawalPending {
+		return nil, fmt.Errorf("The withdrawal [%s] is %s", id, withdrawal.Status)
+	}
+	return withdrawal, nil
+}
+
+func (c *ExchangeChaincode) getOwnerWithdrawal(owner string) ([]*Withdrawal, error) {
+	bb, err := c.getCompositeValue("Withdrawal~owner~uuid", []string{owner}, 1)
+	if err != nil {
+		return nil, err
+	}
+
+	var withdrawals []*Withdrawal
+	for _, v := range bb {
+		withdrawal := new(Withdrawal)
+		err = json.Unmarshal(v, withdrawal)
+		if err != nil {
+			return nil, err
+		}
+		withdrawals = append(withdrawals, withdrawal)
+	}
+	return withdrawals, nil
+}
+
+func (c *ExchangeChaincode) putFiatLog(log *FiatLog) error {
+	if log.UUID == "" {
+		log.UUID = GenerateUUID()
+	}
+	log.DocType = DocFiatLog
+	r, err := json.Marshal(log)
+	if err != nil {
+		return err
+	}
+
+	err = c.stub.PutState(log.UUID, r)
+	if err != nil {
+		return err
+	}
+
+	err = c.putCompositeValue("FiatLog~owner~uuid", []string{log.Owner, log.UUID})
+	if err != nil {
+		return err
+	}
+
+	err = c.putCompositeValue("FiatLog~currency~uuid", []string{log.Currency, log.UUID})
+	if err != nil {
+		return err
+	}
+
+	// the references of the bank transfers, the destination account of a request and the reason of a reject repeat
+	if log.Action == FiatActionDeposit || log.Action == FiatActionConfirm {
+		err = c.putCompositeValue("FiatLog~bankRef~uuid", []string{log.BankRef, log.UUID})
+		if err != nil {
+			return err
+		}
+	}
+
+	err = c.putFiatStatement(log)
+	if err != nil {
+		return err
+	}
+
+	payload, err := json.Marshal(log)
+	if err != nil {
+		return err
+	}
+	return c.stub.SetEvent("chaincode_"+log.Action, payload)
+}
+
+func (c *ExchangeChaincode) getFiatLogs(indexName, key string) ([]*FiatLog, error) {
+	bb, err := c.getCompositeValue(indexName, []string{key}, 1)
+	if err != nil {
+		return nil, err
+	}
+
+	var logs []*FiatLog
+	for _, v := range bb {
+		log := new(FiatLog)
+		err = json.Unmarshal(v, log)
+		if err != nil {
+			return nil, err
+		}
+		logs = append(logs, log)
+	}
+	return logs, nil
+}
+
+// checkBankRefUnused reject a bank transfer reference credited or paid out before
+func (c *ExchangeChaincode) checkBankRefUnused(bankRef string) (error, ErrType) {
+	logs, err := c.getFiatLogs("FiatLog~bankRef~uuid", bankRef)
+	if err != nil {
+		return fmt.Errorf("Failed retrieving the logs of bank reference [%s]: [%s]", bankRef, err), WorldStateErr
+	}
+	if len(logs) > 0 {
+		return fmt.Errorf("The bank reference [%s] is already used", bankRef), CheckErr
+	}
+	return nil, CheckErr
+}
+
+// getBaseCurrencyByName load the currency and check it is a base currency
+func (c *ExchangeChaincode) getBaseCurrencyByName(name string) (*Currency, error) {
+	curr, err := c.getCurrencyByName(name)
+	if err != nil {
+		return nil, fmt.Errorf("Failed retrieving currency [%s]: [%s]", name, err)
+	}
+	if curr == nil {
+		return nil, fmt.Errorf("The currency [%s] does not exist", name)
+	}
+	if !curr.Base {
+		return nil, fmt.Errorf("The currency [%s] is not a base currency", name)
+	}
+	return curr, nil
+}
+
+// deposit credit a base currency received by the bank to the account named in the transfer, requires the custodian role
+// args: owner, currency id, amount, bank reference
+func (c *ExchangeChaincode) deposit() pb.Response {
+	myLogger.Debug("Deposit...")
+
+	if len(c.args) != 4 {
+		return shim.Error("Incorrect number of arguments. Expecting 4")
+	}
+
+	owner := c.args[0]
+	currency := c.args[1]
+	amount, err := strconv.ParseInt(c.args[2], 10, 64)
+	if err != nil || amount <= 0 {
+		return shim.Error("The deposit amount must be > 0")
+	}
+	bankRef := c.args[3]
+	if bankRef == "" {
+		return shim.Error("The bank reference is required")
+	}
+
+	custodian, err := c.checkRole(RoleCustodian)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	err, errType := c.checkBankRefUnused(bankRef)
+	if errType == WorldStateErr {
+		myLogger.Errorf("deposit error3:%s", err)
+		return shim.Error(err.Error())
+	} else if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	curr, err := c.getBaseCurrencyByName(currency)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	err, _ = c.checkAccountActive(owner)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	asset, err := c.getOwnerOneAsset(owner, currency)
+	if err != nil {
+		myLogger.Errorf("deposit error1:%s", err)
+		return shim.Error(fmt.Sprintf("Failed retrieving asset [%s] of the user: [%s]", currency, err))
+	}
+	if asset == nil {
+		asset = &Asset{
+			Owner:    owner,
+			Currency: currency,
+		}
+	}
+	asset.Count = asset.Count + amount
+	err = c.putAsset(asset)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	// the supply of a base currency is the fiat held by the custodian
+	curr.Count = curr.Count + amount
+	err = c.putCurrency(curr)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	err = c.putFiatLog(&FiatLog{
+		Action:   FiatActionDeposit,
+		Owner:    owner,
+		Currency: currency,
+		Amount:   amount,
+		BankRef:  bankRef,
+		Operator: custodian,
+		LogTime:  c.txTime(),
+	})
+	if err != nil {
+		myLogger.Errorf("deposit error2:%s", err)
+		return shim.Error(err.Error())
+	}
+
+	myLogger.Debug("Deposit...done")
+	return shim.Success(nil)
+}
+
+// requestWithdrawal set aside available base currency of the account bound to the caller until the custodian pays it out
+// args: currency id, amount, bank account reference
+func (c *ExchangeChaincode) requestWithdrawal() pb.Response {
+	myLogger.Debug("Request Withdrawal...")
+
+	if len(c.args) != 3 {
+		return shim.Error("Incorrect number of arguments. Expecting 3")
+	}
+
+	currency := c.args[0]
+	amount, err := strconv.ParseInt(c.args[1], 10, 64)
+	if err != nil || amount <= 0 {
+		return shim.Error("The withdrawal amount must be > 0")
+	}
+	bankRef := c.args[2]
+	if bankRef == "" {
+		return shim.Error("The bank reference is required")
+	}
+
+	owner, err := c.getCallerAccount()
+	if err != nil {
+		myLogger.Errorf("requestWithdrawal error1:%s", err)
+		return shim.Error(err.Error())
+	}
+	err, _ = c.checkAccountActive(owner)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	_, err = c.getBaseCurrencyByName(currency)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	asset, err := c.getOwnerOneAsset(owner, currency)
+	if err != nil {
+		myLogger.Errorf("requestWithdrawal error2:%s", err)
+		return shim.Error(fmt.Sprintf("Failed retrieving asset [%s] of the user: [%s]", currency, err))
+	}
+	if asset == nil || asset.Count < amount {
+		return shim.Error(fmt.Sprintf("Currency [%s] of the user is insufficient", currency))
+	}
+	asset.Count = asset.Count - amount
+	asset.WithdrawCount = asset.WithdrawCount + amount
+	err = c.putAsset(asset)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	now := c.txTime()
+	withdrawal := &Withdrawal{
+		Owner:       owner,
+		Currency:    currency,
+		Amount:      amount,
+		BankRef:     bankRef,
+		Status:      WithdrawalPending,
+		RequestTime: now,
+	}
+	err = c.putWithdrawal(withdrawal)
+	if err != nil {
+		myLogger.Errorf("requestWithdrawal error3:%s", err)
+		return shim.Error(err.Error())
+	}
+
+	err = c.putFiatLog(&FiatLog{
+		Action:     FiatActionWithdraw,
+		Owner:      owner,
+		Currency:   currency,
+		Amount:     amount,
+		BankRef:    bankRef,
+		Withdrawal: withdrawal.UUID,
+		Operator:   owner,
+		LogTime:    now,
+	})
+	if err != nil {
+		myLogger.Errorf("requestWithdrawal error4:%s", err)
+		return shim.Error(err.Error())
+	}
+
+	myLogger.Debug("Request Withdrawal...done")
+	return shim.Success([]byte(withdrawal.UUID))
+}
+
+// settleWithdrawal confirm or reject a pending withdrawal, requires the custodian role
+func (c *ExchangeChaincode) settleWithdrawal(confirm bool) pb.Response {
+	if len(c.args) != 2 {
+		return shim.Error("Incorrect number of arguments. Expecting 2")
+	}
+
+	custodian, err := c.checkRole(RoleCustodian)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	withdrawal, err := c.getPendingWithdrawal(c.args[0])
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	ref := c.args[1]
+	if ref == "" {
+		return shim.Error("The bank reference is required")
+	}
+	if confirm {
+		err, errType := c.checkBankRefUnused(ref)
+		if errType == WorldStateErr {
+			myLogger.Errorf("settleWithdrawal error5:%s", err)
+			return shim.Error(err.Error())
+		} else if err != nil {
+			return shim.Error(err.Error())
+		}
+	}
+
+	asset, err := c.getOwnerOneAsset(withdrawal.Owner, withdrawal.Currency)
+	if err != nil {
+		myLogger.Errorf("settleWithdrawal error1:%s", err)
+		return shim.Error(fmt.Sprintf("Failed retrieving asset [%s] of the user: [%s]", withdrawal.Currency, err))
+	}
+	if asset == nil || asset.WithdrawCount < withdrawal.Amount {
+		return shim.Error(fmt.Sprintf("The withdrawing currency [%s] of the user is insufficient", withdrawal.Currency))
+	}
+	asset.WithdrawCount = asset.WithdrawCount - withdrawal.Amount
+
+	action := FiatActionReject
+	withdrawal.Status = WithdrawalRejected
+	if confirm {
+		action = FiatActionConfirm
+		withdrawal.Status = WithdrawalConfirmed
+
+		curr, err := c.getCurrencyByName(withdrawal.Currency)
+		if err != nil {
+			myLogger.Errorf("settleWithdrawal error2:%s", err)
+			return shim.Error(fmt.Sprintf("Failed retrieving currency [%s]: [%s]", withdrawal.Currency, err))
+		}
+		if curr == nil {
+			return shim.Error(fmt.Sprintf("The currency [%s] does not exist", withdrawal.Currency))
+		}
+		curr.Count = curr.Count - withdrawal.Amount
+		err = c.putCurrency(curr)
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+	} else {
+		asset.Count = asset.Count + withdrawal.Amount
+	}
+
+	err = c.putAsset(asset)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	now := c.txTime()
+	withdrawal.Custodian = custodian
+	withdrawal.SettleRef = ref
+	withdrawal.SettleTime = now
+	err = c.putWithdrawal(withdrawal)
+	if err != nil {
+		myLogger.Errorf("settleWithdrawal error3:%s", err)
+		return shim.Error(err.Error())
+	}
+
+	err = c.putFiatLog(&FiatLog{
+		Action:     action,
+		Owner:      withdrawal.Owner,
+		Currency:   withdrawal.Currency,
+		Amount:     withdrawal.Amount,
+		BankRef:    ref,
+		Withdrawal: withdrawal.UUID,
+		Operator:   custodian,
+		LogTime:    now,
+	})
+	if err != nil {
+		myLogger.Errorf("settleWithdrawal error4:%s", err)
+		return shim.Error(err.Error())
+	}
+	return shim.Success(nil)
+}
+
+// confirmWithdrawal burn the withdrawing amount once the bank paid it out
+// args: withdrawal id, bank reference of the payment
+func (c *ExchangeChaincode) confirmWithdrawal() pb.Response {
+	myLogger.Debug("Confirm Withdrawal...")
+	return c.settleWithdrawal(true)
+}
+
+// rejectWithdrawal return the withdrawing amount to the owner
+// args: withdrawal id, reason or bank reference
+func (c *ExchangeChaincode) rejectWithdrawal() pb.Response {
+	myLogger.Debug("Reject Withdrawal...")
+	return c.settleWithdrawal(false)
+}
+
+// queryFiatLog deposits and withdrawals of an owner, or of a currency for reconciliation
+// args: owner | "currency", currency id
+func (c *ExchangeChaincode) queryFiatLog() pb.Response {
+	myLogger.Debug("queryFiatLog...")
+
+	if len(c.args) != 1 && len(c.args) != 2 {
+		return shim.Error("Incorrect number of arguments. Expecting 1 or 2")
+	}
+
+	indexName, key := "FiatLog~owner~uuid", c.args[0]
+	if len(c.args) == 2 {
+		if c.args[0] != "currency" {
+			return shim.Error("The first argument must be currency")
+		}
+		indexName, key = "FiatLog~currency~uuid", c.args[1]
+	}
+
+	logs, err := c.getFiatLogs(indexName, key)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if len(logs) == 0 {
+		return shim.Error(NoDataErr.Error())
+	}
+
+	payload, err := json.Marshal(logs)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	return shim.Success(payload)
+}
+
+// queryMyWithdrawal
+// args: owner
+func (c *ExchangeChaincode) queryMyWithdrawal() pb.Response {
+	myLogger.Debug("queryMyWithdrawal...")
+
+	if len(c.args) != 1 {
+		return shim.Error("Incorrect number of arguments. Expecting 1")
+	}
+
+	withdrawals, err := c.getOwnerWithdrawal(c.args[0])
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if len(withdrawals) == 0 {
+		return shim.Error(NoDataErr.Error())
+	}
+
+	payload, err := json.Marshal(withdrawals)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	return shim.Success(payload)
+}
@@ -0,0 +1,33 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestFiatWithdrawal(t *testing.T) {
+	e := newTestEnv(t)
+	e.openAccount("alice")
+
+	e.mustInvoke("root", "deposit", "alice", "CNY", "100", "in-1")
+	if msg := e.mustFail("root", "deposit", "alice", "CNY", "100", "in-1"); !strings.Contains(msg, "already used") {
+		t.Fatalf("repeated deposit reference: %s", msg)
+	}
+	e.lockOrder("alice", "CNY", "o1", 30)
+
+	// a pending withdrawal stays apart from the order locks
+	first := string(e.mustInvoke("alice", "requestWithdrawal", "CNY", "20", "acct-1"))
+	second := string(e.mustInvoke("alice", "requestWithdrawal", "CNY", "10", "acct-1"))
+	if a := e.asset("alice", "CNY"); a.Count != 40 || a.LockCount != 30 || a.WithdrawCount != 30 {
+		t.Fatalf("alice CNY after the requests: %+v", a)
+	}
+
+	e.mustInvoke("root", "confirmWithdrawal", first, "out-1")
+	if msg := e.mustFail("root", "confirmWithdrawal", second, "in-1"); !strings.Contains(msg, "already used") {
+		t.Fatalf("payout with a deposit reference: %s", msg)
+	}
+	e.mustInvoke("root", "rejectWithdrawal", second, "closed account")
+	if a := e.asset("alice", "CNY"); a.Count != 50 || a.LockCount != 30 || a.WithdrawCount != 0 {
+		t.Fatalf("alice CNY after settlement: %+v", a)
+	}
+}
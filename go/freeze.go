@@ -0,0 +1,367 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	pb "github.com/hyperledger/fabric/protos/peer"
+)
+
+const (
+	FreezeActionFreeze   = "freeze"
+	FreezeActionUnfreeze = "unfreeze"
+	FreezeActionSeize    = "seize"
+)
+
+// FreezeLog audit log of freeze, unfreeze and seize operations
+type FreezeLog struct {
+	DocType  string `json:"docType,omitempty"`
+	UUID     string `json:"uuid"`
+	Action   string `json:"action"`
+	Owner    string `json:"owner"`
+	Currency string `json:"currency"`
+	Count    int64  `json:"count"`
+	ToUser   string `json:"toUser"`
+	Reason   string `json:"reason"`
+	Operator string `json:"operator"`
+	LogTime  int64  `json:"logTime"`
+}
+
+func (c *ExchangeChaincode) putFreezeLog(log *FreezeLog) error {
+	if log.UUID == "" {
+		log.UUID = GenerateUUID()
+	}
+	log.DocType = DocFreezeLog
+	r, err := json.Marshal(log)
+	if err != nil {
+		return err
+	}
+
+	err = c.stub.PutState(log.UUID, r)
+	if err != nil {
+		return err
+	}
+
+	err = c.putCompositeValue("FreezeLog~owner~uuid", []string{log.Owner, log.UUID})
+	if err != nil {
+		return err
+	}
+
+	if log.ToUser != "" {
+		err = c.putCompositeValue("FreezeLog~to~uuid", []string{log.ToUser, log.UUID})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (c *ExchangeChaincode) getFreezeLogs(indexName, owner string) ([]*FreezeLog, error) {
+	bb, err := c.getCompositeValue(indexName, []string{owner}, 1)
+	if err != nil {
+		return nil, err
+	}
+
+	var logs []*FreezeLog
+	for _, v := range bb {
+		log := new(FreezeLog)
+		err = json.Unmarshal(v, log)
+		if err != nil {
+			return nil, err
+		}
+		logs = append(logs, log)
+	}
+	return logs, nil
+}
+
+// logFreeze save the audit log and publish it as the event of the action
+func (c *ExchangeChaincode) logFreeze(log *FreezeLog) error {
+	err := c.putFreezeLog(log)
+	if err != nil {
+		return err
+	}
+
+	payload, err := json.Marshal(log)
+	if err != nil {
+		return err
+	}
+	return c.stub.SetEvent("chaincode_"+log.Action, payload)
+}
+
+// parseFreezeArgs parse owner, currency id, count and load the asset
+func (c *ExchangeChaincode) parseFreezeArgs() (*Asset, int64, error) {
+	owner := c.args[0]
+	currency := c.args[1]
+	count, err := strconv.ParseInt(c.args[2], 10, 64)
+	if err != nil || count <= 0 {
+		return nil, 0, errors.New("The count must be > 0")
+	}
+
+	asset, err := c.getOwnerOneAsset(owner, currency)
+	if err != nil {
+		return nil, 0, fmt.Errorf("Failed retrieving asset [%s] of the user: [%s]", currency, err)
+	}
+	if asset == nil || asset.UUID == "" {
+		return nil, 0, fmt.Errorf("The user have not currency [%s]", currency)
+	}
+	return asset, count, nil
+}
+
+// freezeAsset move available asset into the frozen bucket, requires the admin role
+// args: owner, currency id, count, reason
+func (c *ExchangeChaincode) freezeAsset() pb.Response {
+	myLogger.Debug("Freeze Asset...")
+
+	if len(c.args) != 4 {
+		return shim.Error("Incorrect number of arguments. Expecting 4")
+	}
+
+	operator, err := c.checkRole(RoleAdmin)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	asset, count, err := c.parseFreezeArgs()
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if asset.Count < count {
+		return shim.Error(fmt.Sprintf("Currency [%s] of the user is insufficient", asset.Currency))
+	}
+
+	asset.Count = asset.Count - count
+	asset.FreezeCount = asset.FreezeCount + count
+	err = c.putAsset(asset)
+	if err != nil {
+		myLogger.Errorf("freezeAsset error1:%s", err)
+		return shim.Error(err.Error())
+	}
+
+	err = c.putStatement(asset.Owner, asset.Currency, &StatementEntry{
+		Kind:      StatementFreeze,
+		Action:    FreezeActionFreeze,
+		Available: -count,
+		Frozen:    count,
+	})
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	err = c.logFreeze(&FreezeLog{
+		Action:   FreezeActionFreeze,
+		Owner:    asset.Owner,
+		Currency: asset.Currency,
+		Count:    count,
+		Reason:   c.args[3],
+		Operator: operator,
+		LogTime:  c.txTime(),
+	})
+	if err != nil {
+		myLogger.Errorf("freezeAsset error2:%s", err)
+		return shim.Error(err.Error())
+	}
+
+	myLogger.Debug("Freeze Asset...done")
+	return shim.Success(nil)
+}
+
+// unfreezeAsset move frozen asset back to the available bucket, requires the admin role
+// args: owner, currency id, count, reason
+func (c *ExchangeChaincode) unfreezeAsset() pb.Response {
+	myLogger.Debug("Unfreeze Asset...")
+
+	if len(c.args) != 4 {
+		return shim.Error("Incorrect number of arguments. Expecting 4")
+	}
+
+	operator, err := c.checkRole(RoleAdmin)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	asset, count, err := c.parseFreezeArgs()
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if asset.FreezeCount < count {
+		return shim.Error(fmt.Sprintf("Frozen currency [%s] of the user is insufficient", asset.Currency))
+	}
+
+	asset.Count = asset.Count + count
+	asset.FreezeCount = asset.FreezeCount - count
+	err = c.putAsset(asset)
+	if err != nil {
+		myLogger.Errorf("unfreezeAsset error1:%s", err)
+		return shim.Error(err.Error())
+	}
+
+	err = c.putStatement(asset.Owner, asset.Currency, &StatementEntry{
+		Kind:      StatementFreeze,
+		Action:    FreezeActionUnfreeze,
+		Available: count,
+		Frozen:    -count,
+	})
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	err = c.logFreeze(&FreezeLog{
+		Action:   FreezeActionUnfreeze,
+		Owner:    asset.Owner,
+		Currency: asset.Currency,
+		Count:    count,
+		Reason:   c.args[3],
+		Operator: operator,
+		LogTime:  c.txTime(),
+	})
+	if err != nil {
+		myLogger.Errorf("unfreezeAsset error2:%s", err)
+		return shim.Error(err.Error())
+	}
+
+	myLogger.Debug("Unfreeze Asset...done")
+	return shim.Success(nil)
+}
+
+// seize move asset of the owner to another user under a legal order, requires the admin role.
+// The frozen bucket is taken first, then the available one; locked asset is never seized.
+// args: owner, currency id, count, receiver, case reference
+func (c *ExchangeChaincode) seize() pb.Response {
+	myLogger.Debug("Seize Asset...")
+
+	if len(c.args) != 5 {
+		return shim.Error("Incorrect number of arguments. Expecting 5")
+	}
+
+	operator, err := c.checkRole(RoleAdmin)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	asset, count, err := c.parseFreezeArgs()
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	to := c.args[3]
+	caseRef := c.args[4]
+	if to == "" || to == asset.Owner {
+		return shim.Error("The seize receiver is invalid")
+	}
+	if caseRef == "" {
+		return shim.Error("The case reference is required")
+	}
+	if asset.FreezeCount+asset.Count < count {
+		return shim.Error(fmt.Sprintf("Currency [%s] of the user is insufficient", asset.Currency))
+	}
+
+	// owner freezeCount -, then count -
+	entry := &StatementEntry{
+		Kind:         StatementFreeze,
+		Action:       FreezeActionSeize,
+		Counterparty: to,
+		Frozen:       -count,
+	}
+	if asset.FreezeCount >= count {
+		asset.FreezeCount = asset.FreezeCount - count
+	} else {
+		entry.Available, entry.Frozen = asset.FreezeCount-count, -asset.FreezeCount
+		asset.Count = asset.Count - (count - asset.FreezeCount)
+		asset.FreezeCount = 0
+	}
+	err = c.putAsset(asset)
+	if err != nil {
+		myLogger.Errorf("seize error1:%s", err)
+		return shim.Error(err.Error())
+	}
+
+	err = c.putStatement(asset.Owner, asset.Currency, entry)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	// receiver count +
+	toAsset, err := c.getOwnerOneAsset(to, asset.Currency)
+	if err != nil {
+		myLogger.Errorf("seize error2:%s", err)
+		return shim.Error(fmt.Sprintf("Failed retrieving asset [%s] of the user: [%s]", asset.Currency, err))
+	}
+	if toAsset == nil || toAsset.UUID == "" {
+		toAsset = &Asset{
+			Owner:    to,
+			Currency: asset.Currency,
+		}
+	}
+	toAsset.Count = toAsset.Count + count
+	err = c.putAsset(toAsset)
+	if err != nil {
+		myLogger.Errorf("seize error3:%s", err)
+		return shim.Error(err.Error())
+	}
+
+	err = c.putStatement(to, asset.Currency, &StatementEntry{
+		Kind:         StatementFreeze,
+		Action:       FreezeActionSeize,
+		Counterparty: asset.Owner,
+		Available:    count,
+	})
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	err = c.logFreeze(&FreezeLog{
+		Action:   FreezeActionSeize,
+		Owner:    asset.Owner,
+		Currency: asset.Currency,
+		Count:    count,
+		ToUser:   to,
+		Reason:   caseRef,
+		Operator: operator,
+		LogTime:  c.txTime(),
+	})
+	if err != nil {
+		myLogger.Errorf("seize error4:%s", err)
+		return shim.Error(err.Error())
+	}
+
+	myLogger.Debug("Seize Asset...done")
+	return shim.Success(nil)
+}
+
+// queryFreezeLog
+// args: owner
+func (c *ExchangeChaincode) queryFreezeLog() pb.Response {
+	myLogger.Debug("queryFreezeLog...")
+
+	if len(c.args) != 1 {
+		return shim.Error("Incorrect number of arguments. Expecting 1")
+	}
+	owner := c.args[0]
+
+	logOfMe, err := c.getFreezeLogs("FreezeLog~owner~uuid", owner)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	logToMe, err := c.getFreezeLogs("FreezeLog~to~uuid", owner)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	logs := &struct {
+		OfMe []*FreezeLog `json:"ofMe"`
+		ToMe []*FreezeLog `json:"toMe"`
+	}{
+		OfMe: logOfMe,
+		ToMe: logToMe,
+	}
+
+	payload, err := json.Marshal(logs)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	return shim.Success(payload)
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func TestFreezeAndSeize(t *testing.T) {
+	e := newTestEnv(t)
+	e.now = 1500000000
+	e.openAccount("alice")
+	e.openAccount("bob")
+	e.fund("alice", "EUR", 100)
+	e.lockOrder("alice", "EUR", "o1", 10)
+
+	if msg := e.mustFail("alice", "freezeAsset", "alice", "EUR", "10", "court order"); !strings.Contains(msg, "role") {
+		t.Fatalf("freeze by the owner: %s", msg)
+	}
+	if msg := e.mustFail("root", "freezeAsset", "alice", "EUR", "91", "court order"); !strings.Contains(msg, "insufficient") {
+		t.Fatalf("freeze of the locked asset: %s", msg)
+	}
+	e.now += 10
+	e.mustInvoke("root", "freezeAsset", "alice", "EUR", "40", "court order")
+	if a := e.asset("alice", "EUR"); a.Count != 50 || a.FreezeCount != 40 || a.LockCount != 10 {
+		t.Fatalf("alice EUR after the freeze: %+v", a)
+	}
+
+	if msg := e.mustFail("root", "unfreezeAsset", "alice", "EUR", "41", "released"); !strings.Contains(msg, "insufficient") {
+		t.Fatalf("unfreeze over the frozen count: %s", msg)
+	}
+	e.now += 10
+	e.mustInvoke("root", "unfreezeAsset", "alice", "EUR", "10", "released")
+	if a := e.asset("alice", "EUR"); a.Count != 60 || a.FreezeCount != 30 {
+		t.Fatalf("alice EUR after the unfreeze: %+v", a)
+	}
+
+	if msg := e.mustFail("root", "seize", "alice", "EUR", "10", "alice", "case-1"); !strings.Contains(msg, "receiver") {
+		t.Fatalf("seize to the owner: %s", msg)
+	}
+	if msg := e.mustFail("root", "seize", "alice", "EUR", "91", "bob", "case-1"); !strings.Contains(msg, "insufficient") {
+		t.Fatalf("seize of the locked asset: %s", msg)
+	}
+
+	// the frozen 30 go first, then 20 of the available
+	e.now += 10
+	e.mustInvoke("root", "seize", "alice", "EUR", "50", "bob", "case-1")
+	if a := e.asset("alice", "EUR"); a.Count != 40 || a.FreezeCount != 0 || a.LockCount != 10 {
+		t.Fatalf("alice EUR after the seize: %+v", a)
+	}
+	if b := e.asset("bob", "EUR"); b.Count != 50 {
+		t.Fatalf("bob EUR after the seize: %+v", b)
+	}
+
+	statement := new(Statement)
+	err := json.Unmarshal(e.mustInvoke("root", "queryStatement", "alice", "EUR", "1500000001", itoa(e.now)), statement)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(statement.Entries) != 3 || statement.Closing.Balance != 40 {
+		t.Fatalf("alice statement: %+v", statement)
+	}
+	freeze, unfreeze, seize := statement.Entries[0], statement.Entries[1], statement.Entries[2]
+	if freeze.Action != FreezeActionFreeze || freeze.Available != -40 || freeze.Frozen != 40 {
+		t.Fatalf("freeze entry: %+v", freeze)
+	}
+	if unfreeze.Action != FreezeActionUnfreeze || unfreeze.Available != 10 || unfreeze.Frozen != -10 {
+		t.Fatalf("unfreeze entry: %+v", unfreeze)
+	}
+	if seize.Action != FreezeActionSeize || seize.Available != -20 || seize.Frozen != -30 || seize.Counterparty != "bob" {
+		t.Fatalf("seize entry: %+v", seize)
+	}
+
+	statement = new(Statement)
+	err = json.Unmarshal(e.mustInvoke("root", "queryStatement", "bob", "EUR", "1500000001", itoa(e.now)), statement)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(statement.Entries) != 1 || statement.Entries[0].Available != 50 || statement.Entries[0].Counterparty != "alice" {
+		t.Fatalf("bob statement: %+v", statement)
+	}
+
+	logs := new(struct {
+		OfMe []*FreezeLog `json:"ofMe"`
+		ToMe []*FreezeLog `json:"toMe"`
+	})
+	err = json.Unmarshal(e.mustInvoke("root", "queryFreezeLog", "alice"), logs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(logs.OfMe) != 3 || len(logs.ToMe) != 0 {
+		t.Fatalf("alice freeze logs: %+v", logs)
+	}
+}
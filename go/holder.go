@@ -0,0 +1,263 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"unicode/utf8"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	pb "github.com/hyperledger/fabric/protos/peer"
+)
+
+const maxHolderPageSize = 1000
+
+// maxHolderScan assets read by a holder query, the queries are paged beyond it
+var maxHolderScan = 10000
+
+// Holder balance of an owner in a currency
+type Holder struct {
+	Owner   string `json:"owner"`
+	Balance int64  `json:"balance"` // count + lockCount + freezeCount + withdrawCount
+	Count   int64  `json:"count"`
+}
+
+// holdersByBalance sort holders by balance descending
+type holdersByBalance []*Holder
+
+func (h holdersByBalance) Len() int           { return len(h) }
+func (h holdersByBalance) Swap(i, j int)      { h[i], h[j] = h[j], h[i] }
+func (h holdersByBalance) Less(i, j int) bool { return h[i].Balance > h[j].Balance }
+
+// HolderPage page of holders, Bookmark is passed to get the next page and is empty on the last one.
+// A page holds fewer holders than asked when the scan reaches maxHolderScan assets.
+type HolderPage struct {
+	Currency string    `json:"currency"`
+	Holders  []*Holder `json:"holders"`
+	Bookmark string    `json:"bookmark"`
+}
+
+// scanHolders iterate the holders of the currency above its threshold in owner order, starting after bookmark.
+// At most maxHolderScan assets are read, fn returns false to stop. The returned bookmark resumes the scan
+// and is empty when the assets of the currency are exhausted.
+func (c *ExchangeChaincode) scanHolders(curr *Currency, bookmark string, fn func(holder *Holder) bool) (string, error) {
+	prefix, err := c.stub.CreateCompositeKey("Asset~currency~owner~uuid", []string{curr.Name})
+	if err != nil {
+		return "", err
+	}
+	start := prefix
+	if bookmark != "" {
+		// resume above the keys of the bookmark owner
+		start, err = c.stub.CreateCompositeKey("Asset~currency~owner~uuid", []string{curr.Name, bookmark})
+		if err != nil {
+			return "", err
+		}
+		start += string(utf8.MaxRune)
+	}
+	resultsIterator, err := c.stub.GetStateByRange(start, prefix+string(utf8.MaxRune))
+	if err != nil {
+		return "", err
+	}
+	defer resultsIterator.Close()
+
+	last := bookmark
+	for scanned := 0; resultsIterator.HasNext(); scanned++ {
+		if scanned == maxHolderScan {
+			return last, nil
+		}
+
+		compositeKey, _, err := resultsIterator.Next()
+		if err != nil {
+			return "", err
+		}
+
+		_, compositeKeyParts, err := c.stub.SplitCompositeKey(compositeKey)
+		if err != nil {
+			return "", err
+		}
+
+		asset, err := c.getAsset(compositeKeyParts[2])
+		if err != nil {
+			return "", err
+		}
+		if asset != nil {
+			balance := asset.Count + asset.LockCount + asset.FreezeCount + asset.WithdrawCount
+			if balance > curr.HolderThreshold && !fn(&Holder{Owner: asset.Owner, Balance: balance, Count: asset.Count}) {
+				return last, nil
+			}
+		}
+		last = compositeKeyParts[1]
+	}
+	return "", nil
+}
+
+func (c *ExchangeChaincode) getHolderCurrency(name string) (*Currency, error) {
+	curr, err := c.getCurrencyByName(name)
+	if err != nil {
+		return nil, fmt.Errorf("Failed retrieving currency [%s]: [%s]", name, err)
+	}
+	if curr == nil {
+		return nil, fmt.Errorf("The currency [%s] does not exist", name)
+	}
+	return curr, nil
+}
+
+// setHolderThreshold set the balance at or below which holders are not listed, requires the creator or an admin
+// args: currency id, threshold
+func (c *ExchangeChaincode) setHolderThreshold() pb.Response {
+	myLogger.Debug("Set Holder Threshold...")
+
+	if len(c.args) != 2 {
+		return shim.Error("Incorrect number of arguments. Expecting 2")
+	}
+
+	threshold, err := strconv.ParseInt(c.args[1], 10, 64)
+	if err != nil || threshold < 0 {
+		return shim.Error("The holder threshold must be >= 0")
+	}
+
+	curr, err := c.getHolderCurrency(c.args[0])
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	_, err = c.checkCreatorOrAdmin(curr)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	curr.HolderThreshold = threshold
+	err = c.putCurrency(curr)
+	if err != nil {
+		myLogger.Errorf("setHolderThreshold error1:%s", err)
+		return shim.Error(err.Error())
+	}
+
+	myLogger.Debug("Set Holder Threshold...done")
+	return shim.Success(nil)
+}
+
+// queryHolders
+// args: currency id, page size, bookmark (empty for the first page)
+func (c *ExchangeChaincode) queryHolders() pb.Response {
+	myLogger.Debug("queryHolders...")
+
+	if len(c.args) != 3 {
+		return shim.Error("Incorrect number of arguments. Expecting 3")
+	}
+
+	pageSize, err := strconv.Atoi(c.args[1])
+	if err != nil || pageSize <= 0 || pageSize > maxHolderPageSize {
+		return shim.Error(fmt.Sprintf("The page size must be between 1 and %d", maxHolderPageSize))
+	}
+
+	curr, err := c.getHolderCurrency(c.args[0])
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	page := &HolderPage{Currency: curr.Name, Holders: []*Holder{}}
+	page.Bookmark, err = c.scanHolders(curr, c.args[2], func(holder *Holder) bool {
+		if len(page.Holders) == pageSize {
+			// there is a next page
+			return false
+		}
+		page.Holders = append(page.Holders, holder)
+		return true
+	})
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	payload, err := json.Marshal(page)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	return shim.Success(payload)
+}
+
+// HolderCount count of holders in a page of assets, the counts of all pages add up to the holders of the currency
+type HolderCount struct {
+	Currency string `json:"currency"`
+	Count    int    `json:"count"`
+	Bookmark string `json:"bookmark"`
+}
+
+// queryHolderCount
+// args: currency id[, bookmark]
+func (c *ExchangeChaincode) queryHolderCount() pb.Response {
+	myLogger.Debug("queryHolderCount...")
+
+	if len(c.args) != 1 && len(c.args) != 2 {
+		return shim.Error("Incorrect number of arguments. Expecting 1 or 2")
+	}
+	bookmark := ""
+	if len(c.args) == 2 {
+		bookmark = c.args[1]
+	}
+
+	curr, err := c.getHolderCurrency(c.args[0])
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	count := &HolderCount{Currency: curr.Name}
+	count.Bookmark, err = c.scanHolders(curr, bookmark, func(holder *Holder) bool {
+		count.Count++
+		return true
+	})
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	payload, err := json.Marshal(count)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	return shim.Success(payload)
+}
+
+// queryTopHolders holders with the largest balances in a page of assets,
+// the top holders of the currency are the largest of the holders of all pages
+// args: currency id, count[, bookmark]
+func (c *ExchangeChaincode) queryTopHolders() pb.Response {
+	myLogger.Debug("queryTopHolders...")
+
+	if len(c.args) != 2 && len(c.args) != 3 {
+		return shim.Error("Incorrect number of arguments. Expecting 2 or 3")
+	}
+	bookmark := ""
+	if len(c.args) == 3 {
+		bookmark = c.args[2]
+	}
+
+	n, err := strconv.Atoi(c.args[1])
+	if err != nil || n <= 0 || n > maxHolderPageSize {
+		return shim.Error(fmt.Sprintf("The count must be between 1 and %d", maxHolderPageSize))
+	}
+
+	curr, err := c.getHolderCurrency(c.args[0])
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	page := &HolderPage{Currency: curr.Name, Holders: []*Holder{}}
+	page.Bookmark, err = c.scanHolders(curr, bookmark, func(holder *Holder) bool {
+		page.Holders = append(page.Holders, holder)
+		return true
+	})
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	sort.Stable(holdersByBalance(page.Holders))
+	if len(page.Holders) > n {
+		page.Holders = page.Holders[:n]
+	}
+
+	payload, err := json.Marshal(page)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	return shim.Success(payload)
+}
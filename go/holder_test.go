@@ -0,0 +1,133 @@
+package main
+
+import (
+	"encoding/json"
+	"sort"
+	"strings"
+	"testing"
+)
+
+func TestMigrateHolders(t *testing.T) {
+	e := newTestEnv(t)
+	e.openAccount("bob")
+	e.fund("bob", "EUR", 10)
+
+	// an asset put before the holder index
+	c := &ExchangeChaincode{stub: e.stub}
+	e.stub.MockTransactionStart("legacy")
+	asset := &Asset{DocType: DocAsset, UUID: "legacy-alice", Owner: "alice", Currency: "EUR", Count: 20}
+	b, err := json.Marshal(asset)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = e.stub.PutState(asset.UUID, b)
+	if err == nil {
+		err = c.putCompositeValue("Asset~owner~currency~uuid", []string{asset.Owner, asset.Currency, asset.UUID})
+	}
+	if err == nil {
+		err = c.putCompositeValue("Asset~owner~uuid", []string{asset.Owner, asset.UUID})
+	}
+	e.stub.MockTransactionEnd("legacy")
+	if err != nil {
+		t.Fatal(err)
+	}
+	e.openAccount("carol")
+	e.fund("carol", "EUR", 30)
+
+	holders := func() []string {
+		var owners []string
+		bookmark := ""
+		for {
+			page := new(HolderPage)
+			err := json.Unmarshal(e.mustInvoke("root", "queryHolders", "EUR", "1", bookmark), page)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, v := range page.Holders {
+				owners = append(owners, v.Owner)
+			}
+			bookmark = page.Bookmark
+			if bookmark == "" {
+				return owners
+			}
+		}
+	}
+	if owners := holders(); len(owners) != 2 {
+		t.Fatalf("holders before the migration: %v", owners)
+	}
+
+	result := new(MigrateResult)
+	err = json.Unmarshal(e.mustInvoke("root", "migrate", MigrateHolders, "", "10"), result)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Updated != 1 || result.Bookmark != "" {
+		t.Fatalf("migration: %+v", result)
+	}
+	if owners := holders(); len(owners) != 3 || owners[0] != "alice" || owners[1] != "bob" || owners[2] != "carol" {
+		t.Fatalf("holders after the migration: %v", owners)
+	}
+}
+
+func TestHolderQueries(t *testing.T) {
+	e := newTestEnv(t)
+	for i, name := range []string{"alice", "bob", "carol", "dave", "erin"} {
+		e.openAccount(name)
+		e.fund(name, "EUR", int64(10*(i+1)))
+	}
+	e.lockOrder("alice", "EUR", "o1", 5)
+
+	if msg := e.mustFail("bob", "setHolderThreshold", "EUR", "10"); !strings.Contains(msg, "no permission") {
+		t.Fatalf("setHolderThreshold by a user: %s", msg)
+	}
+	if msg := e.mustFail("root", "setHolderThreshold", "EUR", "-1"); !strings.Contains(msg, ">= 0") {
+		t.Fatalf("negative threshold: %s", msg)
+	}
+	// alice holds 10 with the locked count, at the threshold
+	e.mustInvoke("root", "setHolderThreshold", "EUR", "10")
+
+	defer func(n int) { maxHolderScan = n }(maxHolderScan)
+	maxHolderScan = 2
+
+	count := 0
+	top := []*Holder{}
+	bookmark := ""
+	for pages := 0; ; pages++ {
+		if pages == 3 {
+			t.Fatalf("the holder count doesn't end, bookmark %s", bookmark)
+		}
+		page := new(HolderCount)
+		err := json.Unmarshal(e.mustInvoke("root", "queryHolderCount", "EUR", bookmark), page)
+		if err != nil {
+			t.Fatal(err)
+		}
+		holders := new(HolderPage)
+		err = json.Unmarshal(e.mustInvoke("root", "queryTopHolders", "EUR", "2", bookmark), holders)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if holders.Bookmark != page.Bookmark {
+			t.Fatalf("bookmarks %s and %s of the same page", holders.Bookmark, page.Bookmark)
+		}
+		count += page.Count
+		top = append(top, holders.Holders...)
+		bookmark = page.Bookmark
+		if bookmark == "" {
+			break
+		}
+	}
+	sort.Stable(holdersByBalance(top))
+	if count != 4 || len(top) < 2 || top[0].Owner != "erin" || top[1].Owner != "dave" || top[0].Balance != 50 {
+		t.Fatalf("got %d holders, top %+v", count, top)
+	}
+
+	// the first page without a bookmark argument
+	page := new(HolderCount)
+	err := json.Unmarshal(e.mustInvoke("root", "queryHolderCount", "EUR"), page)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if page.Count != 1 || page.Bookmark != "bob" {
+		t.Fatalf("first page: %+v", page)
+	}
+}
@@ -0,0 +1,408 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	pb "github.com/hyperledger/fabric/protos/peer"
+	"golang.org/x/crypto/sha3"
+)
+
+const (
+	HashSHA256 = "sha256"
+	HashSHA3   = "sha3"
+)
+
+const (
+	HTLCLocked   = "locked"
+	HTLCClaimed  = "claimed"
+	HTLCRefunded = "refunded"
+)
+
+// HTLC hashed time-locked contract, the amount stays in the owner's LockCount until claimed or refunded
+type HTLC struct {
+	DocType    string `json:"docType,omitempty"`
+	UUID       string `json:"uuid"`
+	Owner      string `json:"owner"`
+	Currency   string `json:"currency"`
+	Amount     int64  `json:"amount"`
+	HashAlgo   string `json:"hashAlgo"`
+	HashLock   string `json:"hashLock"`
+	Timeout    int64  `json:"timeout"`
+	Recipient  string `json:"recipient"`
+	Status     string `json:"status"`
+	Preimage   string `json:"preimage"`
+	CreateTime int64  `json:"createTime"`
+	FinishTime int64  `json:"finishTime"`
+}
+
+func (c *ExchangeChaincode) putHTLC(htlc *HTLC) error {
+	if htlc.UUID == "" {
+		htlc.UUID = GenerateUUID()
+	}
+	htlc.DocType = DocHTLC
+	r, err := json.Marshal(htlc)
+	if err != nil {
+		return err
+	}
+
+	err = c.stub.PutState(htlc.UUID, r)
+	if err != nil {
+		return err
+	}
+
+	err = c.putCompositeValue("HTLC~owner~uuid", []string{htlc.Owner, htlc.UUID})
+	if err != nil {
+		return err
+	}
+
+	err = c.putCompositeValue("HTLC~recipient~uuid", []string{htlc.Recipient, htlc.UUID})
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+func (c *ExchangeChaincode) getHTLC(key string) (*HTLC, error) {
+	htlcByte, err := c.stub.GetState(key)
+	if err != nil {
+		return nil, err
+	}
+	if htlcByte == nil {
+		return nil, nil
+	}
+
+	htlc := new(HTLC)
+	err = json.Unmarshal(htlcByte, htlc)
+	if err != nil {
+		return nil, err
+	}
+	return htlc, nil
+}
+
+func (c *ExchangeChaincode) getHTLCsByIndex(indexName, user string) ([]*HTLC, error) {
+	bb, err := c.getCompositeValue(indexName, []string{user}, 1)
+	if err != nil {
+		return nil, err
+	}
+
+	var htlcs []*HTLC
+	for _, v := range bb {
+		htlc := new(HTLC)
+		err = json.Unmarshal(v, htlc)
+		if err != nil {
+			return nil, err
+		}
+		htlcs = append(htlcs, htlc)
+	}
+	return htlcs, nil
+}
+
+// parseHashLock parse hashlock "[sha256:|sha3:]hex", sha256 is used when no algorithm is given
+func parseHashLock(hashLock string) (string, []byte, error) {
+	algo := HashSHA256
+	if i := strings.Index(hashLock, ":"); i >= 0 {
+		algo = strings.ToLower(hashLock[:i])
+		hashLock = hashLock[i+1:]
+	}
+	if algo != HashSHA256 && algo != HashSHA3 {
+		return "", nil, fmt.Errorf("Unsupported hash algorithm [%s]", algo)
+	}
+
+	hash, err := hex.DecodeString(hashLock)
+	if err != nil || len(hash) != sha256.Size {
+		return "", nil, errors.New("The hashlock must be a 32 bytes hex string")
+	}
+	return algo, hash, nil
+}
+
+// verifyPreimage verify the preimage hashes to the hashlock of the contract
+func verifyPreimage(htlc *HTLC, preimage []byte) bool {
+	var sum [32]byte
+	if htlc.HashAlgo == HashSHA3 {
+		sum = sha3.Sum256(preimage)
+	} else {
+		sum = sha256.Sum256(preimage)
+	}
+
+	hash, err := hex.DecodeString(htlc.HashLock)
+	if err != nil {
+		return false
+	}
+	return bytes.Equal(sum[:], hash)
+}
+
+// htlcLock lock owner asset into a hashed time-locked contract, the owner is the account bound to the caller
+// args: owner, currency id, amount, hashlock, timeout (unix seconds), recipient
+func (c *ExchangeChaincode) htlcLock() pb.Response {
+	myLogger.Debug("HTLC Lock...")
+
+	if len(c.args) != 6 {
+		return shim.Error("Incorrect number of arguments. Expecting 6")
+	}
+
+	owner := c.args[0]
+	currency := c.args[1]
+	amount, err := strconv.ParseInt(c.args[2], 10, 64)
+	if err != nil || amount <= 0 {
+		return shim.Error("The htlc amount must be > 0")
+	}
+	algo, hash, err := parseHashLock(c.args[3])
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	now := c.txTime()
+	timeout, err := strconv.ParseInt(c.args[4], 10, 64)
+	if err != nil || timeout <= now {
+		return shim.Error("The htlc timeout must be later than the tx time")
+	}
+	recipient := c.args[5]
+	if recipient == "" || recipient == owner {
+		return shim.Error("The htlc recipient is invalid")
+	}
+
+	caller, err := c.getCallerAccount()
+	if err != nil {
+		myLogger.Errorf("htlcLock error3:%s", err)
+		return shim.Error(err.Error())
+	}
+	if caller != owner {
+		return shim.Error(fmt.Sprintf("The htlc owner [%s] is not the account of the caller", owner))
+	}
+	err, _ = c.checkAccountActive(owner)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	err, _ = c.checkAccountActive(recipient)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	err, _ = c.checkCurrencyOpen(currency, PauseTransfer)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	htlc := &HTLC{
+		UUID:       GenerateUUID(),
+		Owner:      owner,
+		Currency:   currency,
+		Amount:     amount,
+		HashAlgo:   algo,
+		HashLock:   hex.EncodeToString(hash),
+		Timeout:    timeout,
+		Recipient:  recipient,
+		Status:     HTLCLocked,
+		CreateTime: now,
+	}
+
+	err, errType := c.lockOrUnlockBalance(owner, currency, htlc.UUID, amount, true)
+	if errType == WorldStateErr {
+		myLogger.Errorf("htlcLock error1:%s", err)
+		return shim.Error(err.Error())
+	} else if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	err = c.putHTLC(htlc)
+	if err != nil {
+		myLogger.Errorf("htlcLock error2:%s", err)
+		return shim.Error(err.Error())
+	}
+
+	err = c.setHTLCEvent(htlc)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	myLogger.Debug("HTLC Lock...done")
+	return shim.Success([]byte(htlc.UUID))
+}
+
+// htlcClaim move the locked amount to the recipient when the preimage matches before timeout
+// args: htlc id, preimage (hex)
+func (c *ExchangeChaincode) htlcClaim() pb.Response {
+	myLogger.Debug("HTLC Claim...")
+
+	if len(c.args) != 2 {
+		return shim.Error("Incorrect number of arguments. Expecting 2")
+	}
+
+	htlc, err := c.getLockedHTLC(c.args[0])
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	now := c.txTime()
+	if now >= htlc.Timeout {
+		return shim.Error(fmt.Sprintf("The htlc [%s] is timeout", htlc.UUID))
+	}
+
+	preimage, err := hex.DecodeString(c.args[1])
+	if err != nil || !verifyPreimage(htlc, preimage) {
+		return shim.Error("The preimage does not match the hashlock")
+	}
+
+	// refunds stay allowed so that a paused currency can be returned to the owner
+	err, _ = c.checkCurrencyOpen(htlc.Currency, PauseTransfer)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	err, errType := c.settleLocked(htlc.Owner, htlc.Currency, htlc.UUID, htlc.Amount, htlc.Recipient)
+	if errType == WorldStateErr {
+		myLogger.Errorf("htlcClaim error1:%s", err)
+		return shim.Error(err.Error())
+	} else if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	htlc.Status = HTLCClaimed
+	htlc.Preimage = c.args[1]
+	htlc.FinishTime = now
+	err = c.putHTLC(htlc)
+	if err != nil {
+		myLogger.Errorf("htlcClaim error2:%s", err)
+		return shim.Error(err.Error())
+	}
+
+	err = c.setHTLCEvent(htlc)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	myLogger.Debug("HTLC Claim...done")
+	return shim.Success(nil)
+}
+
+// htlcRefund unlock the amount back to the owner after timeout
+// args: htlc id
+func (c *ExchangeChaincode) htlcRefund() pb.Response {
+	myLogger.Debug("HTLC Refund...")
+
+	if len(c.args) != 1 {
+		return shim.Error("Incorrect number of arguments. Expecting 1")
+	}
+
+	htlc, err := c.getLockedHTLC(c.args[0])
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	now := c.txTime()
+	if now < htlc.Timeout {
+		return shim.Error(fmt.Sprintf("The htlc [%s] is not timeout", htlc.UUID))
+	}
+
+	err, errType := c.lockOrUnlockBalance(htlc.Owner, htlc.Currency, htlc.UUID, htlc.Amount, false)
+	if errType == WorldStateErr {
+		myLogger.Errorf("htlcRefund error1:%s", err)
+		return shim.Error(err.Error())
+	} else if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	htlc.Status = HTLCRefunded
+	htlc.FinishTime = now
+	err = c.putHTLC(htlc)
+	if err != nil {
+		myLogger.Errorf("htlcRefund error2:%s", err)
+		return shim.Error(err.Error())
+	}
+
+	err = c.setHTLCEvent(htlc)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	myLogger.Debug("HTLC Refund...done")
+	return shim.Success(nil)
+}
+
+func (c *ExchangeChaincode) getLockedHTLC(id string) (*HTLC, error) {
+	htlc, err := c.getHTLC(id)
+	if err != nil {
+		return nil, fmt.Errorf("Failed retrieving htlc [%s]: [%s]", id, err)
+	}
+	if htlc == nil || htlc.UUID == "" {
+		return nil, fmt.Errorf("The htlc [%s] does not exist", id)
+	}
+	if htlc.Status != HTLCLocked {
+		return nil, fmt.Errorf("The htlc [%s] is %s", id, htlc.Status)
+	}
+	return htlc, nil
+}
+
+// setHTLCEvent publish the htlc, a claim event carries the preimage for the counterparty ledger
+func (c *ExchangeChaincode) setHTLCEvent(htlc *HTLC) error {
+	payload, err := json.Marshal(htlc)
+	if err != nil {
+		return err
+	}
+	return c.stub.SetEvent("chaincode_htlc", payload)
+}
+
+// queryHTLC
+func (c *ExchangeChaincode) queryHTLC() pb.Response {
+	myLogger.Debug("queryHTLC...")
+
+	if len(c.args) != 1 {
+		return shim.Error("Incorrect number of arguments. Expecting 1")
+	}
+
+	htlc, err := c.getHTLC(c.args[0])
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if htlc == nil {
+		return shim.Error(NoDataErr.Error())
+	}
+
+	payload, err := json.Marshal(htlc)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	return shim.Success(payload)
+}
+
+// queryMyHTLC
+func (c *ExchangeChaincode) queryMyHTLC() pb.Response {
+	myLogger.Debug("queryMyHTLC...")
+
+	if len(c.args) != 1 {
+		return shim.Error("Incorrect number of arguments. Expecting 1")
+	}
+	owner := c.args[0]
+
+	sent, err := c.getHTLCsByIndex("HTLC~owner~uuid", owner)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	received, err := c.getHTLCsByIndex("HTLC~recipient~uuid", owner)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	htlcs := &struct {
+		Sent     []*HTLC `json:"sent"`
+		Received []*HTLC `json:"received"`
+	}{
+		Sent:     sent,
+		Received: received,
+	}
+
+	payload, err := json.Marshal(htlcs)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	return shim.Success(payload)
+}
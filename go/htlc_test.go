@@ -0,0 +1,65 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"strings"
+	"testing"
+)
+
+func TestHTLC(t *testing.T) {
+	e := newTestEnv(t)
+	e.now = 1500000000
+	e.openAccount("alice")
+	e.openAccount("bob")
+	e.fund("alice", "EUR", 100)
+
+	preimage := []byte("secret")
+	sum := sha256.Sum256(preimage)
+	hashLock := hex.EncodeToString(sum[:])
+	timeout := itoa(e.now + 100)
+
+	// only the owner account locks its asset
+	if msg := e.mustFail("bob", "htlcLock", "alice", "EUR", "40", hashLock, timeout, "bob"); !strings.Contains(msg, "not the account of the caller") {
+		t.Fatalf("lock by another account: %s", msg)
+	}
+	e.mustFail("mallory", "htlcLock", "alice", "EUR", "40", hashLock, timeout, "bob")
+	e.mustFail("alice", "htlcLock", "alice", "EUR", "400", hashLock, timeout, "bob")
+	e.mustFail("alice", "htlcLock", "alice", "EUR", "40", hashLock, timeout, "carol")
+
+	id := string(e.mustInvoke("alice", "htlcLock", "alice", "EUR", "40", hashLock, timeout, "bob"))
+	if a := e.asset("alice", "EUR"); a.Count != 60 || a.LockCount != 40 {
+		t.Fatalf("alice EUR after lock: %+v", a)
+	}
+
+	e.mustFail("bob", "htlcRefund", id)
+	e.mustFail("bob", "htlcClaim", id, hex.EncodeToString([]byte("guess")))
+	e.mustInvoke("bob", "htlcClaim", id, hex.EncodeToString(preimage))
+	e.mustFail("bob", "htlcClaim", id, hex.EncodeToString(preimage))
+	if a := e.asset("alice", "EUR"); a.Count != 60 || a.LockCount != 0 {
+		t.Fatalf("alice EUR after claim: %+v", a)
+	}
+	if got := e.asset("bob", "EUR").Count; got != 40 {
+		t.Fatalf("bob EUR after claim: got %d, expecting 40", got)
+	}
+
+	// a frozen recipient can't claim, the owner gets a refund after timeout
+	id = string(e.mustInvoke("alice", "htlcLock", "alice", "EUR", "10", hashLock, timeout, "bob"))
+	e.mustInvoke("root", "setAccountStatus", "bob", AccountFrozen, "1")
+	if msg := e.mustFail("bob", "htlcClaim", id, hex.EncodeToString(preimage)); !strings.Contains(msg, "frozen") {
+		t.Fatalf("claim by a frozen recipient: %s", msg)
+	}
+	e.now += 200
+	e.mustFail("bob", "htlcClaim", id, hex.EncodeToString(preimage))
+	e.mustInvoke("alice", "htlcRefund", id)
+	if a := e.asset("alice", "EUR"); a.Count != 60 || a.LockCount != 0 {
+		t.Fatalf("alice EUR after refund: %+v", a)
+	}
+
+	// paused transfers can't be locked
+	e.mustInvoke("root", "setAccountStatus", "bob", AccountActive, "1")
+	e.mustInvoke("root", "setCurrencyPause", "EUR", PauseTransfer, "true")
+	if msg := e.mustFail("alice", "htlcLock", "alice", "EUR", "10", hashLock, itoa(e.now+100), "bob"); !strings.Contains(msg, "paused") {
+		t.Fatalf("lock of a paused currency: %s", msg)
+	}
+}
@@ -1,7 +1,11 @@
 package main
 
 import (
-	"time"
+	"fmt"
+	"strconv"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	pb "github.com/hyperledger/fabric/protos/peer"
 )
 
 const (
@@ -9,29 +13,106 @@ const (
 	USD = "USD"
 )
 
-func (c *ExchangeChaincode) initCurrency() error {
+// defaultBaseCurrencies base currencies created when Init has no arguments
+var defaultBaseCurrencies = []string{CNY, USD}
+
+// initCurrency create the base (fiat-backed) currencies
+func (c *ExchangeChaincode) initCurrency(names []string) error {
+	for _, name := range names {
+		if name == "" {
+			return fmt.Errorf("The base currency id is required")
+		}
+
+		curr, err := c.getCurrencyByName(name)
+		if err != nil {
+			return err
+		}
+		if curr != nil {
+			// keep the supply of an existing currency on upgrade
+			curr.Base = true
+			err = c.putCurrency(curr)
+			if err != nil {
+				return err
+			}
+			continue
+		}
+
+		err = c.putCurrency(&Currency{
+			Name:       name,
+			Count:      0,
+			LeftCount:  0,
+			Creator:    "system",
+			CreateTime: c.txTime(),
+			Symbol:     name,
+			Base:       true,
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// getBaseCurrency the currencies opened by default when an account is initialized
+func (c *ExchangeChaincode) getBaseCurrency() ([]*Currency, error) {
+	currs, err := c.getAllCurrency()
+	if err != nil {
+		return nil, err
+	}
+
+	var bases []*Currency
+	for _, v := range currs {
+		if v.Base {
+			bases = append(bases, v)
+		}
+	}
+	return bases, nil
+}
+
+// setBaseCurrency add a base currency or change the base attribute of a currency, requires the admin role
+// args: currency id, base
+func (c *ExchangeChaincode) setBaseCurrency() pb.Response {
+	myLogger.Debug("Set Base Currency...")
+
+	if len(c.args) != 2 {
+		return shim.Error("Incorrect number of arguments. Expecting 2")
+	}
 
-	err := c.putCurrency(&Currency{
-		Name:       CNY,
-		Count:      0,
-		LeftCount:  0,
-		Creator:    "system",
-		CreateTime: time.Now().Unix(),
-	})
+	name := c.args[0]
+	base, err := strconv.ParseBool(c.args[1])
 	if err != nil {
-		return err
+		return shim.Error("The base flag must be true or false")
 	}
 
-	err = c.putCurrency(&Currency{
-		Name:       USD,
-		Count:      0,
-		LeftCount:  0,
-		Creator:    "system",
-		CreateTime: time.Now().Unix(),
-	})
+	_, err = c.checkRole(RoleAdmin)
 	if err != nil {
-		return err
+		return shim.Error(err.Error())
 	}
 
-	return nil
+	curr, err := c.getCurrencyByName(name)
+	if err != nil {
+		myLogger.Errorf("setBaseCurrency error1:%s", err)
+		return shim.Error(fmt.Sprintf("Failed retrieving currency [%s]: [%s]", name, err))
+	}
+	if curr == nil {
+		if !base {
+			return shim.Error(fmt.Sprintf("The currency [%s] does not exist", name))
+		}
+		err = c.initCurrency([]string{name})
+		if err != nil {
+			myLogger.Errorf("setBaseCurrency error2:%s", err)
+			return shim.Error(err.Error())
+		}
+	} else {
+		curr.Base = base
+		err = c.putCurrency(curr)
+		if err != nil {
+			myLogger.Errorf("setBaseCurrency error3:%s", err)
+			return shim.Error(err.Error())
+		}
+	}
+
+	myLogger.Debug("Set Base Currency...done")
+	return shim.Success(nil)
 }
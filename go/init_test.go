@@ -0,0 +1,101 @@
+package main
+
+import (
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+// baseNames the sorted names of the base currencies
+func (e *testEnv) baseNames() []string {
+	bases, err := (&ExchangeChaincode{stub: e.stub}).getBaseCurrency()
+	if err != nil {
+		e.t.Fatal(err)
+	}
+	var names []string
+	for _, v := range bases {
+		names = append(names, v.Name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// initChaincode run Init as root with the args
+func (e *testEnv) initChaincode(args ...string) {
+	var b [][]byte
+	for _, v := range args {
+		b = append(b, []byte(v))
+	}
+	e.stub.MockTransactionStart("init")
+	r := new(ExchangeChaincode).Init(&testStub{MockStub: e.stub, creator: e.creator("root"), args: b})
+	e.stub.MockTransactionEnd("init")
+	if r.Status != shim.OK {
+		e.t.Fatalf("Init: %s", r.Message)
+	}
+}
+
+func TestInitBaseCurrencies(t *testing.T) {
+	e := newTestEnv(t)
+	if names := e.baseNames(); strings.Join(names, ",") != "CNY,USD" {
+		t.Fatalf("default base currencies: %v", names)
+	}
+
+	e = &testEnv{
+		t:       t,
+		stub:    shim.NewMockStub("exchange", new(ExchangeChaincode)),
+		callers: make(map[string][]byte),
+		events:  make(map[string][]byte),
+	}
+	e.initChaincode("EUR", "JPY")
+	if names := e.baseNames(); strings.Join(names, ",") != "EUR,JPY" {
+		t.Fatalf("base currencies of the args: %v", names)
+	}
+
+	// an upgrade makes an existing currency base and keeps its supply
+	e.mustInvoke("root", "create", "GBP", "100", "")
+	e.initChaincode("GBP")
+	curr, err := (&ExchangeChaincode{stub: e.stub}).getCurrencyByName("GBP")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !curr.Base || curr.Count != 100 {
+		t.Fatalf("GBP after the upgrade: %+v", curr)
+	}
+}
+
+func TestSetBaseCurrency(t *testing.T) {
+	e := newTestEnv(t)
+	e.mustInvoke("root", "create", "EUR", "100", "")
+
+	if msg := e.mustFail("alice", "setBaseCurrency", "EUR", "true"); !strings.Contains(msg, "no permission") {
+		t.Fatalf("setBaseCurrency by a user: %s", msg)
+	}
+	if msg := e.mustFail("root", "setBaseCurrency", "JPY", "false"); !strings.Contains(msg, "does not exist") {
+		t.Fatalf("unset a missing currency: %s", msg)
+	}
+	e.mustInvoke("root", "setBaseCurrency", "EUR", "true")
+	e.mustInvoke("root", "setBaseCurrency", "JPY", "true")
+	e.mustInvoke("root", "setBaseCurrency", "USD", "false")
+	if names := e.baseNames(); strings.Join(names, ",") != "CNY,EUR,JPY" {
+		t.Fatalf("base currencies: %v", names)
+	}
+
+	// a base currency is issued by deposits only
+	if msg := e.mustFail("root", "release", "EUR", "10"); !strings.Contains(msg, "can't be released") {
+		t.Fatalf("release of a base currency: %s", msg)
+	}
+	e.mustInvoke("root", "release", "USD", "10")
+
+	// an account opens the base currencies
+	e.mustInvoke("root", "initAccount", "alice")
+	for _, name := range []string{"CNY", "EUR", "JPY"} {
+		if a := e.asset("alice", name); a.UUID == "" {
+			t.Fatalf("alice has no %s asset", name)
+		}
+	}
+	if a := e.asset("alice", "USD"); a.UUID != "" {
+		t.Fatalf("alice has a USD asset: %+v", a)
+	}
+}
@@ -5,7 +5,6 @@ import (
 	"errors"
 	"fmt"
 	"strconv"
-	"time"
 
 	"github.com/hyperledger/fabric/core/chaincode/shim"
 	pb "github.com/hyperledger/fabric/protos/peer"
@@ -19,8 +18,10 @@ type FailInfo struct {
 type BatchResult struct {
 	EventName string     `json:"eventName"`
 	SrcMethod string     `json:"srcMethod"`
-	Success   []string   `json:""success`
+	Success   []string   `json:"Success"`
 	Fail      []FailInfo `json:"fail"`
+	Duplicate []string   `json:"duplicate,omitempty"`
+	Halted    []string   `json:"halted,omitempty"` // pairs (base/quote) halted by the circuit breaker
 }
 
 type ErrType string
@@ -35,50 +36,73 @@ var (
 	NoDataErr = errors.New("No row data")
 )
 
-// initAccount init account (CNY/USD currency) when user first login
-// args: user
+// initAccount init account and its assets when user first login
+// args: user[, currency ids], the currencies default to the base currencies
 func (c *ExchangeChaincode) initAccount() pb.Response {
 	myLogger.Debug("Init account...")
 
-	if len(c.args) != 1 {
-		return shim.Error("Incorrect number of arguments. Expecting 1")
+	if len(c.args) < 1 {
+		return shim.Error("Incorrect number of arguments. Expecting at least 1")
 	}
 
 	user := c.args[0]
 
-	// find CNY of the user
-	asset, err := c.getOwnerOneAsset(user, CNY)
+	// register the account, compliance activates it after kyc
+	account, err := c.getAccount(user)
 	if err != nil {
-		myLogger.Errorf("initAccount error1:%s", err)
-		return shim.Error(fmt.Sprintf("Failed retrieving asset [%s] of the user: [%s]", CNY, err))
+		myLogger.Errorf("initAccount error5:%s", err)
+		return shim.Error(fmt.Sprintf("Failed retrieving account [%s]: [%s]", user, err))
 	}
-	if asset == nil || asset.UUID == "" {
-		err = c.putAsset(&Asset{
-			Owner:     user,
-			Currency:  CNY,
-			Count:     0,
-			LockCount: 0,
+	if account == nil {
+		err = c.putAccount(&Account{
+			Name:       user,
+			Status:     AccountPending,
+			UpdateTime: c.txTime(),
 		})
 		if err != nil {
 			return shim.Error(err.Error())
 		}
 	}
 
-	// fins USD of the user
-	asset, err = c.getOwnerOneAsset(user, USD)
-	if err != nil {
-		myLogger.Errorf("initAccount error3:%s", err)
-		return shim.Error(fmt.Sprintf("Failed retrieving asset [%s] of the user: [%s]", USD, err))
+	var currs []string
+	if len(c.args) > 1 {
+		currs = c.args[1:]
+	} else {
+		bases, err := c.getBaseCurrency()
+		if err != nil {
+			myLogger.Errorf("initAccount error1:%s", err)
+			return shim.Error(fmt.Sprintf("Failed retrieving base currency: [%s]", err))
+		}
+		for _, v := range bases {
+			currs = append(currs, v.Name)
+		}
 	}
-	if asset == nil || asset.UUID == "" {
-		err = c.putAsset(&Asset{
-			Owner:     user,
-			Currency:  USD,
-			Count:     0,
-			LockCount: 0,
-		})
+
+	for _, currency := range currs {
+		curr, err := c.getCurrencyByName(currency)
 		if err != nil {
-			return shim.Error(err.Error())
+			myLogger.Errorf("initAccount error2:%s", err)
+			return shim.Error(fmt.Sprintf("Failed retrieving currency [%s]: [%s]", currency, err))
+		}
+		if curr == nil {
+			return shim.Error(fmt.Sprintf("The currency [%s] does not exist", currency))
+		}
+
+		asset, err := c.getOwnerOneAsset(user, currency)
+		if err != nil {
+			myLogger.Errorf("initAccount error3:%s", err)
+			return shim.Error(fmt.Sprintf("Failed retrieving asset [%s] of the user: [%s]", currency, err))
+		}
+		if asset == nil || asset.UUID == "" {
+			err = c.putAsset(&Asset{
+				Owner:     user,
+				Currency:  currency,
+				Count:     0,
+				LockCount: 0,
+			})
+			if err != nil {
+				return shim.Error(err.Error())
+			}
 		}
 	}
 
@@ -87,27 +111,79 @@ func (c *ExchangeChaincode) initAccount() pb.Response {
 	return shim.Success(nil)
 }
 
-// create create currency
-// args:currency id, currency count, currency creator
+// create create currency, the caller is the creator
+// args: currency id, currency count, currency creator (empty or the caller)[, json{displayName, symbol, decimals, descriptionURI, supplyCap, mintDisabled, burnable}]
 func (c *ExchangeChaincode) create() pb.Response {
 	myLogger.Debug("Create Currency...")
 
-	if len(c.args) != 3 {
-		return shim.Error("Incorrect number of arguments. Expecting 3")
+	if len(c.args) != 3 && len(c.args) != 4 {
+		return shim.Error("Incorrect number of arguments. Expecting 3 or 4")
 	}
 
 	name := c.args[0]
-	count, _ := strconv.ParseInt(c.args[1], 10, 64)
-	creator := c.args[2]
-	now := time.Now().Unix()
+	if name == "" {
+		return shim.Error("The currency id is required")
+	}
+	count, err := strconv.ParseInt(c.args[1], 10, 64)
+	if err != nil || count < 0 {
+		return shim.Error("The currency count must be >= 0")
+	}
+	creator, err := c.getCaller()
+	if err != nil {
+		myLogger.Errorf("create error4:%s", err)
+		return shim.Error(err.Error())
+	}
+	if c.args[2] != "" && c.args[2] != creator {
+		return shim.Error(fmt.Sprintf("The currency creator [%s] is not the caller", c.args[2]))
+	}
+	now := c.txTime()
 
-	err := c.putCurrency(&Currency{
+	curr := &Currency{
 		Name:       name,
 		Count:      count,
 		LeftCount:  count,
 		Creator:    creator,
 		CreateTime: now,
-	})
+		Symbol:     name,
+	}
+	if len(c.args) == 4 {
+		err = json.Unmarshal([]byte(c.args[3]), curr)
+		if err != nil {
+			myLogger.Errorf("create error1:%s", err)
+			return shim.Error(fmt.Sprintf("Failed unmarshalling currency definition: [%s]", err))
+		}
+		// the definition can't override the identity and supply of the currency
+		curr.UUID = ""
+		curr.Base = false
+		curr.Name = name
+		curr.Count = count
+		curr.LeftCount = count
+		curr.Creator = creator
+		curr.CreateTime = now
+		curr.PendingCreator = ""
+		curr.Issuers = nil
+		curr.IssuerThreshold = 0
+	}
+	if curr.Decimals < 0 || curr.Decimals > 18 {
+		return shim.Error("The currency decimals must be between 0 and 18")
+	}
+	if curr.SupplyCap < 0 {
+		return shim.Error("The currency supply cap must be >= 0")
+	}
+	if curr.SupplyCap > 0 && count > curr.SupplyCap {
+		return shim.Error(fmt.Sprintf("The currency count exceeds the supply cap [%d]", curr.SupplyCap))
+	}
+
+	exist, err := c.getCurrencyByName(name)
+	if err != nil {
+		myLogger.Errorf("create error3:%s", err)
+		return shim.Error(fmt.Sprintf("Failed retrieving currency [%s]: [%s]", name, err))
+	}
+	if exist != nil {
+		return shim.Error(fmt.Sprintf("The currency [%s] already exists", name))
+	}
+
+	err = c.putCurrency(curr)
 	if err != nil {
 		myLogger.Errorf("create error2:%s", err)
 		return shim.Error(err.Error())
@@ -130,7 +206,7 @@ func (c *ExchangeChaincode) create() pb.Response {
 	return shim.Success(nil)
 }
 
-// release release currency
+// release release currency, requires the creator or an admin, or the issuers when the currency has an issuer set
 // args: currency id, release count
 func (c *ExchangeChaincode) release() pb.Response {
 	myLogger.Debug("Release Currency...")
@@ -145,15 +221,36 @@ func (c *ExchangeChaincode) release() pb.Response {
 		return shim.Error("The currency release count must be > 0")
 	}
 
-	if id == CNY || id == USD {
-		return shim.Error("Currency can't be CNY or USD")
-	}
-
 	curr, err := c.getCurrencyByName(id)
 	if err != nil {
 		myLogger.Errorf("releaseCurrency error1:%s", err)
 		return shim.Error(fmt.Sprintf("Failed retrieving currency [%s]: [%s]", id, err))
 	}
+	if curr == nil {
+		return shim.Error(fmt.Sprintf("The currency [%s] does not exist", id))
+	}
+	if curr.Base {
+		return shim.Error(fmt.Sprintf("The base currency [%s] can't be released", id))
+	}
+	err = curr.checkOpen(PauseIssuance)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if curr.MintDisabled {
+		return shim.Error(fmt.Sprintf("The currency [%s] is not mintable", id))
+	}
+	if curr.SupplyCap > 0 && curr.Count+count > curr.SupplyCap {
+		return shim.Error(fmt.Sprintf("The release exceeds the supply cap [%d] of currency [%s]", curr.SupplyCap, id))
+	}
+	if curr.IssuerThreshold > 0 && !c.proposalApproved {
+		return c.proposeIssuance(curr, "release")
+	}
+	if !c.proposalApproved {
+		_, err = c.checkCreatorOrAdmin(curr)
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+	}
 
 	// update currency data
 	curr.Count = curr.Count + count
@@ -168,7 +265,7 @@ func (c *ExchangeChaincode) release() pb.Response {
 		Currency:    id,
 		Releaser:    curr.Creator,
 		Count:       count,
-		ReleaseTime: time.Now().Unix(),
+		ReleaseTime: c.txTime(),
 	})
 	if err != nil {
 		return shim.Error(err.Error())
@@ -179,7 +276,71 @@ func (c *ExchangeChaincode) release() pb.Response {
 	return shim.Success(nil)
 }
 
-// assign  assign currency
+// burn burn unassigned currency, requires the creator or an admin, or the issuers when the currency has an issuer set
+// args: currency id, burn count
+func (c *ExchangeChaincode) burn() pb.Response {
+	myLogger.Debug("Burn Currency...")
+
+	if len(c.args) != 2 {
+		return shim.Error("Incorrect number of arguments. Expecting 2")
+	}
+
+	id := c.args[0]
+	count, err := strconv.ParseInt(c.args[1], 10, 64)
+	if err != nil || count <= 0 {
+		return shim.Error("The currency burn count must be > 0")
+	}
+
+	curr, err := c.getCurrencyByName(id)
+	if err != nil {
+		myLogger.Errorf("burnCurrency error1:%s", err)
+		return shim.Error(fmt.Sprintf("Failed retrieving currency [%s]: [%s]", id, err))
+	}
+	if curr == nil {
+		return shim.Error(fmt.Sprintf("The currency [%s] does not exist", id))
+	}
+	if !curr.Burnable {
+		return shim.Error(fmt.Sprintf("The currency [%s] is not burnable", id))
+	}
+	if curr.LeftCount < count {
+		return shim.Error(fmt.Sprintf("The left count [%d] of currency [%s] is insufficient", curr.LeftCount, id))
+	}
+	if curr.IssuerThreshold > 0 && !c.proposalApproved {
+		return c.proposeIssuance(curr, "burn")
+	}
+	if !c.proposalApproved {
+		_, err = c.checkCreatorOrAdmin(curr)
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+	}
+
+	// update currency data
+	curr.Count = curr.Count - count
+	curr.LeftCount = curr.LeftCount - count
+	err = c.putCurrency(curr)
+	if err != nil {
+		myLogger.Errorf("burnCurrency error2:%s", err)
+		return shim.Error(fmt.Sprintf("Failed replacing row [%s]", err))
+	}
+
+	err = c.putBurnLog(&BurnLog{
+		Currency: id,
+		Burner:   curr.Creator,
+		Count:    count,
+		BurnTime: c.txTime(),
+	})
+	if err != nil {
+		myLogger.Errorf("burnCurrency error3:%s", err)
+		return shim.Error(err.Error())
+	}
+
+	myLogger.Debug("Burn Currency...done")
+
+	return shim.Success(nil)
+}
+
+// assign  assign currency, requires the creator or an admin, or the issuers when the currency has an issuer set
 // args: json{currency id, []{reciver, count}}
 func (c *ExchangeChaincode) assign() pb.Response {
 	myLogger.Debug("Assign Currency...")
@@ -211,30 +372,54 @@ func (c *ExchangeChaincode) assign() pb.Response {
 		myLogger.Errorf("assignCurrency error2:%s", err)
 		return shim.Error(fmt.Sprintf("Failed retrieving currency [%s]: [%s]", assign.Currency, err))
 	}
+	if curr == nil {
+		return shim.Error(fmt.Sprintf("The currency [%s] does not exist", assign.Currency))
+	}
+	err = curr.checkOpen(PauseIssuance)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if curr.IssuerThreshold > 0 && !c.proposalApproved {
+		return c.proposeIssuance(curr, "assign")
+	}
+	if !c.proposalApproved {
+		_, err = c.checkCreatorOrAdmin(curr)
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+	}
+
+	var successInfos []string
+	var failInfos []FailInfo
 
 	assignCount := int64(0)
+	assigns := assign.Assigns[:0]
 	for _, v := range assign.Assigns {
 		if v.Count <= 0 {
 			continue
 		}
 
+		err, errType := c.checkAccountActive(v.Owner)
+		if errType == CheckErr {
+			failInfos = append(failInfos, FailInfo{Id: v.Owner, Info: err.Error()})
+			continue
+		}
+
 		assignCount += v.Count
 		if assignCount > curr.LeftCount {
 			return shim.Error(fmt.Sprintf("The left count [%d] of currency [%s] is insufficient", curr.LeftCount, assign.Currency))
 		}
+		assigns = append(assigns, v)
 	}
 
-	for _, v := range assign.Assigns {
-		if v.Count <= 0 {
-			continue
-		}
+	for _, v := range assigns {
 
 		err = c.putAssignLog(&AssignLog{
 			Currency:   assign.Currency,
 			FromUser:   curr.Creator,
 			ToUser:     v.Owner,
 			Count:      v.Count,
-			AssignTime: time.Now().Unix(),
+			AssignTime: c.txTime(),
 		})
 		if err != nil {
 			myLogger.Errorf("assignCurrency error3:%s", err)
@@ -246,6 +431,12 @@ func (c *ExchangeChaincode) assign() pb.Response {
 			myLogger.Errorf("assignCurrency error4:%s", err)
 			return shim.Error(fmt.Sprintf("Failed retrieving asset [%s] of the user: [%s]", assign.Currency, err))
 		}
+		if asset == nil {
+			asset = &Asset{
+				Owner:    v.Owner,
+				Currency: assign.Currency,
+			}
+		}
 
 		asset.Count = asset.Count + v.Count
 		err = c.putAsset(asset)
@@ -254,6 +445,7 @@ func (c *ExchangeChaincode) assign() pb.Response {
 		}
 
 		curr.LeftCount -= v.Count
+		successInfos = append(successInfos, v.Owner)
 	}
 
 	err = c.putCurrency(curr)
@@ -261,17 +453,220 @@ func (c *ExchangeChaincode) assign() pb.Response {
 		return shim.Error(err.Error())
 	}
 
+	batch := BatchResult{EventName: "chaincode_assign", Success: successInfos, Fail: failInfos}
+	result, err := json.Marshal(&batch)
+	if err != nil {
+		myLogger.Errorf("assignCurrency error5:%s", err)
+		return shim.Error(err.Error())
+	}
+	c.stub.SetEvent(batch.EventName, result)
+
 	myLogger.Debug("Assign Currency...done")
 	return shim.Success(nil)
 }
 
+// reverseAssign take back an erroneous assignment from the available asset of the receiver, requires the creator or an admin,
+// or the issuers when the currency has an issuer set
+// args: assign log id, reason
+func (c *ExchangeChaincode) reverseAssign() pb.Response {
+	myLogger.Debug("Reverse Assign...")
+
+	if len(c.args) != 2 {
+		return shim.Error("Incorrect number of arguments. Expecting 2")
+	}
+
+	log, err := c.getAssignLog(c.args[0])
+	if err != nil {
+		myLogger.Errorf("reverseAssign error1:%s", err)
+		return shim.Error(fmt.Sprintf("Failed retrieving assign log [%s]: [%s]", c.args[0], err))
+	}
+	if log == nil || log.UUID == "" || log.Currency == "" {
+		return shim.Error(fmt.Sprintf("The assign log [%s] does not exist", c.args[0]))
+	}
+	if log.ReversalOf != "" {
+		return shim.Error(fmt.Sprintf("The assign log [%s] is a reversal", log.UUID))
+	}
+	if log.VestingUUID != "" {
+		return shim.Error(fmt.Sprintf("The assign log [%s] is a vesting claim, revoke the vesting instead", log.UUID))
+	}
+	if log.ReversedBy != "" {
+		return shim.Error(fmt.Sprintf("The assign log [%s] is already reversed by [%s]", log.UUID, log.ReversedBy))
+	}
+
+	curr, err := c.getCurrencyByName(log.Currency)
+	if err != nil {
+		myLogger.Errorf("reverseAssign error2:%s", err)
+		return shim.Error(fmt.Sprintf("Failed retrieving currency [%s]: [%s]", log.Currency, err))
+	}
+	if curr == nil {
+		return shim.Error(fmt.Sprintf("The currency [%s] does not exist", log.Currency))
+	}
+	if curr.IssuerThreshold > 0 && !c.proposalApproved {
+		return c.proposeIssuance(curr, "reverseAssign")
+	}
+	if !c.proposalApproved {
+		_, err = c.checkCreatorOrAdmin(curr)
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+	}
+
+	asset, err := c.getOwnerOneAsset(log.ToUser, log.Currency)
+	if err != nil {
+		myLogger.Errorf("reverseAssign error3:%s", err)
+		return shim.Error(fmt.Sprintf("Failed retrieving asset [%s] of the user: [%s]", log.Currency, err))
+	}
+	if asset == nil || asset.Count < log.Count {
+		return shim.Error(fmt.Sprintf("The available currency [%s] of the user is insufficient, it is spent or locked", log.Currency))
+	}
+
+	asset.Count = asset.Count - log.Count
+	err = c.putAsset(asset)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	curr.LeftCount += log.Count
+	err = c.putCurrency(curr)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	reversal := &AssignLog{
+		Currency:   log.Currency,
+		FromUser:   log.FromUser,
+		ToUser:     log.ToUser,
+		Count:      -log.Count,
+		AssignTime: c.txTime(),
+		ReversalOf: log.UUID,
+		Reason:     c.args[1],
+	}
+	err = c.putAssignLog(reversal)
+	if err != nil {
+		myLogger.Errorf("reverseAssign error4:%s", err)
+		return shim.Error(err.Error())
+	}
+
+	log.ReversedBy = reversal.UUID
+	err = c.putAssignLog(log)
+	if err != nil {
+		myLogger.Errorf("reverseAssign error5:%s", err)
+		return shim.Error(err.Error())
+	}
+
+	payload, err := json.Marshal(reversal)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	c.stub.SetEvent("chaincode_reverseAssign", payload)
+
+	myLogger.Debug("Reverse Assign...done")
+	return shim.Success([]byte(reversal.UUID))
+}
+
+// transfer transfer available asset of the account bound to the caller to another account
+// args: currency id, receiver, count, memo
+func (c *ExchangeChaincode) transfer() pb.Response {
+	myLogger.Debug("Transfer Asset...")
+
+	if len(c.args) != 4 {
+		return shim.Error("Incorrect number of arguments. Expecting 4")
+	}
+
+	currency := c.args[0]
+	to := c.args[1]
+	count, err := strconv.ParseInt(c.args[2], 10, 64)
+	if err != nil || count <= 0 {
+		return shim.Error("The transfer count must be > 0")
+	}
+	memo := c.args[3]
+
+	from, err := c.getCallerAccount()
+	if err != nil {
+		myLogger.Errorf("transfer error1:%s", err)
+		return shim.Error(err.Error())
+	}
+	if to == "" || to == from {
+		return shim.Error("The transfer receiver is invalid")
+	}
+	err, _ = c.checkAccountActive(from)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	err, _ = c.checkAccountActive(to)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	err, _ = c.checkCurrencyOpen(currency, PauseTransfer)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	// sender count -
+	fromAsset, err := c.getOwnerOneAsset(from, currency)
+	if err != nil {
+		myLogger.Errorf("transfer error2:%s", err)
+		return shim.Error(fmt.Sprintf("Failed retrieving asset [%s] of the user: [%s]", currency, err))
+	}
+	if fromAsset == nil || fromAsset.UUID == "" {
+		return shim.Error(fmt.Sprintf("The user have not currency [%s]", currency))
+	}
+	if fromAsset.Count < count {
+		return shim.Error(fmt.Sprintf("Currency [%s] of the user is insufficient", currency))
+	}
+	fromAsset.Count = fromAsset.Count - count
+	err = c.putAsset(fromAsset)
+	if err != nil {
+		myLogger.Errorf("transfer error3:%s", err)
+		return shim.Error(err.Error())
+	}
+
+	// receiver count +
+	toAsset, err := c.getOwnerOneAsset(to, currency)
+	if err != nil {
+		myLogger.Errorf("transfer error4:%s", err)
+		return shim.Error(fmt.Sprintf("Failed retrieving asset [%s] of the user: [%s]", currency, err))
+	}
+	if toAsset == nil || toAsset.UUID == "" {
+		toAsset = &Asset{
+			Owner:     to,
+			Currency:  currency,
+			LockCount: int64(0),
+		}
+	}
+	toAsset.Count = toAsset.Count + count
+	err = c.putAsset(toAsset)
+	if err != nil {
+		myLogger.Errorf("transfer error5:%s", err)
+		return shim.Error(err.Error())
+	}
+
+	err = c.putTransferLog(&TransferLog{
+		Currency:     currency,
+		FromUser:     from,
+		ToUser:       to,
+		Count:        count,
+		Memo:         memo,
+		TransferTime: c.txTime(),
+	})
+	if err != nil {
+		myLogger.Errorf("transfer error6:%s", err)
+		return shim.Error(err.Error())
+	}
+
+	myLogger.Debug("Transfer Asset...done")
+	return shim.Success(nil)
+}
+
 // lock lock or unlock user asset when commit a exchange or cancel exchange
-// args: json []{user, currency id, lock count, lock order}, islock, srcMethod
+// args: json []{user, currency id, lock count, lock order, nonce}, islock, srcMethod[, batch id]
+// a new order with a nonce must be above the last nonce of the account, a zero nonce is not checked
+// a processed batch id returns AlreadyProcessed, processed orders are reported as duplicates
 func (c *ExchangeChaincode) lock() pb.Response {
 	myLogger.Debug("Lock Asset Balance...")
 
-	if len(c.args) != 3 {
-		return shim.Error("Incorrect number of arguments. Expecting 3")
+	if len(c.args) != 3 && len(c.args) != 4 {
+		return shim.Error("Incorrect number of arguments. Expecting 3 or 4")
 	}
 
 	var lockInfos []struct {
@@ -279,6 +674,7 @@ func (c *ExchangeChaincode) lock() pb.Response {
 		Currency string `json:"currency"`
 		OrderId  string `json:"orderId"`
 		Count    int64  `json:"count"`
+		Nonce    int64  `json:"nonce"`
 	}
 
 	err := json.Unmarshal([]byte(c.args[0]), &lockInfos)
@@ -287,28 +683,120 @@ func (c *ExchangeChaincode) lock() pb.Response {
 		return shim.Error(err.Error())
 	}
 	islock, _ := strconv.ParseBool(c.args[1])
+	batchID := ""
+	if len(c.args) == 4 {
+		batchID = c.args[3]
+	}
+
+	replay, err := c.checkBatch(batchID)
+	if err != nil {
+		myLogger.Errorf("lock error4:%s", err)
+		return shim.Error(err.Error())
+	}
+	if replay != nil {
+		return *replay
+	}
+
+	kind := ProcessedUnlock
+	if islock {
+		kind = ProcessedLock
+	}
 
 	var successInfos []string
 	var failInfos []FailInfo
+	var duplicates []string
+
+	// ids and nonces processed by this batch
+	processedIDs := make(map[string]bool)
+	nonces := make(map[string]int64)
 
 	for _, v := range lockInfos {
-		err, errType := c.lockOrUnlockBalance(v.Owner, v.Currency, v.OrderId, v.Count, islock)
-		if errType == CheckErr && err != ExecedErr {
+		// check the order is processed or not
+		processed, err := c.getProcessed(kind, v.OrderId)
+		if err != nil {
+			myLogger.Errorf("lock error5:%s", err)
+			return shim.Error(err.Error())
+		}
+		if processed != nil || processedIDs[v.OrderId] {
+			duplicates = append(duplicates, v.OrderId)
+			continue
+		}
+
+		// unlocking is always allowed so that orders of inactive accounts can be canceled
+		if islock {
+			err, errType := c.checkAccountActive(v.Owner)
+			if errType == CheckErr {
+				failInfos = append(failInfos, FailInfo{Id: v.OrderId, Info: err.Error()})
+				continue
+			}
+			err, errType = c.checkCurrencyOpen(v.Currency, PauseTrading)
+			if errType == CheckErr {
+				failInfos = append(failInfos, FailInfo{Id: v.OrderId, Info: err.Error()})
+				continue
+			}
+		}
+
+		var account *Account
+		var errType ErrType
+		if islock {
+			account, err, errType = c.checkNonce(v.Owner, v.Nonce)
+			if errType == "" && nonces[v.Owner] != 0 && v.Nonce <= nonces[v.Owner] {
+				err, errType = fmt.Errorf("The nonce [%d] of account [%s] must be above [%d]", v.Nonce, v.Owner, nonces[v.Owner]), CheckErr
+			}
+			if errType == CheckErr {
+				failInfos = append(failInfos, FailInfo{Id: v.OrderId, Info: err.Error()})
+				continue
+			} else if errType == WorldStateErr {
+				myLogger.Errorf("lock error6:%s", err)
+				return shim.Error(err.Error())
+			}
+		}
+
+		err, errType = c.lockOrUnlockOrder(v.Owner, v.Currency, v.OrderId, v.Count, islock, v.Nonce)
+		if err == ExecedErr {
+			// locked or unlocked before the registry
+			duplicates = append(duplicates, v.OrderId)
+			continue
+		} else if errType == CheckErr {
 			failInfos = append(failInfos, FailInfo{Id: v.OrderId, Info: err.Error()})
 			continue
 		} else if errType == WorldStateErr {
 			myLogger.Errorf("lock error2:%s", err)
 			return shim.Error(err.Error())
 		}
+
+		if account != nil {
+			nonces[v.Owner] = v.Nonce
+			account.Nonce = v.Nonce
+			err = c.putAccount(account)
+			if err != nil {
+				myLogger.Errorf("lock error9:%s", err)
+				return shim.Error(err.Error())
+			}
+		}
+
+		err = c.putProcessed(kind, v.OrderId, "")
+		if err != nil {
+			myLogger.Errorf("lock error7:%s", err)
+			return shim.Error(err.Error())
+		}
+		processedIDs[v.OrderId] = true
 		successInfos = append(successInfos, v.OrderId)
 	}
 
-	batch := BatchResult{EventName: "chaincode_lock", Success: successInfos, Fail: failInfos, SrcMethod: c.args[2]}
+	batch := BatchResult{EventName: "chaincode_lock", Success: successInfos, Fail: failInfos, SrcMethod: c.args[2], Duplicate: duplicates}
 	result, err := json.Marshal(&batch)
 	if err != nil {
 		myLogger.Errorf("lock error3:%s", err)
 		return shim.Error(err.Error())
 	}
+	if batchID != "" {
+		err = c.putProcessed(ProcessedBatch, batchID, string(result))
+		if err != nil {
+			myLogger.Errorf("lock error8:%s", err)
+			return shim.Error(err.Error())
+		}
+	}
 
 	c.stub.SetEvent(batch.EventName, result)
 
@@ -317,26 +805,76 @@ func (c *ExchangeChaincode) lock() pb.Response {
 }
 
 // exchange exchange asset
-// args: exchange order 1, exchange order 2
+// args: exchange orders[, batch id]
+// a confidential exchange passes the orders and the key in the transient map (see TransientOrders), args: [batch id]
+// a processed batch id returns AlreadyProcessed, processed matches are reported as duplicates
 func (c *ExchangeChaincode) exchange() pb.Response {
 	myLogger.Debug("Exchange...")
 
-	if len(c.args) != 1 {
-		return shim.Error("Incorrect number of arguments. Expecting 1")
+	orders, key, err := c.getTransientOrders()
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	c.sealKey = key
+	batchArgs := c.args
+	if orders == nil {
+		if len(c.args) != 1 && len(c.args) != 2 {
+			return shim.Error("Incorrect number of arguments. Expecting 1 or 2")
+		}
+		orders = []byte(c.args[0])
+		batchArgs = c.args[1:]
+	} else if len(c.args) > 1 {
+		return shim.Error("Incorrect number of arguments. Expecting 0 or 1 with transient orders")
+	}
+	batchID := ""
+	if len(batchArgs) == 1 {
+		batchID = batchArgs[0]
+	}
+
+	replay, err := c.checkBatch(batchID)
+	if err != nil {
+		myLogger.Errorf("exchange error13:%s", err)
+		return shim.Error(err.Error())
+	}
+	if replay != nil {
+		return *replay
 	}
 
 	var exchangeOrders []struct {
 		BuyOrder  Order `json:"buyOrder"`
 		SellOrder Order `json:"sellOrder"`
 	}
-	err := json.Unmarshal([]byte(c.args[0]), &exchangeOrders)
+	err = json.Unmarshal(orders, &exchangeOrders)
 	if err != nil {
 		myLogger.Errorf("exchange error1:%s", err)
 		return shim.Error("Failed unmarshalling order")
 	}
 
+	oracle, err := c.getOracleConfig()
+	if err != nil {
+		myLogger.Errorf("exchange error7:%s", err)
+		return shim.Error(err.Error())
+	}
+
+	signatureRequired, err := c.isOrderSignatureRequired()
+	if err != nil {
+		myLogger.Errorf("exchange error11:%s", err)
+		return shim.Error(err.Error())
+	}
+
 	var successInfos []string
 	var failInfos []FailInfo
+	var duplicates []string
+	var halted []string
+
+	// ids processed by this batch
+	processedIDs := make(map[string]bool)
+	// ticker and candles, written after the batch
+	market := newMarketFills()
+	// trading volumes, written after the batch
+	volumes := newBatchVolumes()
+	// circuit breakers, written after the batch
+	breakers := newBatchBreakers()
 
 	for _, v := range exchangeOrders {
 		buyOrder := v.BuyOrder
@@ -348,29 +886,129 @@ func (c *ExchangeChaincode) exchange() pb.Response {
 			return shim.Error("The exchange is invalid")
 		}
 
+		// check the match is processed or not
+		match, err := c.getProcessed(ProcessedMatch, matchOrder)
+		if err != nil {
+			myLogger.Errorf("exchange error14:%s", err)
+			return shim.Error(err.Error())
+		}
+		if match != nil || processedIDs[matchOrder] {
+			duplicates = append(duplicates, matchOrder)
+			continue
+		}
+
 		// check exchanged or not
-		buy, err := c.getTxLog(buyOrder.UUID)
+		buy, err := c.isOrderExchanged(buyOrder.UUID)
 		if err != nil {
 			myLogger.Errorf("exchange error2:%s", err)
 			failInfos = append(failInfos, FailInfo{Id: matchOrder, Info: err.Error()})
 			continue
 		}
-		if buy != nil && buy.UUID != "" {
-			// exchanged
+		if buy || processedIDs[buyOrder.UUID] {
+			failInfos = append(failInfos, FailInfo{Id: matchOrder, Info: fmt.Sprintf("The order [%s] is already exchanged", buyOrder.UUID)})
+			continue
 		}
 
-		sell, err := c.getTxLog(sellOrder.UUID)
+		sell, err := c.isOrderExchanged(sellOrder.UUID)
 		if err != nil {
 			myLogger.Errorf("exchange error3:%s", err)
 			failInfos = append(failInfos, FailInfo{Id: matchOrder, Info: err.Error()})
 			continue
 		}
-		if sell != nil && sell.UUID != "" {
-			// exchanged
+		if sell || processedIDs[sellOrder.UUID] {
+			failInfos = append(failInfos, FailInfo{Id: matchOrder, Info: fmt.Sprintf("The order [%s] is already exchanged", sellOrder.UUID)})
+			continue
+		}
+
+		// check both accounts are active
+		err, errType := c.checkAccountActive(buyOrder.Account)
+		if errType == CheckErr {
+			failInfos = append(failInfos, FailInfo{Id: matchOrder, Info: err.Error()})
+			continue
+		}
+		err, errType = c.checkAccountActive(sellOrder.Account)
+		if errType == CheckErr {
+			failInfos = append(failInfos, FailInfo{Id: matchOrder, Info: err.Error()})
+			continue
+		}
+
+		// check the raw orders with sealed fills are filled with their key
+		err, errType = c.checkSealKey(&buyOrder)
+		if errType == CheckErr {
+			failInfos = append(failInfos, FailInfo{Id: matchOrder, Info: err.Error()})
+			continue
+		} else if errType == WorldStateErr {
+			myLogger.Errorf("exchange error17:%s", err)
+			return shim.Error(err.Error())
+		}
+		err, errType = c.checkSealKey(&sellOrder)
+		if errType == CheckErr {
+			failInfos = append(failInfos, FailInfo{Id: matchOrder, Info: err.Error()})
+			continue
+		} else if errType == WorldStateErr {
+			myLogger.Errorf("exchange error17:%s", err)
+			return shim.Error(err.Error())
+		}
+
+		// check both orders are signed by their accounts
+		err, errType = c.checkOrderSignature(&buyOrder, signatureRequired)
+		if errType == CheckErr {
+			failInfos = append(failInfos, FailInfo{Id: matchOrder, Info: err.Error()})
+			continue
+		} else if errType == WorldStateErr {
+			myLogger.Errorf("exchange error12:%s", err)
+			return shim.Error(err.Error())
+		}
+		err, errType = c.checkOrderSignature(&sellOrder, signatureRequired)
+		if errType == CheckErr {
+			failInfos = append(failInfos, FailInfo{Id: matchOrder, Info: err.Error()})
+			continue
+		} else if errType == WorldStateErr {
+			myLogger.Errorf("exchange error12:%s", err)
+			return shim.Error(err.Error())
+		}
+
+		// check trading is not halted
+		err, errType = c.checkCurrencyOpen(buyOrder.SrcCurrency, PauseTrading)
+		if errType == CheckErr {
+			failInfos = append(failInfos, FailInfo{Id: matchOrder, Info: err.Error()})
+			continue
+		}
+		err, errType = c.checkCurrencyOpen(buyOrder.DesCurrency, PauseTrading)
+		if errType == CheckErr {
+			failInfos = append(failInfos, FailInfo{Id: matchOrder, Info: err.Error()})
+			continue
+		}
+
+		// check the price of both legs against the oracle
+		if oracle != nil && oracle.Chaincode != "" {
+			err = c.checkOraclePrice(oracle, &buyOrder)
+			if err != nil {
+				failInfos = append(failInfos, FailInfo{Id: matchOrder, Info: err.Error()})
+				continue
+			}
+			err = c.checkOraclePrice(oracle, &sellOrder)
+			if err != nil {
+				failInfos = append(failInfos, FailInfo{Id: matchOrder, Info: err.Error()})
+				continue
+			}
+		}
+
+		// check price against the circuit breaker
+		tripped, err, errType := c.checkCircuitBreaker(breakers, &buyOrder)
+		if tripped != nil {
+			halted = append(halted, tripped.Base+"/"+tripped.Quote)
+		}
+		if errType == CheckErr {
+			failInfos = append(failInfos, FailInfo{Id: matchOrder, Info: err.Error()})
+			continue
+		} else if errType == WorldStateErr {
+			myLogger.Errorf("exchange error8:%s", err)
+			return shim.Error(err.Error())
 		}
 
 		// execTx
-		err, errType := c.execTx(&buyOrder, &sellOrder)
+		err, errType = c.execTx(volumes, &buyOrder, &sellOrder)
 		if errType == CheckErr && err != ExecedErr {
 			failInfos = append(failInfos, FailInfo{Id: matchOrder, Info: err.Error()})
 			continue
@@ -379,6 +1017,29 @@ func (c *ExchangeChaincode) exchange() pb.Response {
 			return shim.Error(err.Error())
 		}
 
+		c.fillCircuitBreaker(breakers, &buyOrder)
+
+		err = c.recordMatch(matchOrder, buyOrder.UUID, sellOrder.UUID)
+		if err != nil {
+			myLogger.Errorf("exchange error15:%s", err)
+			return shim.Error(err.Error())
+		}
+		processedIDs[matchOrder] = true
+		processedIDs[buyOrder.UUID] = true
+		processedIDs[sellOrder.UUID] = true
+
+		// confidential txlog, the price is not published to the market data
+		if key != nil {
+			err = c.putSealedTxLog(key, &buyOrder, &sellOrder)
+			if err != nil {
+				myLogger.Errorf("exchange error10:%s", err)
+				return shim.Error(err.Error())
+			}
+
+			successInfos = append(successInfos, matchOrder)
+			continue
+		}
+
 		// txlog
 		err = c.putTxLog(&buyOrder, &sellOrder)
 		if err != nil {
@@ -386,147 +1047,144 @@ func (c *ExchangeChaincode) exchange() pb.Response {
 			return shim.Error(err.Error())
 		}
 
+		market.add(&buyOrder)
 		successInfos = append(successInfos, matchOrder)
 	}
 
-	batch := BatchResult{EventName: "chaincode_exchange", Success: successInfos, Fail: failInfos}
+	err = c.putMarketData(market)
+	if err != nil {
+		myLogger.Errorf("exchange error9:%s", err)
+		return shim.Error(err.Error())
+	}
+	err = c.putVolumes(volumes)
+	if err != nil {
+		myLogger.Errorf("exchange error18:%s", err)
+		return shim.Error(err.Error())
+	}
+	err = c.putCircuitBreakers(breakers)
+	if err != nil {
+		myLogger.Errorf("exchange error19:%s", err)
+		return shim.Error(err.Error())
+	}
+
+	batch := BatchResult{EventName: "chaincode_exchange", Success: successInfos, Fail: failInfos, Duplicate: duplicates, Halted: halted}
 	result, err := json.Marshal(&batch)
 	if err != nil {
 		myLogger.Errorf("exchange error6:%s", err)
 		return shim.Error(err.Error())
 	}
+	if batchID != "" {
+		err = c.putProcessed(ProcessedBatch, batchID, string(result))
+		if err != nil {
+			myLogger.Errorf("exchange error16:%s", err)
+			return shim.Error(err.Error())
+		}
+	}
 	c.stub.SetEvent(batch.EventName, result)
 
 	myLogger.Debug("Exchange...done")
 	return shim.Success(nil)
 }
 
-// execTx execTx
-func (c *ExchangeChaincode) execTx(buyOrder, sellOrder *Order) (error, ErrType) {
-	// UUID=rawuuID
-	if buyOrder.IsBuyAll && buyOrder.UUID == buyOrder.RawUUID {
-		unlock, err := c.computeBalance(buyOrder.Account, buyOrder.SrcCurrency, buyOrder.DesCurrency, buyOrder.RawUUID, buyOrder.FinalCost)
-		if err != nil {
-			myLogger.Errorf("execTx error1:%s", err)
-			return errors.New("Failed compute balance"), CheckErr
-		}
-		myLogger.Debugf("Order %s balance %d", buyOrder.UUID, unlock)
-		if unlock > 0 {
-			err, errType := c.lockOrUnlockBalance(buyOrder.Account, buyOrder.SrcCurrency, buyOrder.RawUUID, unlock, false)
-			if err != nil {
-				myLogger.Errorf("execTx error2:%s", err)
-				return errors.New("Failed unlock balance"), errType
-			}
-		}
-	}
-
-	// buy order srcCurrency -
-	buySrcAsset, err := c.getOwnerOneAsset(buyOrder.Account, buyOrder.SrcCurrency)
+// execTx execTx, the trading volume is consumed in volumes and written after the batch
+func (c *ExchangeChaincode) execTx(volumes *batchVolumes, buyOrder, sellOrder *Order) (error, ErrType) {
+	// check trading limits before any change
+	buyVolume, err, errType := c.checkLimit(volumes, buyOrder.Account, buyOrder.SrcCurrency, buyOrder.FinalCost)
 	if err != nil {
-		myLogger.Errorf("execTx error3:%s", err)
-		return fmt.Errorf("Failed retrieving asset [%s] of the user: [%s]", buyOrder.SrcCurrency, err), CheckErr
-	}
-	if buySrcAsset == nil || buySrcAsset.UUID == "" {
-		return fmt.Errorf("The user have not currency [%s]", buyOrder.SrcCurrency), CheckErr
+		return err, errType
 	}
-	buySrcAsset.LockCount = buySrcAsset.LockCount - buyOrder.FinalCost
-	err = c.putAsset(buySrcAsset)
+	sellVolume, err, errType := c.checkLimit(volumes, sellOrder.Account, sellOrder.SrcCurrency, sellOrder.FinalCost)
 	if err != nil {
-		myLogger.Errorf("execTx error4:%s", err)
-		return errors.New("Failed updating row"), WorldStateErr
+		return err, errType
 	}
 
-	// buy order srcCurrency +
-	buyDesAsset, err := c.getOwnerOneAsset(buyOrder.Account, buyOrder.DesCurrency)
+	err, errType = c.settleOrder(buyOrder)
 	if err != nil {
-		myLogger.Errorf("execTx error5:%s", err)
-		return fmt.Errorf("Failed retrieving asset [%s] of the user: [%s]", buyOrder.DesCurrency, err), CheckErr
+		return err, errType
 	}
-	if buyDesAsset == nil || buyDesAsset.UUID == "" {
-		err = c.putAsset(&Asset{
-			Owner:     buyOrder.Account,
-			Currency:  buyOrder.DesCurrency,
-			Count:     buyOrder.DesCount,
-			LockCount: int64(0),
-		})
-
-		if err != nil {
-			myLogger.Errorf("execTx error6:%s", err)
-			return errors.New("Failed inserting row"), WorldStateErr
-		}
-	} else {
-		buyDesAsset.Count = buyDesAsset.Count + buyOrder.DesCount
-		err = c.putAsset(buyDesAsset)
-		if err != nil {
-			myLogger.Errorf("execTx error7:%s", err)
-			return errors.New("Failed updating row"), WorldStateErr
-		}
+	err, errType = c.settleOrder(sellOrder)
+	if err != nil {
+		return err, errType
 	}
 
+	// consume trading volume
+	volumes.add(buyVolume, buyOrder.FinalCost)
+	volumes.add(sellVolume, sellOrder.FinalCost)
+	return nil, ErrType("")
+}
+
+// settleOrder settle the locked srcCurrency and the desCurrency of a filled order,
+// the remaining lock of a buy-all raw order is released
+func (c *ExchangeChaincode) settleOrder(order *Order) (error, ErrType) {
 	// UUID=rawuuid
-	if sellOrder.IsBuyAll && sellOrder.UUID == sellOrder.RawUUID {
-		unlock, err := c.computeBalance(sellOrder.Account, sellOrder.SrcCurrency, sellOrder.DesCurrency, sellOrder.RawUUID, sellOrder.FinalCost)
+	if order.IsBuyAll && order.UUID == order.RawUUID {
+		unlock, err := c.computeBalance(order.Account, order.SrcCurrency, order.DesCurrency, order.RawUUID, order.FinalCost)
 		if err != nil {
-			myLogger.Errorf("execTx error8:%s", err)
+			myLogger.Errorf("settleOrder error1:%s", err)
 			return errors.New("Failed compute balance"), CheckErr
 		}
-		myLogger.Debugf("Order %s balance %d", sellOrder.UUID, unlock)
+		myLogger.Debugf("Order %s balance %d", order.UUID, unlock)
 		if unlock > 0 {
-			err, errType := c.lockOrUnlockBalance(sellOrder.Account, sellOrder.SrcCurrency, sellOrder.RawUUID, unlock, false)
+			err, errType := c.lockOrUnlockBalance(order.Account, order.SrcCurrency, order.RawUUID, unlock, false)
 			if err != nil {
-				myLogger.Errorf("execTx error9:%s", err)
+				myLogger.Errorf("settleOrder error2:%s", err)
 				return errors.New("Failed unlock balance"), errType
 			}
 		}
 	}
 
-	// sell order srcCurrency -
-	sellSrcAsset, err := c.getOwnerOneAsset(sellOrder.Account, sellOrder.SrcCurrency)
+	// order srcCurrency -
+	srcAsset, err := c.getOwnerOneAsset(order.Account, order.SrcCurrency)
 	if err != nil {
-		myLogger.Errorf("execTx error10:%s", err)
-		return fmt.Errorf("Failed retrieving asset [%s] of the user: [%s]", sellOrder.SrcCurrency, err), CheckErr
+		myLogger.Errorf("settleOrder error3:%s", err)
+		return fmt.Errorf("Failed retrieving asset [%s] of the user: [%s]", order.SrcCurrency, err), CheckErr
+	}
+	if srcAsset == nil || srcAsset.UUID == "" {
+		return fmt.Errorf("The user have not currency [%s]", order.SrcCurrency), CheckErr
 	}
-	if sellSrcAsset == nil || sellSrcAsset.UUID == "" {
-		return fmt.Errorf("The user have not currency [%s]", sellOrder.SrcCurrency), CheckErr
+	if srcAsset.LockCount < order.FinalCost {
+		return fmt.Errorf("The locked count [%d] of currency [%s] is insufficient", srcAsset.LockCount, order.SrcCurrency), CheckErr
 	}
-	sellSrcAsset.LockCount = sellSrcAsset.LockCount - sellOrder.FinalCost
-	err = c.putAsset(sellSrcAsset)
+	srcAsset.LockCount = srcAsset.LockCount - order.FinalCost
+	err = c.putAsset(srcAsset)
 	if err != nil {
-		myLogger.Errorf("execTx error11:%s", err)
+		myLogger.Errorf("settleOrder error4:%s", err)
 		return errors.New("Failed updating row"), WorldStateErr
 	}
 
-	// sell order desCurrency +
-	sellDesAsset, err := c.getOwnerOneAsset(sellOrder.Account, sellOrder.DesCurrency)
+	// order desCurrency +
+	desAsset, err := c.getOwnerOneAsset(order.Account, order.DesCurrency)
 	if err != nil {
-		myLogger.Errorf("execTx error12:%s", err)
-		return fmt.Errorf("Failed retrieving asset [%s] of the user: [%s]", sellOrder.DesCurrency, err), CheckErr
+		myLogger.Errorf("settleOrder error5:%s", err)
+		return fmt.Errorf("Failed retrieving asset [%s] of the user: [%s]", order.DesCurrency, err), CheckErr
 	}
-	if sellDesAsset == nil || sellDesAsset.UUID == "" {
+	if desAsset == nil || desAsset.UUID == "" {
 		err = c.putAsset(&Asset{
-			Owner:     sellOrder.Account,
-			Currency:  sellOrder.DesCurrency,
-			Count:     sellOrder.DesCount,
+			Owner:     order.Account,
+			Currency:  order.DesCurrency,
+			Count:     order.DesCount,
 			LockCount: int64(0),
 		})
+
 		if err != nil {
-			myLogger.Errorf("execTx error13:%s", err)
+			myLogger.Errorf("settleOrder error6:%s", err)
 			return errors.New("Failed inserting row"), WorldStateErr
 		}
 	} else {
-		sellDesAsset.Count = sellDesAsset.Count + sellOrder.DesCount
-		err = c.putAsset(sellDesAsset)
+		desAsset.Count = desAsset.Count + order.DesCount
+		err = c.putAsset(desAsset)
 		if err != nil {
-			myLogger.Errorf("execTx error14:%s", err)
+			myLogger.Errorf("settleOrder error7:%s", err)
 			return errors.New("Failed updating row"), WorldStateErr
 		}
 	}
+
 	return nil, ErrType("")
 }
 
 // computeBalance
 func (c *ExchangeChaincode) computeBalance(owner string, srcCurrency, desCurrency, rawUUID string, currentCost int64) (int64, error) {
-	txs, err := c.getTXs(owner, srcCurrency, desCurrency, rawUUID)
+	fills, err := c.getRawFills(owner, srcCurrency, desCurrency, rawUUID)
 	if err != nil {
 		return 0, err
 	}
@@ -538,17 +1196,16 @@ func (c *ExchangeChaincode) computeBalance(owner string, srcCurrency, desCurrenc
 		return 0, errors.New("can't find lock log")
 	}
 
-	lock := lockLog.LockCount
-	sumCost := int64(0)
-	for _, tx := range txs {
-		sumCost += tx.FinalCost
-	}
-
-	return lock - sumCost - currentCost, nil
+	return lockLog.LockCount - fills.FinalCost - currentCost, nil
 }
 
 // lockOrUnlockBalance lockOrUnlockBalance
 func (c *ExchangeChaincode) lockOrUnlockBalance(owner string, currency, order string, count int64, islock bool) (error, ErrType) {
+	return c.lockOrUnlockOrder(owner, currency, order, count, islock, 0)
+}
+
+// lockOrUnlockOrder lockOrUnlockBalance of an order, the lock log keeps the nonce the order is signed with
+func (c *ExchangeChaincode) lockOrUnlockOrder(owner string, currency, order string, count int64, islock bool, nonce int64) (error, ErrType) {
 	asset, err := c.getOwnerOneAsset(owner, currency)
 	if err != nil {
 		return fmt.Errorf("Failed retrieving asset [%s] of the user: [%s]", currency, err), CheckErr
@@ -572,6 +1229,14 @@ func (c *ExchangeChaincode) lockOrUnlockBalance(owner string, currency, order st
 		return ExecedErr, CheckErr
 	}
 
+	// the volume is only consumed when the order is filled
+	if islock {
+		_, err, errType := c.checkLimit(newBatchVolumes(), owner, currency, count)
+		if err != nil {
+			return err, errType
+		}
+	}
+
 	if islock {
 		asset.Count = asset.Count - count
 		asset.LockCount = asset.LockCount + count
@@ -591,7 +1256,72 @@ func (c *ExchangeChaincode) lockOrUnlockBalance(owner string, currency, order st
 		Order:     order,
 		IsLock:    islock,
 		LockCount: count,
-		LockTime:  time.Now().Unix(),
+		LockTime:  c.txTime(),
+		Nonce:     nonce,
+	})
+	if err != nil {
+		return err, WorldStateErr
+	}
+
+	return nil, ErrType("")
+}
+
+// settleLocked move count from the locked balance of owner to the available balance of the active receiver, ref is the htlc or escrow
+func (c *ExchangeChaincode) settleLocked(owner, currency, ref string, count int64, receiver string) (error, ErrType) {
+	err, errType := c.checkAccountActive(receiver)
+	if err != nil {
+		return err, errType
+	}
+
+	// owner lockCount -
+	ownerAsset, err := c.getOwnerOneAsset(owner, currency)
+	if err != nil {
+		return fmt.Errorf("Failed retrieving asset [%s] of the user: [%s]", currency, err), CheckErr
+	}
+	if ownerAsset == nil || ownerAsset.UUID == "" {
+		return fmt.Errorf("The user have not currency [%s]", currency), CheckErr
+	}
+	if ownerAsset.LockCount < count {
+		return fmt.Errorf("Locked currency [%s] of the user is insufficient", currency), CheckErr
+	}
+	ownerAsset.LockCount = ownerAsset.LockCount - count
+	err = c.putAsset(ownerAsset)
+	if err != nil {
+		return err, WorldStateErr
+	}
+
+	// receiver count +
+	receiverAsset, err := c.getOwnerOneAsset(receiver, currency)
+	if err != nil {
+		return fmt.Errorf("Failed retrieving asset [%s] of the user: [%s]", currency, err), CheckErr
+	}
+	if receiverAsset == nil || receiverAsset.UUID == "" {
+		receiverAsset = &Asset{
+			Owner:     receiver,
+			Currency:  currency,
+			LockCount: int64(0),
+		}
+	}
+	receiverAsset.Count = receiverAsset.Count + count
+	err = c.putAsset(receiverAsset)
+	if err != nil {
+		return err, WorldStateErr
+	}
+
+	err = c.putStatement(owner, currency, &StatementEntry{
+		Kind:         StatementSettle,
+		Ref:          ref,
+		Counterparty: receiver,
+		Locked:       -count,
+	})
+	if err != nil {
+		return err, WorldStateErr
+	}
+	err = c.putStatement(receiver, currency, &StatementEntry{
+		Kind:         StatementSettle,
+		Ref:          ref,
+		Counterparty: owner,
+		Available:    count,
 	})
 	if err != nil {
 		return err, WorldStateErr
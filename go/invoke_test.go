@@ -0,0 +1,219 @@
+package main
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func TestMintDisabled(t *testing.T) {
+	e := newTestEnv(t)
+
+	// currencies default to mintable, like the ones created before the flag
+	e.mustInvoke("root", "create", "EUR", "100", "")
+	e.mustInvoke("root", "release", "EUR", "10")
+
+	e.mustInvoke("root", "create", "GBP", "100", "", `{"mintDisabled":true}`)
+	if msg := e.mustFail("root", "release", "GBP", "10"); !strings.Contains(msg, "not mintable") {
+		t.Fatalf("release: %s", msg)
+	}
+}
+
+func TestReleaseAndAssignCreator(t *testing.T) {
+	e := newTestEnv(t)
+	e.openAccount("alice")
+	e.openAccount("mallory")
+	e.mustInvoke("alice", "create", "EUR", "100", "")
+
+	// without an issuer set only the creator or an admin issues
+	e.mustFail("mallory", "release", "EUR", "1000")
+	assign := `{"currency":"EUR","assigns":[{"owner":"mallory","count":100}]}`
+	e.mustFail("mallory", "assign", assign)
+	if got := e.asset("mallory", "EUR").Count; got != 0 {
+		t.Fatalf("mallory EUR: got %d, expecting 0", got)
+	}
+
+	e.mustInvoke("alice", "release", "EUR", "10")
+	e.mustInvoke("root", "release", "EUR", "10")
+	e.mustInvoke("alice", "assign", `{"currency":"EUR","assigns":[{"owner":"alice","count":120}]}`)
+	if got := e.asset("alice", "EUR").Count; got != 120 {
+		t.Fatalf("alice EUR: got %d, expecting 120", got)
+	}
+}
+
+func TestBurn(t *testing.T) {
+	e := newTestEnv(t)
+	e.mustInvoke("root", "create", "EUR", "100", "", `{"burnable":true}`)
+
+	e.mustFail("alice", "burn", "EUR", "10")
+	e.mustFail("root", "burn", "EUR", "1000")
+	e.mustInvoke("root", "burn", "EUR", "10")
+
+	c := &ExchangeChaincode{stub: e.stub}
+	curr, err := c.getCurrencyByName("EUR")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if curr.Count != 90 || curr.LeftCount != 90 {
+		t.Fatalf("EUR after burn: %+v", curr)
+	}
+
+	bb, err := c.getCompositeValue("BurnLog~currency~uuid", []string{"EUR"}, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(bb) != 1 {
+		t.Fatalf("got %d burn logs, expecting 1", len(bb))
+	}
+	log := new(BurnLog)
+	err = json.Unmarshal(bb[0], log)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if log.DocType != DocBurnLog || log.Count != 10 {
+		t.Fatalf("burn log: %+v", log)
+	}
+
+	// the release logs only hold releases
+	logs, err := c.getMyReleaseLog(curr.Creator)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, v := range logs {
+		if v.Count < 0 {
+			t.Fatalf("negative release log: %+v", v)
+		}
+	}
+}
+
+func TestSettleLockedCount(t *testing.T) {
+	e := newTestEnv(t)
+	e.openAccount("alice")
+	e.openAccount("bob")
+	e.fund("alice", "EUR", 1000)
+	e.fund("bob", "BTC", 1000)
+	e.lockOrder("alice", "EUR", "b1", 100)
+	e.lockOrder("bob", "BTC", "s1", 10)
+
+	// the fill may not spend more than is locked
+	if batch := e.exchange(match("alice", "bob", "EUR", "BTC", 150, 10, "1")); len(batch.Fail) != 1 ||
+		!strings.Contains(batch.Fail[0].Info, "insufficient") {
+		t.Fatalf("fill over the lock: %+v", batch)
+	}
+	if a := e.asset("alice", "EUR"); a.Count != 900 || a.LockCount != 100 {
+		t.Fatalf("alice EUR: %+v", a)
+	}
+}
+
+// indexedUUIDs the uuids of an index for the attribute
+func (e *testEnv) indexedUUIDs(index, attr string) []string {
+	iter, err := e.stub.GetStateByPartialCompositeKey(index, []string{attr})
+	if err != nil {
+		e.t.Fatal(err)
+	}
+	defer iter.Close()
+
+	var uuids []string
+	for iter.HasNext() {
+		key, _, err := iter.Next()
+		if err != nil {
+			e.t.Fatal(err)
+		}
+		_, parts, err := e.stub.SplitCompositeKey(key)
+		if err != nil {
+			e.t.Fatal(err)
+		}
+		uuids = append(uuids, parts[len(parts)-1])
+	}
+	return uuids
+}
+
+func TestReverseAssign(t *testing.T) {
+	e := newTestEnv(t)
+	e.openAccount("alice")
+	e.openAccount("bob")
+	e.fund("alice", "EUR", 100)
+	e.mustInvoke("alice", "transfer", "EUR", "bob", "30", "")
+
+	// a transfer log is not an assign log
+	transfers := e.indexedUUIDs("TransferLog~from~uuid", "alice")
+	if len(transfers) != 1 {
+		t.Fatalf("got %d transfer logs, expecting 1", len(transfers))
+	}
+	if msg := e.mustFail("root", "reverseAssign", transfers[0], "wrong"); !strings.Contains(msg, "does not exist") {
+		t.Fatalf("reverse of a transfer log: %s", msg)
+	}
+
+	assigns := e.indexedUUIDs("AssignLog~to~uuid", "alice")
+	if len(assigns) != 1 {
+		t.Fatalf("got %d assign logs, expecting 1", len(assigns))
+	}
+	e.mustFail("alice", "reverseAssign", assigns[0], "wrong")
+
+	// 70 of the 100 assigned are left
+	e.mustFail("root", "reverseAssign", assigns[0], "wrong")
+	e.mustInvoke("bob", "transfer", "EUR", "alice", "30", "")
+	e.mustInvoke("root", "reverseAssign", assigns[0], "wrong")
+	if got := e.asset("alice", "EUR").Count; got != 0 {
+		t.Fatalf("alice EUR after reverse: got %d, expecting 0", got)
+	}
+	if msg := e.mustFail("root", "reverseAssign", assigns[0], "wrong"); !strings.Contains(msg, "already reversed") {
+		t.Fatalf("second reverse: %s", msg)
+	}
+}
+
+func TestReverseAssignByProposal(t *testing.T) {
+	e := newTestEnv(t)
+	e.openAccount("bob")
+	e.fund("bob", "EUR", 30)
+	assigns := e.indexedUUIDs("AssignLog~to~uuid", "bob")
+	if len(assigns) != 1 {
+		t.Fatalf("got %d assign logs, expecting 1", len(assigns))
+	}
+	e.mustInvoke("root", "setIssuers", "EUR", `["Org1MSP/i1","Org1MSP/i2"]`, "2")
+
+	// the issuers reverse like they assign
+	if msg := e.mustFail("root", "reverseAssign", assigns[0], "wrong"); !strings.Contains(msg, "not an issuer") {
+		t.Fatalf("reverse by the creator: %s", msg)
+	}
+	id := string(e.mustInvoke("i1", "reverseAssign", assigns[0], "wrong"))
+	if got := e.asset("bob", "EUR").Count; got != 30 {
+		t.Fatalf("bob EUR before the approval: got %d, expecting 30", got)
+	}
+	e.mustInvoke("i2", "approveProposal", id)
+	if got := e.asset("bob", "EUR").Count; got != 0 {
+		t.Fatalf("bob EUR after the approval: got %d, expecting 0", got)
+	}
+}
+
+func TestTransferLog(t *testing.T) {
+	e := newTestEnv(t)
+	e.now = 1500000000
+	e.openAccount("alice")
+	e.openAccount("bob")
+	e.fund("alice", "EUR", 100)
+	e.fund("bob", "EUR", 100)
+
+	e.mustInvoke("alice", "transfer", "EUR", "bob", "30", "rent")
+	e.now += 10
+	e.mustInvoke("bob", "transfer", "EUR", "alice", "5", "refund")
+
+	logs := new(struct {
+		ToMe []*TransferLog `json:"toMe"`
+		MeTo []*TransferLog `json:"meTo"`
+	})
+	err := json.Unmarshal(e.mustInvoke("alice", "queryMyTransferLog", "alice"), logs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(logs.MeTo) != 1 || len(logs.ToMe) != 1 {
+		t.Fatalf("alice transfer logs: %+v", logs)
+	}
+	sent, received := logs.MeTo[0], logs.ToMe[0]
+	if sent.FromUser != "alice" || sent.ToUser != "bob" || sent.Count != 30 || sent.Memo != "rent" || sent.TransferTime != 1500000000 {
+		t.Fatalf("sent log: %+v", sent)
+	}
+	if received.FromUser != "bob" || received.ToUser != "alice" || received.Count != 5 || received.Memo != "refund" {
+		t.Fatalf("received log: %+v", received)
+	}
+}
@@ -0,0 +1,372 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	pb "github.com/hyperledger/fabric/protos/peer"
+)
+
+const (
+	ProposalPending  = "pending"
+	ProposalExecuted = "executed"
+)
+
+// Proposal release, assign, assignVested, burn, reverseAssign or setIssuers of a currency with an issuer set, executed once the threshold of issuers approved
+type Proposal struct {
+	DocType    string   `json:"docType,omitempty"`
+	UUID       string   `json:"uuid"`
+	Currency   string   `json:"currency"`
+	Function   string   `json:"function"`
+	Args       []string `json:"args"`
+	Proposer   string   `json:"proposer"`
+	Approvals  []string `json:"approvals"`
+	Status     string   `json:"status"`
+	CreateTime int64    `json:"createTime"`
+	ExecTime   int64    `json:"execTime"`
+}
+
+func (c *ExchangeChaincode) putProposal(proposal *Proposal) error {
+	if proposal.UUID == "" {
+		proposal.UUID = GenerateUUID()
+	}
+	proposal.DocType = DocProposal
+	r, err := json.Marshal(proposal)
+	if err != nil {
+		return err
+	}
+
+	err = c.stub.PutState(proposal.UUID, r)
+	if err != nil {
+		return err
+	}
+
+	err = c.putCompositeValue("Proposal~currency~uuid", []string{proposal.Currency, proposal.UUID})
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+func (c *ExchangeChaincode) getProposal(key string) (*Proposal, error) {
+	proposalByte, err := c.stub.GetState(key)
+	if err != nil {
+		return nil, err
+	}
+	if proposalByte == nil {
+		return nil, nil
+	}
+
+	proposal := new(Proposal)
+	err = json.Unmarshal(proposalByte, proposal)
+	if err != nil {
+		return nil, err
+	}
+	return proposal, nil
+}
+
+func (c *ExchangeChaincode) getCurrencyProposals(currency string) ([]*Proposal, error) {
+	bb, err := c.getCompositeValue("Proposal~currency~uuid", []string{currency}, 1)
+	if err != nil {
+		return nil, err
+	}
+
+	var proposals []*Proposal
+	for _, v := range bb {
+		proposal := new(Proposal)
+		err = json.Unmarshal(v, proposal)
+		if err != nil {
+			return nil, err
+		}
+		proposals = append(proposals, proposal)
+	}
+	return proposals, nil
+}
+
+// proposeIssuance record the current release, assign, burn, reverseAssign or setIssuers as a proposal of the issuers, it is executed at once
+// when the threshold is 1
+func (c *ExchangeChaincode) proposeIssuance(curr *Currency, function string) pb.Response {
+	caller, err := c.getCaller()
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if !containsString(curr.Issuers, caller) {
+		return shim.Error(fmt.Sprintf("[%s] is not an issuer of currency [%s]", caller, curr.Name))
+	}
+
+	proposal := &Proposal{
+		UUID:       GenerateUUID(),
+		Currency:   curr.Name,
+		Function:   function,
+		Args:       c.args,
+		Proposer:   caller,
+		Approvals:  []string{caller},
+		Status:     ProposalPending,
+		CreateTime: c.txTime(),
+	}
+
+	return c.execProposal(curr, proposal)
+}
+
+// execProposal save the proposal, and execute it when the threshold of approvals is reached
+func (c *ExchangeChaincode) execProposal(curr *Currency, proposal *Proposal) pb.Response {
+	if len(proposal.Approvals) >= curr.IssuerThreshold {
+		args := c.args
+		c.args = proposal.Args
+		c.proposalApproved = true
+		defer func() {
+			c.args = args
+			c.proposalApproved = false
+		}()
+
+		var resp pb.Response
+		switch proposal.Function {
+		case "release":
+			resp = c.release()
+		case "assign":
+			resp = c.assign()
+		case "assignVested":
+			resp = c.assignVested()
+		case "burn":
+			resp = c.burn()
+		case "reverseAssign":
+			resp = c.reverseAssign()
+		case "setIssuers":
+			resp = c.setIssuers()
+		default:
+			return shim.Error(fmt.Sprintf("Unknown proposal function [%s]", proposal.Function))
+		}
+		if resp.Status != shim.OK {
+			return resp
+		}
+
+		proposal.Status = ProposalExecuted
+		proposal.ExecTime = c.txTime()
+	}
+
+	err := c.putProposal(proposal)
+	if err != nil {
+		myLogger.Errorf("execProposal error1:%s", err)
+		return shim.Error(err.Error())
+	}
+
+	return shim.Success([]byte(proposal.UUID))
+}
+
+// approveProposal approve a pending issuer proposal as the tx creator
+// args: proposal id
+func (c *ExchangeChaincode) approveProposal() pb.Response {
+	myLogger.Debug("Approve Proposal...")
+
+	if len(c.args) != 1 {
+		return shim.Error("Incorrect number of arguments. Expecting 1")
+	}
+
+	proposal, err := c.getProposal(c.args[0])
+	if err != nil {
+		myLogger.Errorf("approveProposal error1:%s", err)
+		return shim.Error(fmt.Sprintf("Failed retrieving proposal [%s]: [%s]", c.args[0], err))
+	}
+	if proposal == nil || proposal.UUID == "" {
+		return shim.Error(fmt.Sprintf("The proposal [%s] does not exist", c.args[0]))
+	}
+	if proposal.Status != ProposalPending {
+		return shim.Error(fmt.Sprintf("The proposal [%s] is %s", proposal.UUID, proposal.Status))
+	}
+
+	curr, err := c.getCurrencyByName(proposal.Currency)
+	if err != nil {
+		myLogger.Errorf("approveProposal error2:%s", err)
+		return shim.Error(fmt.Sprintf("Failed retrieving currency [%s]: [%s]", proposal.Currency, err))
+	}
+	if curr == nil {
+		return shim.Error(fmt.Sprintf("The currency [%s] does not exist", proposal.Currency))
+	}
+
+	caller, err := c.getCaller()
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if !containsString(curr.Issuers, caller) {
+		return shim.Error(fmt.Sprintf("[%s] is not an issuer of currency [%s]", caller, curr.Name))
+	}
+	if containsString(proposal.Approvals, caller) {
+		return shim.Success([]byte(proposal.UUID))
+	}
+	proposal.Approvals = append(proposal.Approvals, caller)
+
+	myLogger.Debug("Approve Proposal...done")
+	return c.execProposal(curr, proposal)
+}
+
+// setIssuers set the M-of-N issuer set of a currency, requires the creator or an admin,
+// or a proposal approved by the current issuers once the currency has an issuer set
+// args: currency id, json []issuer, threshold (0 disables the issuer set)
+func (c *ExchangeChaincode) setIssuers() pb.Response {
+	myLogger.Debug("Set Issuers...")
+
+	if len(c.args) != 3 {
+		return shim.Error("Incorrect number of arguments. Expecting 3")
+	}
+
+	name := c.args[0]
+	var issuers []string
+	err := json.Unmarshal([]byte(c.args[1]), &issuers)
+	if err != nil {
+		return shim.Error(fmt.Sprintf("Failed unmarshalling issuers: [%s]", err))
+	}
+	threshold, err := strconv.Atoi(c.args[2])
+	if err != nil || threshold < 0 || threshold > len(issuers) {
+		return shim.Error(fmt.Sprintf("The threshold must be between 0 and %d", len(issuers)))
+	}
+
+	curr, err := c.getCurrencyByName(name)
+	if err != nil {
+		myLogger.Errorf("setIssuers error1:%s", err)
+		return shim.Error(fmt.Sprintf("Failed retrieving currency [%s]: [%s]", name, err))
+	}
+	if curr == nil {
+		return shim.Error(fmt.Sprintf("The currency [%s] does not exist", name))
+	}
+
+	if curr.IssuerThreshold > 0 && !c.proposalApproved {
+		return c.proposeIssuance(curr, "setIssuers")
+	}
+	if !c.proposalApproved {
+		_, err = c.checkCreatorOrAdmin(curr)
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+	}
+
+	curr.Issuers = nil
+	for _, v := range issuers {
+		if v != "" && !containsString(curr.Issuers, v) {
+			curr.Issuers = append(curr.Issuers, v)
+		}
+	}
+	if threshold > len(curr.Issuers) {
+		return shim.Error(fmt.Sprintf("The threshold must be between 0 and %d", len(curr.Issuers)))
+	}
+	curr.IssuerThreshold = threshold
+
+	err = c.putCurrency(curr)
+	if err != nil {
+		myLogger.Errorf("setIssuers error2:%s", err)
+		return shim.Error(err.Error())
+	}
+
+	myLogger.Debug("Set Issuers...done")
+	return shim.Success(nil)
+}
+
+// proposeIssuerChange propose a new creator of a currency, requires the creator or an admin
+// args: currency id, new creator (mspid/common name)
+func (c *ExchangeChaincode) proposeIssuerChange() pb.Response {
+	myLogger.Debug("Propose Issuer Change...")
+
+	if len(c.args) != 2 {
+		return shim.Error("Incorrect number of arguments. Expecting 2")
+	}
+
+	name := c.args[0]
+	curr, err := c.getCurrencyByName(name)
+	if err != nil {
+		myLogger.Errorf("proposeIssuerChange error1:%s", err)
+		return shim.Error(fmt.Sprintf("Failed retrieving currency [%s]: [%s]", name, err))
+	}
+	if curr == nil {
+		return shim.Error(fmt.Sprintf("The currency [%s] does not exist", name))
+	}
+
+	_, err = c.checkCreatorOrAdmin(curr)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	curr.PendingCreator = c.args[1]
+	err = c.putCurrency(curr)
+	if err != nil {
+		myLogger.Errorf("proposeIssuerChange error2:%s", err)
+		return shim.Error(err.Error())
+	}
+
+	myLogger.Debug("Propose Issuer Change...done")
+	return shim.Success(nil)
+}
+
+// acceptIssuerChange the proposed creator takes over the currency
+// args: currency id
+func (c *ExchangeChaincode) acceptIssuerChange() pb.Response {
+	myLogger.Debug("Accept Issuer Change...")
+
+	if len(c.args) != 1 {
+		return shim.Error("Incorrect number of arguments. Expecting 1")
+	}
+
+	name := c.args[0]
+	curr, err := c.getCurrencyByName(name)
+	if err != nil {
+		myLogger.Errorf("acceptIssuerChange error1:%s", err)
+		return shim.Error(fmt.Sprintf("Failed retrieving currency [%s]: [%s]", name, err))
+	}
+	if curr == nil {
+		return shim.Error(fmt.Sprintf("The currency [%s] does not exist", name))
+	}
+
+	caller, err := c.getCaller()
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if curr.PendingCreator == "" || curr.PendingCreator != caller {
+		return shim.Error(fmt.Sprintf("[%s] is not the proposed creator of currency [%s]", caller, name))
+	}
+
+	err = c.delCompositeValue("Currency~owner~uuid", []string{curr.Creator, curr.UUID})
+	if err != nil {
+		myLogger.Errorf("acceptIssuerChange error2:%s", err)
+		return shim.Error(err.Error())
+	}
+
+	curr.Creator = caller
+	curr.PendingCreator = ""
+	err = c.putCurrency(curr)
+	if err != nil {
+		myLogger.Errorf("acceptIssuerChange error3:%s", err)
+		return shim.Error(err.Error())
+	}
+
+	myLogger.Debug("Accept Issuer Change...done")
+	return shim.Success(nil)
+}
+
+// queryProposals query the pending issuer proposals of a currency
+// args: currency id
+func (c *ExchangeChaincode) queryProposals() pb.Response {
+	myLogger.Debug("queryProposals...")
+
+	if len(c.args) != 1 {
+		return shim.Error("Incorrect number of arguments. Expecting 1")
+	}
+
+	proposals, err := c.getCurrencyProposals(c.args[0])
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	pending := []*Proposal{}
+	for _, v := range proposals {
+		if v.Status == ProposalPending {
+			pending = append(pending, v)
+		}
+	}
+
+	payload, err := json.Marshal(pending)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	return shim.Success(payload)
+}
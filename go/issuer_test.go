@@ -0,0 +1,100 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestSetIssuersByProposal(t *testing.T) {
+	e := newTestEnv(t)
+	c := &ExchangeChaincode{stub: e.stub}
+
+	// the creator is the caller
+	if msg := e.mustFail("alice", "create", "EUR", "100", "Org1MSP/bob"); !strings.Contains(msg, "not the caller") {
+		t.Fatalf("create for another creator: %s", msg)
+	}
+	e.mustInvoke("alice", "create", "EUR", "100", "")
+	curr, err := c.getCurrencyByName("EUR")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if curr.Creator != "Org1MSP/alice" {
+		t.Fatalf("got creator %s, expecting Org1MSP/alice", curr.Creator)
+	}
+
+	e.mustFail("bob", "setIssuers", "EUR", `["Org1MSP/i1","Org1MSP/i2","Org1MSP/i3"]`, "2")
+	e.mustInvoke("alice", "setIssuers", "EUR", `["Org1MSP/i1","Org1MSP/i2","Org1MSP/i3"]`, "2")
+
+	// the creator can't change the issuer set alone anymore
+	e.mustFail("alice", "setIssuers", "EUR", `["Org1MSP/alice"]`, "1")
+	id := string(e.mustInvoke("i1", "setIssuers", "EUR", `["Org1MSP/i1","Org1MSP/i2"]`, "1"))
+	curr, err = c.getCurrencyByName("EUR")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if curr.IssuerThreshold != 2 || len(curr.Issuers) != 3 {
+		t.Fatalf("issuers changed before the threshold: %+v", curr)
+	}
+
+	e.mustFail("alice", "approveProposal", id)
+	e.mustInvoke("i2", "approveProposal", id)
+	curr, err = c.getCurrencyByName("EUR")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if curr.IssuerThreshold != 1 || len(curr.Issuers) != 2 {
+		t.Fatalf("issuers after the approval: %+v", curr)
+	}
+
+	// a single issuer executes at once
+	e.mustInvoke("i1", "release", "EUR", "10")
+	curr, err = c.getCurrencyByName("EUR")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if curr.Count != 110 {
+		t.Fatalf("got count %d, expecting 110", curr.Count)
+	}
+}
+
+func TestIssuerChange(t *testing.T) {
+	e := newTestEnv(t)
+	c := &ExchangeChaincode{stub: e.stub}
+	e.mustInvoke("alice", "create", "EUR", "100", "")
+
+	if msg := e.mustFail("bob", "proposeIssuerChange", "EUR", "Org1MSP/carol"); !strings.Contains(msg, "no permission") {
+		t.Fatalf("proposal by another user: %s", msg)
+	}
+	e.mustInvoke("alice", "proposeIssuerChange", "EUR", "Org1MSP/carol")
+	if msg := e.mustFail("bob", "acceptIssuerChange", "EUR"); !strings.Contains(msg, "not the proposed creator") {
+		t.Fatalf("accept by another user: %s", msg)
+	}
+
+	// the proposal alone changes nothing
+	e.mustInvoke("alice", "release", "EUR", "10")
+	e.mustInvoke("carol", "acceptIssuerChange", "EUR")
+	curr, err := c.getCurrencyByName("EUR")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if curr.Creator != "Org1MSP/carol" || curr.PendingCreator != "" {
+		t.Fatalf("currency after the change: %+v", curr)
+	}
+	if uuids := e.indexedUUIDs("Currency~owner~uuid", "Org1MSP/alice"); len(uuids) != 0 {
+		t.Fatalf("currencies still indexed under the old creator: %v", uuids)
+	}
+	if uuids := e.indexedUUIDs("Currency~owner~uuid", "Org1MSP/carol"); len(uuids) != 1 || uuids[0] != curr.UUID {
+		t.Fatalf("currencies indexed under the new creator: %v", uuids)
+	}
+
+	e.mustFail("alice", "release", "EUR", "10")
+	e.mustFail("carol", "acceptIssuerChange", "EUR")
+	e.mustInvoke("carol", "release", "EUR", "10")
+	curr, err = c.getCurrencyByName("EUR")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if curr.Count != 120 {
+		t.Fatalf("got count %d, expecting 120", curr.Count)
+	}
+}
@@ -0,0 +1,299 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	pb "github.com/hyperledger/fabric/protos/peer"
+)
+
+const (
+	LimitScopeAccount = "account"
+	LimitScopeTier    = "tier"
+)
+
+// Limit trading limit of an account or a kyc tier on a currency, 0 means unlimited
+type Limit struct {
+	DocType     string `json:"docType,omitempty"`
+	Scope       string `json:"scope"`
+	Subject     string `json:"subject"`
+	Currency    string `json:"currency"`
+	PerTradeMax int64  `json:"perTradeMax"`
+	DailyMax    int64  `json:"dailyMax"`
+}
+
+// Volume traded volume of an account on a currency in one day
+type Volume struct {
+	DocType  string `json:"docType,omitempty"`
+	Account  string `json:"account"`
+	Currency string `json:"currency"`
+	Day      string `json:"day"`
+	Count    int64  `json:"count"`
+}
+
+func (c *ExchangeChaincode) putLimit(limit *Limit) error {
+	key, err := c.stub.CreateCompositeKey("Limit~scope~subject~currency", []string{limit.Scope, limit.Subject, limit.Currency})
+	if err != nil {
+		return err
+	}
+
+	limit.DocType = DocLimit
+	r, err := json.Marshal(limit)
+	if err != nil {
+		return err
+	}
+	return c.stub.PutState(key, r)
+}
+
+func (c *ExchangeChaincode) getLimit(scope, subject, currency string) (*Limit, error) {
+	key, err := c.stub.CreateCompositeKey("Limit~scope~subject~currency", []string{scope, subject, currency})
+	if err != nil {
+		return nil, err
+	}
+
+	limitByte, err := c.stub.GetState(key)
+	if err != nil {
+		return nil, err
+	}
+	if limitByte == nil {
+		return nil, nil
+	}
+
+	limit := new(Limit)
+	err = json.Unmarshal(limitByte, limit)
+	if err != nil {
+		return nil, err
+	}
+	return limit, nil
+}
+
+// getEffectiveLimit the account limit overrides the limit of its kyc tier
+func (c *ExchangeChaincode) getEffectiveLimit(owner, currency string) (*Limit, error) {
+	limit, err := c.getLimit(LimitScopeAccount, owner, currency)
+	if err != nil || limit != nil {
+		return limit, err
+	}
+
+	account, err := c.getAccount(owner)
+	if err != nil || account == nil {
+		return nil, err
+	}
+	return c.getLimit(LimitScopeTier, strconv.Itoa(account.KYCLevel), currency)
+}
+
+func (c *ExchangeChaincode) putVolume(volume *Volume) error {
+	key, err := c.stub.CreateCompositeKey("Volume~account~currency~day", []string{volume.Account, volume.Currency, volume.Day})
+	if err != nil {
+		return err
+	}
+
+	volume.DocType = DocVolume
+	r, err := json.Marshal(volume)
+	if err != nil {
+		return err
+	}
+	return c.stub.PutState(key, r)
+}
+
+func (c *ExchangeChaincode) getVolume(owner, currency, day string) (*Volume, error) {
+	key, err := c.stub.CreateCompositeKey("Volume~account~currency~day", []string{owner, currency, day})
+	if err != nil {
+		return nil, err
+	}
+
+	volumeByte, err := c.stub.GetState(key)
+	if err != nil {
+		return nil, err
+	}
+	if volumeByte == nil {
+		return &Volume{Account: owner, Currency: currency, Day: day}, nil
+	}
+
+	volume := new(Volume)
+	err = json.Unmarshal(volumeByte, volume)
+	if err != nil {
+		return nil, err
+	}
+	return volume, nil
+}
+
+// txDay the UTC day of the tx
+func (c *ExchangeChaincode) txDay() string {
+	return time.Unix(c.txTime(), 0).UTC().Format("20060102")
+}
+
+// batchVolumes volumes of the accounts in a batch. The writes of a transaction are not visible to its reads,
+// so the volumes are accumulated in memory and written once by putVolumes.
+type batchVolumes struct {
+	volumes  map[string]*Volume
+	consumed map[string]bool
+	keys     []string // consumed volumes in fill order, for a deterministic write set
+}
+
+func newBatchVolumes() *batchVolumes {
+	return &batchVolumes{volumes: make(map[string]*Volume), consumed: make(map[string]bool)}
+}
+
+// add consume count of a volume of the batch
+func (b *batchVolumes) add(volume *Volume, count int64) {
+	key := volume.Account + "\x00" + volume.Currency
+	if !b.consumed[key] {
+		b.consumed[key] = true
+		b.keys = append(b.keys, key)
+	}
+	volume.Count = volume.Count + count
+}
+
+// getBatchVolume the volume of the account in the batch, read from the state on first use
+func (c *ExchangeChaincode) getBatchVolume(volumes *batchVolumes, owner, currency string) (*Volume, error) {
+	key := owner + "\x00" + currency
+	volume, ok := volumes.volumes[key]
+	if ok {
+		return volume, nil
+	}
+
+	volume, err := c.getVolume(owner, currency, c.txDay())
+	if err != nil {
+		return nil, err
+	}
+	volumes.volumes[key] = volume
+	return volume, nil
+}
+
+// putVolumes write the volumes consumed by the batch
+func (c *ExchangeChaincode) putVolumes(volumes *batchVolumes) error {
+	for _, key := range volumes.keys {
+		err := c.putVolume(volumes.volumes[key])
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// checkLimit check count is inside the per trade and remaining daily limit of the account,
+// with the volume consumed earlier in the batch
+func (c *ExchangeChaincode) checkLimit(volumes *batchVolumes, owner, currency string, count int64) (*Volume, error, ErrType) {
+	limit, err := c.getEffectiveLimit(owner, currency)
+	if err != nil {
+		return nil, err, WorldStateErr
+	}
+
+	volume, err := c.getBatchVolume(volumes, owner, currency)
+	if err != nil {
+		return nil, err, WorldStateErr
+	}
+
+	if limit == nil {
+		return volume, nil, ErrType("")
+	}
+	if limit.PerTradeMax > 0 && count > limit.PerTradeMax {
+		return nil, fmt.Errorf("The count [%d] of currency [%s] exceeds the per trade limit [%d]", count, currency, limit.PerTradeMax), CheckErr
+	}
+	if limit.DailyMax > 0 && volume.Count+count > limit.DailyMax {
+		return nil, fmt.Errorf("The count [%d] of currency [%s] exceeds the daily headroom [%d]", count, currency, limit.DailyMax-volume.Count), CheckErr
+	}
+
+	return volume, nil, ErrType("")
+}
+
+// setLimit set the trading limit of an account or a kyc tier, requires the admin role
+// args: scope (account|tier), account or kyc level, currency id, per trade max, daily max
+func (c *ExchangeChaincode) setLimit() pb.Response {
+	myLogger.Debug("Set Limit...")
+
+	if len(c.args) != 5 {
+		return shim.Error("Incorrect number of arguments. Expecting 5")
+	}
+
+	scope := c.args[0]
+	subject := c.args[1]
+	if scope != LimitScopeAccount && scope != LimitScopeTier {
+		return shim.Error(fmt.Sprintf("Unknown limit scope [%s]", scope))
+	}
+	if scope == LimitScopeTier {
+		level, err := strconv.Atoi(subject)
+		if err != nil || level < 0 {
+			return shim.Error("The kyc level must be >= 0")
+		}
+	}
+	perTradeMax, err := strconv.ParseInt(c.args[3], 10, 64)
+	if err != nil || perTradeMax < 0 {
+		return shim.Error("The per trade limit must be >= 0")
+	}
+	dailyMax, err := strconv.ParseInt(c.args[4], 10, 64)
+	if err != nil || dailyMax < 0 {
+		return shim.Error("The daily limit must be >= 0")
+	}
+
+	_, err = c.checkRole(RoleAdmin)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	err = c.putLimit(&Limit{
+		Scope:       scope,
+		Subject:     subject,
+		Currency:    c.args[2],
+		PerTradeMax: perTradeMax,
+		DailyMax:    dailyMax,
+	})
+	if err != nil {
+		myLogger.Errorf("setLimit error1:%s", err)
+		return shim.Error(err.Error())
+	}
+
+	myLogger.Debug("Set Limit...done")
+	return shim.Success(nil)
+}
+
+// queryLimit query the limit and remaining daily headroom of an account, remaining -1 means unlimited
+// args: account, currency id
+func (c *ExchangeChaincode) queryLimit() pb.Response {
+	myLogger.Debug("queryLimit...")
+
+	if len(c.args) != 2 {
+		return shim.Error("Incorrect number of arguments. Expecting 2")
+	}
+
+	owner := c.args[0]
+	currency := c.args[1]
+
+	limit, err := c.getEffectiveLimit(owner, currency)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	volume, err := c.getVolume(owner, currency, c.txDay())
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	headroom := &struct {
+		Limit     *Limit `json:"limit"`
+		Day       string `json:"day"`
+		Used      int64  `json:"used"`
+		Remaining int64  `json:"remaining"`
+	}{
+		Limit:     limit,
+		Day:       volume.Day,
+		Used:      volume.Count,
+		Remaining: -1,
+	}
+	if limit != nil && limit.DailyMax > 0 {
+		headroom.Remaining = limit.DailyMax - volume.Count
+		if headroom.Remaining < 0 {
+			headroom.Remaining = 0
+		}
+	}
+
+	payload, err := json.Marshal(headroom)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	return shim.Success(payload)
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func TestLimits(t *testing.T) {
+	e := newTestEnv(t)
+	e.openAccount("alice")
+	e.openAccount("bob")
+	e.fund("alice", "EUR", 1000)
+	e.fund("bob", "BTC", 1000)
+
+	e.mustFail("alice", "setLimit", LimitScopeAccount, "alice", "EUR", "0", "0")
+	if msg := e.mustFail("root", "setLimit", "desk", "alice", "EUR", "0", "0"); !strings.Contains(msg, "Unknown limit scope") {
+		t.Fatalf("unknown scope: %s", msg)
+	}
+	e.mustInvoke("root", "setLimit", LimitScopeAccount, "alice", "EUR", "100", "150")
+	e.mustInvoke("root", "setLimit", LimitScopeTier, "1", "BTC", "20", "0")
+
+	headroom := func(owner, currency string) (used, remaining int64) {
+		h := struct {
+			Used      int64 `json:"used"`
+			Remaining int64 `json:"remaining"`
+		}{}
+		err := json.Unmarshal(e.mustInvoke(owner, "queryLimit", owner, currency), &h)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return h.Used, h.Remaining
+	}
+	if used, remaining := headroom("alice", "EUR"); used != 0 || remaining != 150 {
+		t.Fatalf("alice EUR headroom: used %d, remaining %d", used, remaining)
+	}
+	if _, remaining := headroom("bob", "BTC"); remaining != -1 {
+		t.Fatalf("bob BTC headroom: remaining %d, expecting unlimited", remaining)
+	}
+
+	// the per trade limit applies to the lock, the tier limit when the account has none
+	e.mustInvoke("root", "lock", `[{"owner":"alice","currency":"EUR","orderId":"b0","count":120}]`, "true", "test")
+	if batch := e.batch("chaincode_lock"); len(batch.Fail) != 1 || !strings.Contains(batch.Fail[0].Info, "per trade") {
+		t.Fatalf("lock over the per trade limit: %+v", batch)
+	}
+	e.mustInvoke("root", "lock", `[{"owner":"bob","currency":"BTC","orderId":"s0","count":30}]`, "true", "test")
+	if batch := e.batch("chaincode_lock"); len(batch.Fail) != 1 {
+		t.Fatalf("lock over the tier limit: %+v", batch)
+	}
+
+	// the second fill of the batch counts the volume of the first one
+	e.lockOrder("alice", "EUR", "b1", 100)
+	e.lockOrder("alice", "EUR", "b2", 100)
+	e.lockOrder("bob", "BTC", "s1", 10)
+	e.lockOrder("bob", "BTC", "s2", 10)
+	batch := e.exchange(append(match("alice", "bob", "EUR", "BTC", 100, 10, "1"), match("alice", "bob", "EUR", "BTC", 100, 10, "2")...))
+	if len(batch.Success) != 1 || len(batch.Fail) != 1 || !strings.Contains(batch.Fail[0].Info, "daily headroom") {
+		t.Fatalf("fills over the daily limit: %+v", batch)
+	}
+	if used, remaining := headroom("alice", "EUR"); used != 100 || remaining != 50 {
+		t.Fatalf("alice EUR headroom after the batch: used %d, remaining %d", used, remaining)
+	}
+	if used, _ := headroom("bob", "BTC"); used != 10 {
+		t.Fatalf("bob BTC used %d, expecting 10", used)
+	}
+}
@@ -1,6 +1,9 @@
 package main
 
 import (
+	"fmt"
+
+	"github.com/hyperledger/fabric/bccsp/factory"
 	"github.com/hyperledger/fabric/core/chaincode/shim"
 	pb "github.com/hyperledger/fabric/protos/peer"
 	"github.com/op/go-logging"
@@ -12,21 +15,43 @@ var myLogger = logging.MustGetLogger("exchange")
 type ExchangeChaincode struct {
 	stub shim.ChaincodeStubInterface
 	args []string
+
+	// set while an approved issuer proposal is executed
+	proposalApproved bool
+
+	// order of the statement entries written by the tx
+	statementSeq int
+
+	// transient key of a confidential exchange, the fills of its raw orders are sealed with it
+	sealKey []byte
 }
 
 // Init init
 func (c *ExchangeChaincode) Init(stub shim.ChaincodeStubInterface) pb.Response {
 	myLogger.Debug("Init Chaincode...")
 
+	// args: base currency ids, defaults to CNY and USD
 	args := stub.GetStringArgs()
-	if len(args) != 0 {
-		return shim.Error("Incorrect number of arguments. Expecting 0")
-	}
 
 	c.stub = stub
 	c.args = args
 
-	err := c.initCurrency()
+	bases := args
+	if len(bases) == 0 {
+		bases = defaultBaseCurrencies
+	}
+	err := c.initCurrency(bases)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	// the deployer is the first admin
+	admin, err := c.getCaller()
+	if err != nil {
+		myLogger.Errorf("Init error1:%s", err)
+		return shim.Error(fmt.Sprintf("Failed retrieving the deployer: [%s]", err))
+	}
+	err = c.putRole(RoleAdmin, admin)
 	if err != nil {
 		return shim.Error(err.Error())
 	}
@@ -40,22 +65,109 @@ func (c *ExchangeChaincode) Init(stub shim.ChaincodeStubInterface) pb.Response {
 func (c *ExchangeChaincode) Invoke(stub shim.ChaincodeStubInterface) pb.Response {
 	myLogger.Debug("Invoke Chaincode...")
 
-	function, args := stub.GetFunctionAndParameters()
+	function, args, err := decodeArgs(stub.GetArgs())
+	if err != nil {
+		myLogger.Errorf("Invoke error1:%s", err)
+		return shim.Error(fmt.Sprintf("Failed decoding args: [%s]", err))
+	}
 	c.stub = stub
 	c.args = args
+	c.statementSeq = 0
 
 	if function == "initAccount" {
 		return c.initAccount()
+	} else if function == "setBaseCurrency" {
+		return c.setBaseCurrency()
 	} else if function == "create" {
 		return c.create()
 	} else if function == "release" {
 		return c.release()
+	} else if function == "burn" {
+		return c.burn()
 	} else if function == "assign" {
 		return c.assign()
+	} else if function == "reverseAssign" {
+		return c.reverseAssign()
+	} else if function == "transfer" {
+		return c.transfer()
+	} else if function == "assignVested" {
+		return c.assignVested()
+	} else if function == "claimVested" {
+		return c.claimVested()
+	} else if function == "revokeVested" {
+		return c.revokeVested()
 	} else if function == "lock" {
 		return c.lock()
 	} else if function == "exchange" {
 		return c.exchange()
+	} else if function == "htlcLock" {
+		return c.htlcLock()
+	} else if function == "htlcClaim" {
+		return c.htlcClaim()
+	} else if function == "htlcRefund" {
+		return c.htlcRefund()
+	} else if function == "createEscrow" {
+		return c.createEscrow()
+	} else if function == "approveEscrow" {
+		return c.approveEscrow()
+	} else if function == "releaseEscrow" {
+		return c.releaseEscrow()
+	} else if function == "refundEscrow" {
+		return c.refundEscrow()
+	} else if function == "grantRole" {
+		return c.grantRole()
+	} else if function == "revokeRole" {
+		return c.revokeRole()
+	} else if function == "setAccountStatus" {
+		return c.setAccountStatus()
+	} else if function == "setAccountIdentity" {
+		return c.setAccountIdentity()
+	} else if function == "setLimit" {
+		return c.setLimit()
+	} else if function == "freezeAsset" {
+		return c.freezeAsset()
+	} else if function == "unfreezeAsset" {
+		return c.unfreezeAsset()
+	} else if function == "seize" {
+		return c.seize()
+	} else if function == "setCurrencyPause" {
+		return c.setCurrencyPause()
+	} else if function == "setCircuitBreaker" {
+		return c.setCircuitBreaker()
+	} else if function == "setIssuers" {
+		return c.setIssuers()
+	} else if function == "approveProposal" {
+		return c.approveProposal()
+	} else if function == "proposeIssuerChange" {
+		return c.proposeIssuerChange()
+	} else if function == "acceptIssuerChange" {
+		return c.acceptIssuerChange()
+	} else if function == "setOracle" {
+		return c.setOracle()
+	} else if function == "setAccountKey" {
+		return c.setAccountKey()
+	} else if function == "setOrderSignatureRequired" {
+		return c.setOrderSignatureRequired()
+	} else if function == "settleRing" {
+		return c.settleRing()
+	} else if function == "commitBalances" {
+		return c.commitBalances()
+	} else if function == "migrate" {
+		return c.migrate()
+	} else if function == "beginImport" {
+		return c.beginImport()
+	} else if function == "importState" {
+		return c.importState()
+	} else if function == "setHolderThreshold" {
+		return c.setHolderThreshold()
+	} else if function == "deposit" {
+		return c.deposit()
+	} else if function == "requestWithdrawal" {
+		return c.requestWithdrawal()
+	} else if function == "confirmWithdrawal" {
+		return c.confirmWithdrawal()
+	} else if function == "rejectWithdrawal" {
+		return c.rejectWithdrawal()
 	} else if function == "queryCurrencyByID" {
 		return c.queryCurrencyByID()
 	} else if function == "queryAllCurrency" {
@@ -70,6 +182,56 @@ func (c *ExchangeChaincode) Invoke(stub shim.ChaincodeStubInterface) pb.Response
 		return c.queryMyReleaseLog()
 	} else if function == "queryMyAssignLog" {
 		return c.queryMyAssignLog()
+	} else if function == "queryMyTransferLog" {
+		return c.queryMyTransferLog()
+	} else if function == "queryOracle" {
+		return c.queryOracle()
+	} else if function == "queryHTLC" {
+		return c.queryHTLC()
+	} else if function == "queryMyHTLC" {
+		return c.queryMyHTLC()
+	} else if function == "queryEscrow" {
+		return c.queryEscrow()
+	} else if function == "queryMyEscrow" {
+		return c.queryMyEscrow()
+	} else if function == "queryRole" {
+		return c.queryRole()
+	} else if function == "queryAccount" {
+		return c.queryAccount()
+	} else if function == "queryLimit" {
+		return c.queryLimit()
+	} else if function == "queryFreezeLog" {
+		return c.queryFreezeLog()
+	} else if function == "queryCircuitBreaker" {
+		return c.queryCircuitBreaker()
+	} else if function == "queryTicker" {
+		return c.queryTicker()
+	} else if function == "queryCandles" {
+		return c.queryCandles()
+	} else if function == "queryProposals" {
+		return c.queryProposals()
+	} else if function == "queryFiatLog" {
+		return c.queryFiatLog()
+	} else if function == "queryMyWithdrawal" {
+		return c.queryMyWithdrawal()
+	} else if function == "queryStatement" {
+		return c.queryStatement()
+	} else if function == "queryHolders" {
+		return c.queryHolders()
+	} else if function == "queryHolderCount" {
+		return c.queryHolderCount()
+	} else if function == "queryTopHolders" {
+		return c.queryTopHolders()
+	} else if function == "richQuery" {
+		return c.richQuery()
+	} else if function == "exportState" {
+		return c.exportState()
+	} else if function == "queryBalanceProof" {
+		return c.queryBalanceProof()
+	} else if function == "queryCommitments" {
+		return c.queryCommitments()
+	} else if function == "querySealedOrder" {
+		return c.querySealedOrder()
 	}
 
 	myLogger.Debug("Invoke Chaincode...done")
@@ -79,7 +241,12 @@ func (c *ExchangeChaincode) Invoke(stub shim.ChaincodeStubInterface) pb.Response
 
 func main() {
 	// primitives.SetSecurityLevel("SHA3", 256)
-	err := shim.Start(new(ExchangeChaincode))
+	err := factory.InitFactories(nil)
+	if err != nil {
+		myLogger.Errorf("Error initializing bccsp: %s", err)
+	}
+
+	err = shim.Start(new(ExchangeChaincode))
 	if err != nil {
 		myLogger.Errorf("Error starting exchange chaincode: %s", err)
 	}
@@ -0,0 +1,269 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"math/big"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/golang/protobuf/ptypes/timestamp"
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	"github.com/hyperledger/fabric/msp"
+	pb "github.com/hyperledger/fabric/protos/peer"
+)
+
+// testStub MockStub with the creator, args, transient map and timestamp of the tx, which MockStub leaves empty,
+// the events are kept in events
+type testStub struct {
+	*shim.MockStub
+	creator   []byte
+	args      [][]byte
+	transient map[string][]byte
+	events    map[string][]byte
+	now       int64
+}
+
+func (s *testStub) GetTxTimestamp() (*timestamp.Timestamp, error) {
+	if s.now == 0 {
+		return s.MockStub.GetTxTimestamp()
+	}
+	return &timestamp.Timestamp{Seconds: s.now}, nil
+}
+
+func (s *testStub) GetCreator() ([]byte, error)              { return s.creator, nil }
+func (s *testStub) GetArgs() [][]byte                        { return s.args }
+func (s *testStub) GetTransient() (map[string][]byte, error) { return s.transient, nil }
+
+func (s *testStub) GetStringArgs() []string {
+	var args []string
+	for _, v := range s.args {
+		args = append(args, string(v))
+	}
+	return args
+}
+
+func (s *testStub) GetFunctionAndParameters() (string, []string) {
+	args := s.GetStringArgs()
+	if len(args) == 0 {
+		return "", nil
+	}
+	return args[0], args[1:]
+}
+
+func (s *testStub) SetEvent(name string, payload []byte) error {
+	if s.events != nil {
+		s.events[name] = payload
+	}
+	return nil
+}
+
+// testEnv exchange chaincode on a MockStub, deployed by Org1MSP/root, the txs run at now unless it is 0
+type testEnv struct {
+	t       *testing.T
+	stub    *shim.MockStub
+	callers map[string][]byte
+	events  map[string][]byte
+	now     int64
+}
+
+func newTestEnv(t *testing.T) *testEnv {
+	e := &testEnv{
+		t:       t,
+		stub:    shim.NewMockStub("exchange", new(ExchangeChaincode)),
+		callers: make(map[string][]byte),
+		events:  make(map[string][]byte),
+	}
+
+	e.stub.MockTransactionStart("init")
+	r := new(ExchangeChaincode).Init(&testStub{MockStub: e.stub, creator: e.creator("root")})
+	e.stub.MockTransactionEnd("init")
+	if r.Status != shim.OK {
+		t.Fatalf("Init: %s", r.Message)
+	}
+	return e
+}
+
+// creator serialized identity Org1MSP/cn
+func (e *testEnv) creator(cn string) []byte {
+	if b, ok := e.callers[cn]; ok {
+		return b
+	}
+
+	k, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		e.t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: cn},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &k.PublicKey, k)
+	if err != nil {
+		e.t.Fatal(err)
+	}
+	b, err := proto.Marshal(&msp.SerializedIdentity{
+		Mspid:   "Org1MSP",
+		IdBytes: pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+	})
+	if err != nil {
+		e.t.Fatal(err)
+	}
+	e.callers[cn] = b
+	return b
+}
+
+// invokeTransient invoke as Org1MSP/cn with a transient map
+func (e *testEnv) invokeTransient(cn string, transient map[string][]byte, args ...string) pb.Response {
+	var input [][]byte
+	for _, v := range args {
+		input = append(input, []byte(v))
+	}
+
+	e.stub.MockTransactionStart(GenerateUUID())
+	r := new(ExchangeChaincode).Invoke(&testStub{MockStub: e.stub, creator: e.creator(cn), args: input, transient: transient, events: e.events, now: e.now})
+	e.stub.MockTransactionEnd("")
+	return r
+}
+
+// invoke invoke as Org1MSP/cn
+func (e *testEnv) invoke(cn string, args ...string) pb.Response {
+	return e.invokeTransient(cn, nil, args...)
+}
+
+// mustInvoke invoke as Org1MSP/cn and fail the test unless it succeeds
+func (e *testEnv) mustInvoke(cn string, args ...string) []byte {
+	r := e.invoke(cn, args...)
+	if r.Status != shim.OK {
+		e.t.Fatalf("%s %v: %d %s", cn, args, r.Status, r.Message)
+	}
+	return r.Payload
+}
+
+// mustFail invoke as Org1MSP/cn and fail the test if it succeeds
+func (e *testEnv) mustFail(cn string, args ...string) string {
+	r := e.invoke(cn, args...)
+	if r.Status == shim.OK {
+		e.t.Fatalf("%s %v: expecting an error", cn, args)
+	}
+	return r.Message
+}
+
+// batch the result of the last batch event
+func (e *testEnv) batch(name string) *BatchResult {
+	batch := new(BatchResult)
+	err := json.Unmarshal(e.events[name], batch)
+	if err != nil {
+		e.t.Fatalf("%s: %s", name, err)
+	}
+	return batch
+}
+
+// asset the asset of owner, empty if none
+func (e *testEnv) asset(owner, currency string) *Asset {
+	asset, err := (&ExchangeChaincode{stub: e.stub}).getOwnerOneAsset(owner, currency)
+	if err != nil {
+		e.t.Fatal(err)
+	}
+	if asset == nil {
+		return new(Asset)
+	}
+	return asset
+}
+
+// openAccount register an active account at kyc level 1, bound to Org1MSP/name
+func (e *testEnv) openAccount(name string) {
+	e.mustInvoke("root", "setAccountStatus", name, AccountActive, "1")
+	e.mustInvoke("root", "setAccountIdentity", name, "Org1MSP/"+name)
+}
+
+// fund create the currency on first use and assign count to owner
+func (e *testEnv) fund(owner, currency string, count int64) {
+	curr, err := (&ExchangeChaincode{stub: e.stub}).getCurrencyByName(currency)
+	if err != nil {
+		e.t.Fatal(err)
+	}
+	if curr == nil {
+		e.mustInvoke("root", "create", currency, "1000000", "")
+	}
+	b, err := json.Marshal(map[string]interface{}{
+		"currency": currency,
+		"assigns":  []map[string]interface{}{{"owner": owner, "count": count}},
+	})
+	if err != nil {
+		e.t.Fatal(err)
+	}
+	e.mustInvoke("root", "assign", string(b))
+}
+
+// lockOrder lock count of currency for the order
+func (e *testEnv) lockOrder(owner, currency, orderID string, count int64) {
+	b, err := json.Marshal([]map[string]interface{}{{"owner": owner, "currency": currency, "orderId": orderID, "count": count}})
+	if err != nil {
+		e.t.Fatal(err)
+	}
+	e.mustInvoke("root", "lock", string(b), "true", "test")
+	if batch := e.batch("chaincode_lock"); len(batch.Success) != 1 {
+		e.t.Fatalf("lock %s: %+v", orderID, batch.Fail)
+	}
+}
+
+// match a buy order of owner paying cost src for count des, matched with a sell order of seller
+func match(buyer, seller, src, des string, cost, count int64, suffix string) []map[string]Order {
+	return []map[string]Order{{
+		"buyOrder": {UUID: "b" + suffix, RawUUID: "b" + suffix, Account: buyer, SrcCurrency: src, SrcCount: cost,
+			DesCurrency: des, DesCount: count, FinalCost: cost},
+		"sellOrder": {UUID: "s" + suffix, RawUUID: "s" + suffix, Account: seller, SrcCurrency: des, SrcCount: count,
+			DesCurrency: src, DesCount: cost, FinalCost: count},
+	}}
+}
+
+// exchange exchange the matches and return the batch result
+func (e *testEnv) exchange(matches []map[string]Order) *BatchResult {
+	b, err := json.Marshal(matches)
+	if err != nil {
+		e.t.Fatal(err)
+	}
+	e.mustInvoke("root", "exchange", string(b))
+	return e.batch("chaincode_exchange")
+}
+
+func itoa(i int64) string { return strconv.FormatInt(i, 10) }
+
+func TestDecodeArgs(t *testing.T) {
+	enc := func(s string) []byte { return []byte(base64.StdEncoding.EncodeToString([]byte(s))) }
+	ci, err := proto.Marshal(&pb.ChaincodeInput{Args: [][]byte{[]byte("transfer"), []byte("a")}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, input := range [][][]byte{
+		{[]byte("transfer"), []byte("a")},
+		{[]byte(ArgEncodingRaw), []byte("transfer"), []byte("a")},
+		{[]byte(ArgEncodingBase64), enc("transfer"), enc("a")},
+		{[]byte(ArgEncodingProtobuf), ci},
+	} {
+		function, args, err := decodeArgs(input)
+		if err != nil {
+			t.Fatalf("%q: %s", input[0], err)
+		}
+		if function != "transfer" || len(args) != 1 || args[0] != "a" {
+			t.Fatalf("%q: got %s %v", input[0], function, args)
+		}
+	}
+
+	_, _, err = decodeArgs([][]byte{[]byte(ArgEncodingBase64), []byte("%%")})
+	if err == nil {
+		t.Fatal("expecting an error for bad base64")
+	}
+}
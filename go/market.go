@@ -0,0 +1,403 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	pb "github.com/hyperledger/fabric/protos/peer"
+)
+
+type candleInterval struct {
+	Name      string
+	Seconds   int64
+	Retention int64 // number of buckets kept
+}
+
+// candleIntervals bucket lengths of the candles
+var candleIntervals = []candleInterval{
+	{"1m", 60, 1440},
+	{"1h", 3600, 720},
+	{"1d", 86400, 365},
+}
+
+func getCandleInterval(name string) (candleInterval, bool) {
+	for _, v := range candleIntervals {
+		if v.Name == name {
+			return v, true
+		}
+	}
+	return candleInterval{}, false
+}
+
+// Candle OHLCV bucket of a pair, prices are quote per base
+type Candle struct {
+	DocType     string  `json:"docType,omitempty"`
+	Base        string  `json:"base"`
+	Quote       string  `json:"quote"`
+	Interval    string  `json:"interval"`
+	Start       int64   `json:"start"`
+	Open        float64 `json:"open"`
+	High        float64 `json:"high"`
+	Low         float64 `json:"low"`
+	Close       float64 `json:"close"`
+	Volume      int64   `json:"volume"`      // base count
+	QuoteVolume int64   `json:"quoteVolume"` // quote count
+	Trades      int64   `json:"trades"`
+}
+
+// Ticker last fill of a pair, the 24h figures are computed from the 1h candles
+type Ticker struct {
+	DocType     string  `json:"docType,omitempty"`
+	Base        string  `json:"base"`
+	Quote       string  `json:"quote"`
+	LastPrice   float64 `json:"lastPrice"`
+	LastTime    int64   `json:"lastTime"`
+	Open24h     float64 `json:"open24h"`
+	High24h     float64 `json:"high24h"`
+	Low24h      float64 `json:"low24h"`
+	Volume24h   int64   `json:"volume24h"`
+	QuoteVol24h int64   `json:"quoteVolume24h"`
+}
+
+func candleKeyStart(start int64) string {
+	return fmt.Sprintf("%020d", start)
+}
+
+func (c *ExchangeChaincode) putCandle(candle *Candle) error {
+	key, err := c.stub.CreateCompositeKey("Candle~base~quote~interval~start", []string{candle.Base, candle.Quote, candle.Interval, candleKeyStart(candle.Start)})
+	if err != nil {
+		return err
+	}
+
+	candle.DocType = DocCandle
+	r, err := json.Marshal(candle)
+	if err != nil {
+		return err
+	}
+	return c.stub.PutState(key, r)
+}
+
+func (c *ExchangeChaincode) getCandle(base, quote, interval string, start int64) (*Candle, error) {
+	key, err := c.stub.CreateCompositeKey("Candle~base~quote~interval~start", []string{base, quote, interval, candleKeyStart(start)})
+	if err != nil {
+		return nil, err
+	}
+
+	candleByte, err := c.stub.GetState(key)
+	if err != nil {
+		return nil, err
+	}
+	if candleByte == nil {
+		return nil, nil
+	}
+
+	candle := new(Candle)
+	err = json.Unmarshal(candleByte, candle)
+	if err != nil {
+		return nil, err
+	}
+	return candle, nil
+}
+
+// getCandles candles of the pair with from <= start <= to, deleting the ones before expire (start) on the way
+func (c *ExchangeChaincode) getCandles(base, quote, interval string, from, to, expire int64) ([]*Candle, error) {
+	resultsIterator, err := c.stub.GetStateByPartialCompositeKey("Candle~base~quote~interval~start", []string{base, quote, interval})
+	if err != nil {
+		return nil, err
+	}
+	defer resultsIterator.Close()
+
+	var candles []*Candle
+	var expired []string
+	for resultsIterator.HasNext() {
+		key, value, err := resultsIterator.Next()
+		if err != nil {
+			return nil, err
+		}
+
+		candle := new(Candle)
+		err = json.Unmarshal(value, candle)
+		if err != nil {
+			return nil, err
+		}
+		if candle.Start < expire {
+			expired = append(expired, key)
+			continue
+		}
+		if candle.Start > to {
+			break
+		}
+		if candle.Start >= from {
+			candles = append(candles, candle)
+		}
+	}
+
+	for _, key := range expired {
+		err = c.stub.DelState(key)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return candles, nil
+}
+
+func (c *ExchangeChaincode) putTicker(ticker *Ticker) error {
+	key, err := c.stub.CreateCompositeKey("Ticker~base~quote", []string{ticker.Base, ticker.Quote})
+	if err != nil {
+		return err
+	}
+
+	ticker.DocType = DocTicker
+	r, err := json.Marshal(ticker)
+	if err != nil {
+		return err
+	}
+	return c.stub.PutState(key, r)
+}
+
+func (c *ExchangeChaincode) getTicker(base, quote string) (*Ticker, error) {
+	key, err := c.stub.CreateCompositeKey("Ticker~base~quote", []string{base, quote})
+	if err != nil {
+		return nil, err
+	}
+
+	tickerByte, err := c.stub.GetState(key)
+	if err != nil {
+		return nil, err
+	}
+	if tickerByte == nil {
+		return nil, nil
+	}
+
+	ticker := new(Ticker)
+	err = json.Unmarshal(tickerByte, ticker)
+	if err != nil {
+		return nil, err
+	}
+	return ticker, nil
+}
+
+// pairFills fills of a pair in a batch, they share the tx time and so the candle buckets
+type pairFills struct {
+	Base        string
+	Quote       string
+	Open        float64
+	High        float64
+	Low         float64
+	Close       float64
+	Volume      int64
+	QuoteVolume int64
+	Trades      int64
+}
+
+// marketFills fills of a batch by pair. The writes of a transaction are not visible to its reads,
+// so the fills are accumulated in memory and written once by putMarketData.
+type marketFills struct {
+	pairs map[string]*pairFills
+	keys  []string // pairs in fill order, for a deterministic write set
+}
+
+func newMarketFills() *marketFills {
+	return &marketFills{pairs: make(map[string]*pairFills)}
+}
+
+// add add a fill to its pair
+func (m *marketFills) add(buyOrder *Order) {
+	if buyOrder.FinalCost <= 0 || buyOrder.DesCount <= 0 {
+		return
+	}
+
+	base, quote := pairKey(buyOrder.SrcCurrency, buyOrder.DesCurrency)
+	volume, quoteVolume := buyOrder.DesCount, buyOrder.FinalCost
+	if base != buyOrder.DesCurrency {
+		volume, quoteVolume = quoteVolume, volume
+	}
+	price := float64(quoteVolume) / float64(volume)
+
+	key := base + "/" + quote
+	fills, ok := m.pairs[key]
+	if !ok {
+		fills = &pairFills{Base: base, Quote: quote, Open: price, High: price, Low: price}
+		m.pairs[key] = fills
+		m.keys = append(m.keys, key)
+	}
+	if price > fills.High {
+		fills.High = price
+	}
+	if price < fills.Low {
+		fills.Low = price
+	}
+	fills.Close = price
+	fills.Volume += volume
+	fills.QuoteVolume += quoteVolume
+	fills.Trades++
+}
+
+// putMarketData write the ticker and the candles of the pairs filled by the batch
+func (c *ExchangeChaincode) putMarketData(m *marketFills) error {
+	now := c.txTime()
+	for _, key := range m.keys {
+		fills := m.pairs[key]
+
+		err := c.putTicker(&Ticker{
+			Base:      fills.Base,
+			Quote:     fills.Quote,
+			LastPrice: fills.Close,
+			LastTime:  now,
+		})
+		if err != nil {
+			return err
+		}
+
+		for _, v := range candleIntervals {
+			start := now - now%v.Seconds
+			candle, err := c.getCandle(fills.Base, fills.Quote, v.Name, start)
+			if err != nil {
+				return err
+			}
+
+			if candle == nil {
+				candle = &Candle{
+					Base:     fills.Base,
+					Quote:    fills.Quote,
+					Interval: v.Name,
+					Start:    start,
+					Open:     fills.Open,
+					High:     fills.High,
+					Low:      fills.Low,
+				}
+
+				// a new bucket, drop the ones out of the retention
+				_, err = c.getCandles(fills.Base, fills.Quote, v.Name, 0, -1, start-v.Seconds*(v.Retention-1))
+				if err != nil {
+					return err
+				}
+			}
+
+			if fills.High > candle.High {
+				candle.High = fills.High
+			}
+			if fills.Low < candle.Low {
+				candle.Low = fills.Low
+			}
+			candle.Close = fills.Close
+			candle.Volume += fills.Volume
+			candle.QuoteVolume += fills.QuoteVolume
+			candle.Trades += fills.Trades
+
+			err = c.putCandle(candle)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// parsePair parse "base/quote" or two currency ids
+func parsePair(args []string) (string, string, error) {
+	if len(args) == 1 {
+		args = strings.Split(args[0], "/")
+	}
+	if len(args) != 2 || args[0] == "" || args[1] == "" {
+		return "", "", fmt.Errorf("The pair must be base/quote")
+	}
+
+	base, quote := pairKey(args[0], args[1])
+	return base, quote, nil
+}
+
+// queryTicker
+// args: pair (base/quote)
+func (c *ExchangeChaincode) queryTicker() pb.Response {
+	myLogger.Debug("queryTicker...")
+
+	if len(c.args) != 1 {
+		return shim.Error("Incorrect number of arguments. Expecting 1")
+	}
+
+	base, quote, err := parsePair(c.args)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	ticker, err := c.getTicker(base, quote)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if ticker == nil {
+		return shim.Error(NoDataErr.Error())
+	}
+
+	hour, _ := getCandleInterval("1h")
+	now := c.txTime()
+	from := now - now%hour.Seconds - 23*hour.Seconds
+	candles, err := c.getCandles(base, quote, "1h", from, now, 0)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	for i, v := range candles {
+		if i == 0 {
+			ticker.Open24h = v.Open
+			ticker.Low24h = v.Low
+		}
+		if v.High > ticker.High24h {
+			ticker.High24h = v.High
+		}
+		if v.Low < ticker.Low24h {
+			ticker.Low24h = v.Low
+		}
+		ticker.Volume24h += v.Volume
+		ticker.QuoteVol24h += v.QuoteVolume
+	}
+
+	payload, err := json.Marshal(ticker)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	return shim.Success(payload)
+}
+
+// queryCandles
+// args: pair (base/quote), interval (1m|1h|1d), from, to
+func (c *ExchangeChaincode) queryCandles() pb.Response {
+	myLogger.Debug("queryCandles...")
+
+	if len(c.args) != 4 {
+		return shim.Error("Incorrect number of arguments. Expecting 4")
+	}
+
+	base, quote, err := parsePair(c.args[:1])
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	interval := c.args[1]
+	if _, ok := getCandleInterval(interval); !ok {
+		return shim.Error(fmt.Sprintf("Unknown interval [%s]", interval))
+	}
+	from, err := strconv.ParseInt(c.args[2], 10, 64)
+	if err != nil {
+		return shim.Error("The from time must be an unix timestamp")
+	}
+	to, err := strconv.ParseInt(c.args[3], 10, 64)
+	if err != nil || to < from {
+		return shim.Error("The to time must be an unix timestamp >= from")
+	}
+
+	candles, err := c.getCandles(base, quote, interval, from, to, 0)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if len(candles) == 0 {
+		return shim.Error(NoDataErr.Error())
+	}
+
+	payload, err := json.Marshal(candles)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	return shim.Success(payload)
+}
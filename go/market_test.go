@@ -0,0 +1,55 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestMarketDataBatch(t *testing.T) {
+	e := newTestEnv(t)
+	e.now = 1500000000
+	e.openAccount("alice")
+	e.openAccount("bob")
+	e.fund("alice", "EUR", 1000)
+	e.fund("bob", "BTC", 1000)
+
+	// two fills of the pair in one batch count in the same buckets
+	e.lockOrder("alice", "EUR", "b1", 100)
+	e.lockOrder("bob", "BTC", "s1", 50)
+	e.lockOrder("alice", "EUR", "b2", 120)
+	e.lockOrder("bob", "BTC", "s2", 40)
+	batch := e.exchange(append(match("alice", "bob", "EUR", "BTC", 100, 50, "1"), match("alice", "bob", "EUR", "BTC", 120, 40, "2")...))
+	if len(batch.Success) != 2 {
+		t.Fatalf("batch: %+v", batch)
+	}
+
+	// and a later batch adds to them
+	e.lockOrder("alice", "EUR", "b3", 50)
+	e.lockOrder("bob", "BTC", "s3", 50)
+	batch = e.exchange(match("alice", "bob", "EUR", "BTC", 50, 50, "3"))
+	if len(batch.Success) != 1 {
+		t.Fatalf("batch: %+v", batch)
+	}
+
+	var candles []*Candle
+	err := json.Unmarshal(e.mustInvoke("bob", "queryCandles", "BTC/EUR", "1m", itoa(e.now-60), itoa(e.now)), &candles)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(candles) != 1 {
+		t.Fatalf("candles: %d, expecting 1", len(candles))
+	}
+	c := candles[0]
+	if c.Open != 2 || c.High != 3 || c.Low != 1 || c.Close != 1 || c.Volume != 140 || c.QuoteVolume != 270 || c.Trades != 3 {
+		t.Fatalf("candle: %+v", c)
+	}
+
+	ticker := new(Ticker)
+	err = json.Unmarshal(e.mustInvoke("bob", "queryTicker", "BTC/EUR"), ticker)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ticker.LastPrice != 1 || ticker.Volume24h != 140 {
+		t.Fatalf("ticker: %+v", ticker)
+	}
+}
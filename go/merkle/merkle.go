@@ -0,0 +1,168 @@
+package merkle
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+)
+
+// leaf and node hashes use different prefixes so that a node can't be presented as a leaf
+const (
+	leafPrefix = 0x00
+	nodePrefix = 0x01
+)
+
+// Node node of a Merkle-sum tree, Sum is the total of the balances below it and is committed by Hash.
+// A holder checks its balance is counted once in the total of the root, the sums on its path being >= 0.
+type Node struct {
+	Hash []byte
+	Sum  int64
+}
+
+// Step sibling on the path from a leaf to the root, Left is true when the sibling is on the left
+type Step struct {
+	Hash string `json:"hash"`
+	Sum  int64  `json:"sum"`
+	Left bool   `json:"left"`
+}
+
+// Proof inclusion proof of the balance of an owner in a commitment of Total
+type Proof struct {
+	Commitment string `json:"commitment"`
+	Currency   string `json:"currency"`
+	Owner      string `json:"owner"`
+	Balance    int64  `json:"balance"`
+	Index      int    `json:"index"`
+	Path       []Step `json:"path"`
+	Root       string `json:"root"`
+	Total      int64  `json:"total"`
+}
+
+func putSum(b []byte, sum int64) []byte {
+	var s [8]byte
+	binary.BigEndian.PutUint64(s[:], uint64(sum))
+	return append(b, s[:]...)
+}
+
+// Leaf leaf of the balance of an owner
+func Leaf(owner string, balance int64) Node {
+	b := []byte{leafPrefix}
+	b = append(b, owner...)
+	b = append(b, leafPrefix)
+	b = putSum(b, balance)
+
+	h := sha256.Sum256(b)
+	return Node{Hash: h[:], Sum: balance}
+}
+
+// parent node of two nodes, the sums must be >= 0 and must not overflow
+func parent(left, right Node) (Node, error) {
+	if left.Sum < 0 || right.Sum < 0 {
+		return Node{}, errors.New("The balances must be >= 0")
+	}
+	sum := left.Sum + right.Sum
+	if sum < 0 {
+		return Node{}, errors.New("The total overflows")
+	}
+
+	b := []byte{nodePrefix}
+	b = append(b, left.Hash...)
+	b = putSum(b, left.Sum)
+	b = append(b, right.Hash...)
+	b = putSum(b, right.Sum)
+
+	h := sha256.Sum256(b)
+	return Node{Hash: h[:], Sum: sum}, nil
+}
+
+// nextLevel hash the pairs of a level, an odd last node is carried to the next level
+func nextLevel(level []Node) ([]Node, error) {
+	next := make([]Node, 0, (len(level)+1)/2)
+	for i := 0; i < len(level); i += 2 {
+		if i+1 == len(level) {
+			next = append(next, level[i])
+			continue
+		}
+		node, err := parent(level[i], level[i+1])
+		if err != nil {
+			return nil, err
+		}
+		next = append(next, node)
+	}
+	return next, nil
+}
+
+// Root root of the tree of the leaves, the root of no leaves is sha256 of nothing with a zero sum
+func Root(leaves []Node) (Node, error) {
+	if len(leaves) == 0 {
+		h := sha256.Sum256(nil)
+		return Node{Hash: h[:]}, nil
+	}
+	if len(leaves) == 1 && leaves[0].Sum < 0 {
+		return Node{}, errors.New("The balances must be >= 0")
+	}
+
+	var err error
+	level := leaves
+	for len(level) > 1 {
+		level, err = nextLevel(level)
+		if err != nil {
+			return Node{}, err
+		}
+	}
+	return level[0], nil
+}
+
+// Path siblings from the leaf at index up to the root
+func Path(leaves []Node, index int) ([]Step, error) {
+	if index < 0 || index >= len(leaves) {
+		return nil, errors.New("The leaf index is out of range")
+	}
+
+	var err error
+	path := []Step{}
+	level := leaves
+	for len(level) > 1 {
+		sibling := index ^ 1
+		if sibling < len(level) {
+			path = append(path, Step{Hash: hex.EncodeToString(level[sibling].Hash), Sum: level[sibling].Sum, Left: sibling < index})
+		}
+		level, err = nextLevel(level)
+		if err != nil {
+			return nil, err
+		}
+		index = index / 2
+	}
+	return path, nil
+}
+
+// Verify check the balance of the proof is included in its root and counted in its total
+func Verify(proof *Proof) (bool, error) {
+	root, err := hex.DecodeString(proof.Root)
+	if err != nil {
+		return false, err
+	}
+	if proof.Balance < 0 {
+		return false, nil
+	}
+
+	node := Leaf(proof.Owner, proof.Balance)
+	for _, v := range proof.Path {
+		hash, err := hex.DecodeString(v.Hash)
+		if err != nil {
+			return false, err
+		}
+		sibling := Node{Hash: hash, Sum: v.Sum}
+		if v.Left {
+			node, err = parent(sibling, node)
+		} else {
+			node, err = parent(node, sibling)
+		}
+		if err != nil {
+			return false, nil
+		}
+	}
+	return bytes.Equal(node.Hash, root) && node.Sum == proof.Total, nil
+}
@@ -0,0 +1,107 @@
+package merkle
+
+import (
+	"encoding/hex"
+	"strconv"
+	"testing"
+)
+
+func leaves(n int) []Node {
+	nodes := make([]Node, n)
+	for i := range nodes {
+		nodes[i] = Leaf("owner"+strconv.Itoa(i), int64(i+1)*10)
+	}
+	return nodes
+}
+
+func proof(t *testing.T, nodes []Node, index int) *Proof {
+	root, err := Root(nodes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	path, err := Path(nodes, index)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &Proof{
+		Owner:   "owner" + strconv.Itoa(index),
+		Balance: nodes[index].Sum,
+		Index:   index,
+		Path:    path,
+		Root:    hex.EncodeToString(root.Hash),
+		Total:   root.Sum,
+	}
+}
+
+func TestRootSum(t *testing.T) {
+	root, err := Root(nil)
+	if err != nil || root.Sum != 0 || len(root.Hash) != 32 {
+		t.Fatalf("empty root: %+v %v", root, err)
+	}
+
+	root, err = Root(leaves(5))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if root.Sum != 150 {
+		t.Fatalf("sum: got %d, expecting 150", root.Sum)
+	}
+}
+
+func TestVerify(t *testing.T) {
+	for _, n := range []int{1, 2, 3, 5, 8} {
+		nodes := leaves(n)
+		for i := 0; i < n; i++ {
+			ok, err := Verify(proof(t, nodes, i))
+			if err != nil || !ok {
+				t.Fatalf("leaf %d of %d: %v %v", i, n, ok, err)
+			}
+		}
+	}
+}
+
+func TestVerifyTampered(t *testing.T) {
+	nodes := leaves(5)
+
+	p := proof(t, nodes, 2)
+	p.Balance++
+	if ok, _ := Verify(p); ok {
+		t.Fatal("verified a tampered balance")
+	}
+
+	p = proof(t, nodes, 2)
+	p.Total--
+	if ok, _ := Verify(p); ok {
+		t.Fatal("verified a tampered total")
+	}
+
+	// a sibling sum moved to hide a balance changes the root
+	p = proof(t, nodes, 2)
+	p.Path[0].Sum -= 10
+	if ok, _ := Verify(p); ok {
+		t.Fatal("verified a tampered sibling sum")
+	}
+
+	p = proof(t, nodes, 2)
+	p.Path[0].Sum = -1
+	if ok, _ := Verify(p); ok {
+		t.Fatal("verified a negative sibling sum")
+	}
+}
+
+func TestNegativeBalance(t *testing.T) {
+	nodes := leaves(3)
+	nodes[1] = Leaf("owner1", -5)
+	if _, err := Root(nodes); err == nil {
+		t.Fatal("committed a negative balance")
+	}
+	if _, err := Root(nodes[1:2]); err == nil {
+		t.Fatal("committed a single negative balance")
+	}
+}
+
+func TestPathOutOfRange(t *testing.T) {
+	if _, err := Path(leaves(3), 3); err == nil {
+		t.Fatal("path of a missing leaf")
+	}
+}
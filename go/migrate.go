@@ -0,0 +1,314 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	pb "github.com/hyperledger/fabric/protos/peer"
+)
+
+// migration steps backfilling the state written before an upgrade
+const (
+	// MigrateAccounts register the owners of assets without an account as active at kyc level 0
+	MigrateAccounts = "accounts"
+	// MigrateHolders index the assets put before the holder index by currency
+	MigrateHolders = "holders"
+	// MigrateDocTypes set the docType of the records of the richQuery entities put before it, for the Mango selectors
+	MigrateDocTypes = "docTypes"
+)
+
+var migrateSteps = []string{MigrateAccounts, MigrateHolders, MigrateDocTypes}
+
+const maxMigratePageSize = 500
+
+// MigrateResult page of a migration step, Bookmark is empty on the last page
+type MigrateResult struct {
+	Step     string `json:"step"`
+	Scanned  int    `json:"scanned"`
+	Updated  int    `json:"updated"`
+	Bookmark string `json:"bookmark"`
+}
+
+// scanIndexAfter iterate the keys of an index after the bookmark key, from the first key when it is empty
+func (c *ExchangeChaincode) scanIndexAfter(indexName, bookmark string) (shim.StateQueryIteratorInterface, error) {
+	prefix, err := c.stub.CreateCompositeKey(indexName, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	start := prefix
+	if bookmark != "" {
+		if !strings.HasPrefix(bookmark, prefix) {
+			return nil, errors.New("Invalid bookmark")
+		}
+		// the smallest key above the bookmark
+		start = bookmark + "\x00"
+	}
+	return c.stub.GetStateByRange(start, prefix+string(utf8.MaxRune))
+}
+
+// migrate run a page of a migration step, requires the admin role. Run the step again with the
+// returned bookmark until it is empty.
+// args: step, bookmark (empty for the first page), page size
+func (c *ExchangeChaincode) migrate() pb.Response {
+	myLogger.Debug("Migrate...")
+
+	if len(c.args) != 3 {
+		return shim.Error("Incorrect number of arguments. Expecting 3")
+	}
+
+	step := c.args[0]
+	if !containsString(migrateSteps, step) {
+		return shim.Error(fmt.Sprintf("Unknown migration step [%s]", step))
+	}
+	bookmark := ""
+	if c.args[1] != "" {
+		b, err := base64.StdEncoding.DecodeString(c.args[1])
+		if err != nil {
+			return shim.Error("Invalid bookmark")
+		}
+		bookmark = string(b)
+	}
+	pageSize, err := strconv.Atoi(c.args[2])
+	if err != nil || pageSize <= 0 || pageSize > maxMigratePageSize {
+		return shim.Error(fmt.Sprintf("The page size must be between 1 and %d", maxMigratePageSize))
+	}
+
+	caller, err := c.checkRole(RoleAdmin)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	result := &MigrateResult{Step: step}
+	switch step {
+	case MigrateAccounts:
+		err = c.migrateAccounts(caller, bookmark, pageSize, result)
+	case MigrateHolders:
+		err = c.migrateHolders(bookmark, pageSize, result)
+	case MigrateDocTypes:
+		err = c.migrateDocTypes(bookmark, pageSize, result)
+	}
+	if err != nil {
+		myLogger.Errorf("migrate error1:%s", err)
+		return shim.Error(err.Error())
+	}
+	if result.Bookmark != "" {
+		result.Bookmark = base64.StdEncoding.EncodeToString([]byte(result.Bookmark))
+	}
+
+	payload, err := json.Marshal(result)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	myLogger.Debug("Migrate...done")
+	return shim.Success(payload)
+}
+
+// migrateAccounts register the asset owners of the page that have no account.
+// They traded before the registry, so they are kept active at the lowest kyc level.
+func (c *ExchangeChaincode) migrateAccounts(caller, bookmark string, pageSize int, result *MigrateResult) error {
+	resultsIterator, err := c.scanIndexAfter("Asset~owner~uuid", bookmark)
+	if err != nil {
+		return err
+	}
+	defer resultsIterator.Close()
+
+	for resultsIterator.HasNext() {
+		if result.Scanned == pageSize {
+			return nil
+		}
+
+		key, _, err := resultsIterator.Next()
+		if err != nil {
+			return err
+		}
+		result.Scanned++
+		result.Bookmark = key
+
+		_, parts, err := c.stub.SplitCompositeKey(key)
+		if err != nil {
+			return err
+		}
+		account, err := c.getAccount(parts[0])
+		if err != nil {
+			return err
+		}
+		if account != nil {
+			continue
+		}
+
+		err = c.putAccount(&Account{
+			Name:       parts[0],
+			Status:     AccountActive,
+			UpdateTime: c.txTime(),
+			UpdatedBy:  caller,
+		})
+		if err != nil {
+			return err
+		}
+		result.Updated++
+	}
+
+	// last page
+	result.Bookmark = ""
+	return nil
+}
+
+// migrateHolders add the missing Asset~currency~owner~uuid entries of the assets of the page
+func (c *ExchangeChaincode) migrateHolders(bookmark string, pageSize int, result *MigrateResult) error {
+	resultsIterator, err := c.scanIndexAfter("Asset~owner~uuid", bookmark)
+	if err != nil {
+		return err
+	}
+	defer resultsIterator.Close()
+
+	for resultsIterator.HasNext() {
+		if result.Scanned == pageSize {
+			return nil
+		}
+
+		key, _, err := resultsIterator.Next()
+		if err != nil {
+			return err
+		}
+		result.Scanned++
+		result.Bookmark = key
+
+		_, parts, err := c.stub.SplitCompositeKey(key)
+		if err != nil {
+			return err
+		}
+		asset, err := c.getAsset(parts[1])
+		if err != nil {
+			return err
+		}
+		if asset == nil {
+			continue
+		}
+
+		indexKey, err := c.stub.CreateCompositeKey("Asset~currency~owner~uuid", []string{asset.Currency, asset.Owner, asset.UUID})
+		if err != nil {
+			return err
+		}
+		v, err := c.stub.GetState(indexKey)
+		if err != nil {
+			return err
+		}
+		if v != nil {
+			continue
+		}
+
+		err = c.stub.PutState(indexKey, NilValue)
+		if err != nil {
+			return err
+		}
+		result.Updated++
+	}
+
+	// last page
+	result.Bookmark = ""
+	return nil
+}
+
+// migrateDocTypes set the docType of the records of the page, the indexes of the richQuery entities are scanned
+// in index order so the bookmark tells the entity to resume
+func (c *ExchangeChaincode) migrateDocTypes(bookmark string, pageSize int, result *MigrateResult) error {
+	var indexes []string
+	entities := make(map[string]richEntity)
+	for _, v := range richEntities {
+		indexes = append(indexes, v.Index)
+		entities[v.Index] = v
+	}
+	sort.Strings(indexes)
+
+	for _, index := range indexes {
+		prefix, err := c.stub.CreateCompositeKey(index, nil)
+		if err != nil {
+			return err
+		}
+		after := ""
+		if bookmark != "" {
+			if !strings.HasPrefix(bookmark, prefix) {
+				if bookmark > prefix {
+					// done before the bookmark
+					continue
+				}
+			} else {
+				after = bookmark
+			}
+			bookmark = ""
+		}
+
+		done, err := c.migrateIndexDocType(entities[index], after, pageSize, result)
+		if err != nil || !done {
+			return err
+		}
+	}
+
+	// last page
+	result.Bookmark = ""
+	return nil
+}
+
+// migrateIndexDocType set the docType of the records of the entity index after the bookmark key, done is false when the
+// page is full
+func (c *ExchangeChaincode) migrateIndexDocType(entity richEntity, bookmark string, pageSize int, result *MigrateResult) (bool, error) {
+	resultsIterator, err := c.scanIndexAfter(entity.Index, bookmark)
+	if err != nil {
+		return false, err
+	}
+	defer resultsIterator.Close()
+
+	for resultsIterator.HasNext() {
+		if result.Scanned == pageSize {
+			return false, nil
+		}
+
+		key, _, err := resultsIterator.Next()
+		if err != nil {
+			return false, err
+		}
+		result.Scanned++
+		result.Bookmark = key
+
+		_, parts, err := c.stub.SplitCompositeKey(key)
+		if err != nil {
+			return false, err
+		}
+		raw, err := c.stub.GetState(parts[entity.KeyIndex])
+		if err != nil {
+			return false, err
+		}
+		if raw == nil {
+			continue
+		}
+
+		var doc map[string]interface{}
+		err = decodeJSON(raw, &doc)
+		if err != nil {
+			return false, err
+		}
+		if _, ok := doc["docType"]; ok {
+			continue
+		}
+		doc["docType"] = entity.DocType
+		raw, err = json.Marshal(doc)
+		if err != nil {
+			return false, err
+		}
+		err = c.stub.PutState(parts[entity.KeyIndex], raw)
+		if err != nil {
+			return false, err
+		}
+		result.Updated++
+	}
+	return true, nil
+}
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	pb "github.com/hyperledger/fabric/protos/peer"
+)
+
+const oracleConfigKey = "OracleConfig"
+
+// OracleConfig price oracle used to validate the fills of exchange
+type OracleConfig struct {
+	DocType   string `json:"docType,omitempty"`
+	Chaincode string `json:"chaincode"`
+	Channel   string `json:"channel"`
+	Band      int64  `json:"band"` // max deviation from the reference rate, in basis points
+}
+
+func (c *ExchangeChaincode) putOracleConfig(cfg *OracleConfig) error {
+	cfg.DocType = DocOracleConfig
+	r, err := json.Marshal(cfg)
+	if err != nil {
+		return err
+	}
+
+	return c.stub.PutState(oracleConfigKey, r)
+}
+
+func (c *ExchangeChaincode) getOracleConfig() (*OracleConfig, error) {
+	cfgByte, err := c.stub.GetState(oracleConfigKey)
+	if err != nil {
+		return nil, err
+	}
+	if cfgByte == nil {
+		return nil, nil
+	}
+
+	cfg := new(OracleConfig)
+	err = json.Unmarshal(cfgByte, cfg)
+	if err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+// setOracle set or clear the oracle chaincode, an empty chaincode name disables the price check, admin only
+// args: oracle chaincode name, channel, band (basis points)
+func (c *ExchangeChaincode) setOracle() pb.Response {
+	myLogger.Debug("Set Oracle...")
+
+	if len(c.args) != 3 {
+		return shim.Error("Incorrect number of arguments. Expecting 3")
+	}
+
+	_, err := c.checkRole(RoleAdmin)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	band, err := strconv.ParseInt(c.args[2], 10, 64)
+	if err != nil || band < 0 {
+		return shim.Error("The oracle band must be >= 0")
+	}
+
+	err = c.putOracleConfig(&OracleConfig{
+		Chaincode: c.args[0],
+		Channel:   c.args[1],
+		Band:      band,
+	})
+	if err != nil {
+		myLogger.Errorf("setOracle error1:%s", err)
+		return shim.Error(err.Error())
+	}
+
+	myLogger.Debug("Set Oracle...done")
+
+	return shim.Success(nil)
+}
+
+// queryOracle
+func (c *ExchangeChaincode) queryOracle() pb.Response {
+	myLogger.Debug("queryOracle...")
+
+	if len(c.args) != 0 {
+		return shim.Error("Incorrect number of arguments. Expecting 0")
+	}
+
+	cfg, err := c.getOracleConfig()
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if cfg == nil || cfg.Chaincode == "" {
+		return shim.Error(NoDataErr.Error())
+	}
+
+	payload, err := json.Marshal(cfg)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	return shim.Success(payload)
+}
+
+// getOracleRate fetch the reference rate (quote per one base) from the oracle chaincode
+func (c *ExchangeChaincode) getOracleRate(cfg *OracleConfig, base, quote string) (float64, error) {
+	args := [][]byte{[]byte("queryRate"), []byte(base), []byte(quote)}
+	resp := c.stub.InvokeChaincode(cfg.Chaincode, args, cfg.Channel)
+	if resp.Status != shim.OK {
+		return 0, fmt.Errorf("Failed querying oracle rate of [%s/%s]: [%s]", base, quote, resp.Message)
+	}
+
+	rate, err := strconv.ParseFloat(string(resp.Payload), 64)
+	if err != nil || rate <= 0 {
+		return 0, fmt.Errorf("Invalid oracle rate of [%s/%s]: [%s]", base, quote, resp.Payload)
+	}
+	return rate, nil
+}
+
+// checkOraclePrice check the fill price of the order is inside the band around the oracle rate
+func (c *ExchangeChaincode) checkOraclePrice(cfg *OracleConfig, order *Order) error {
+	if order.DesCount <= 0 {
+		return errors.New("The order desCount must be > 0")
+	}
+
+	// the order pays srcCurrency for desCurrency
+	rate, err := c.getOracleRate(cfg, order.DesCurrency, order.SrcCurrency)
+	if err != nil {
+		return err
+	}
+
+	price := float64(order.FinalCost) / float64(order.DesCount)
+	deviation := math.Abs(price-rate) / rate * 10000
+	if deviation > float64(cfg.Band) {
+		return fmt.Errorf("The price [%g] deviates from the oracle rate [%g] of [%s/%s] beyond %d bp",
+			price, rate, order.DesCurrency, order.SrcCurrency, cfg.Band)
+	}
+
+	return nil
+}
@@ -0,0 +1,16 @@
+package main
+
+import (
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	"github.com/op/go-logging"
+	"github.com/wutongtree/externality-chaincode/go/oracle"
+)
+
+var myLogger = logging.MustGetLogger("oracle")
+
+func main() {
+	err := shim.Start(new(oracle.OracleChaincode))
+	if err != nil {
+		myLogger.Errorf("Error starting oracle chaincode: %s", err)
+	}
+}
@@ -0,0 +1,302 @@
+package oracle
+
+import (
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	"github.com/hyperledger/fabric/msp"
+	pb "github.com/hyperledger/fabric/protos/peer"
+	"github.com/op/go-logging"
+)
+
+var myLogger = logging.MustGetLogger("oracle")
+
+// key of the deployer, who manages the publishers
+const ownerKey = "Owner"
+
+// Rate reference rate of a currency pair, how many quote per one base
+type Rate struct {
+	Base       string `json:"base"`
+	Quote      string `json:"quote"`
+	Rate       string `json:"rate"`
+	UpdateTime int64  `json:"updateTime"`
+}
+
+// OracleChaincode reference price oracle used by the exchange chaincode
+type OracleChaincode struct {
+	stub shim.ChaincodeStubInterface
+	args []string
+}
+
+// Init init, the deployer is the owner and a publisher
+// args: other publisher identities (mspid/common name)
+func (c *OracleChaincode) Init(stub shim.ChaincodeStubInterface) pb.Response {
+	myLogger.Debug("Init Oracle...")
+
+	c.stub = stub
+	c.args = stub.GetStringArgs()
+
+	owner, err := c.getCaller()
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	err = stub.PutState(ownerKey, []byte(owner))
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	for _, publisher := range append([]string{owner}, c.args...) {
+		err = c.putPublisher(publisher, true)
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+	}
+
+	myLogger.Debug("Init Oracle...done")
+
+	return shim.Success(nil)
+}
+
+// Invoke invoke
+func (c *OracleChaincode) Invoke(stub shim.ChaincodeStubInterface) pb.Response {
+	myLogger.Debug("Invoke Oracle...")
+
+	function, args := stub.GetFunctionAndParameters()
+	c.stub = stub
+	c.args = args
+
+	if function == "setRate" {
+		return c.setRate()
+	} else if function == "setPublisher" {
+		return c.setPublisher()
+	} else if function == "queryRate" {
+		return c.queryRate()
+	}
+
+	myLogger.Debug("Invoke Oracle...done")
+
+	return shim.Error(fmt.Sprintf("Invalid invoke function name [%s]. Expecting \"setRate\" \"setPublisher\" \"queryRate\"", function))
+}
+
+// getCaller returns the identity of the tx creator as "mspid/common name"
+func (c *OracleChaincode) getCaller() (string, error) {
+	creator, err := c.stub.GetCreator()
+	if err != nil {
+		return "", fmt.Errorf("Failed retrieving creator: [%s]", err)
+	}
+	if len(creator) == 0 {
+		return "", errors.New("The creator of the tx is empty")
+	}
+
+	sid := new(msp.SerializedIdentity)
+	err = proto.Unmarshal(creator, sid)
+	if err != nil {
+		return "", fmt.Errorf("Failed unmarshalling creator: [%s]", err)
+	}
+
+	block, _ := pem.Decode(sid.IdBytes)
+	if block == nil {
+		return "", errors.New("The creator certificate is not PEM encoded")
+	}
+	cert, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		return "", fmt.Errorf("Failed parsing creator certificate: [%s]", err)
+	}
+
+	return sid.Mspid + "/" + cert.Subject.CommonName, nil
+}
+
+// txTime returns the tx timestamp in unix seconds, so that every endorser stores the same time
+func (c *OracleChaincode) txTime() int64 {
+	ts, err := c.stub.GetTxTimestamp()
+	if err != nil || ts == nil {
+		return time.Now().Unix()
+	}
+	return ts.Seconds
+}
+
+func (c *OracleChaincode) putPublisher(identity string, enabled bool) error {
+	key, err := c.stub.CreateCompositeKey("Publisher~identity", []string{identity})
+	if err != nil {
+		return err
+	}
+	if !enabled {
+		return c.stub.DelState(key)
+	}
+	return c.stub.PutState(key, []byte{0x00})
+}
+
+// checkPublisher check the tx creator may publish rates
+func (c *OracleChaincode) checkPublisher() error {
+	caller, err := c.getCaller()
+	if err != nil {
+		return err
+	}
+
+	key, err := c.stub.CreateCompositeKey("Publisher~identity", []string{caller})
+	if err != nil {
+		return err
+	}
+	b, err := c.stub.GetState(key)
+	if err != nil {
+		return err
+	}
+	if b == nil {
+		return fmt.Errorf("[%s] is not a rate publisher", caller)
+	}
+	return nil
+}
+
+// setPublisher grant or revoke a rate publisher, owner only
+// args: identity (mspid/common name), true|false
+func (c *OracleChaincode) setPublisher() pb.Response {
+	myLogger.Debug("Set Publisher...")
+
+	if len(c.args) != 2 {
+		return shim.Error("Incorrect number of arguments. Expecting 2")
+	}
+	enabled, err := strconv.ParseBool(c.args[1])
+	if err != nil {
+		return shim.Error("Expecting true or false")
+	}
+
+	caller, err := c.getCaller()
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	owner, err := c.stub.GetState(ownerKey)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if caller != string(owner) {
+		return shim.Error(fmt.Sprintf("[%s] is not the oracle owner", caller))
+	}
+
+	err = c.putPublisher(c.args[0], enabled)
+	if err != nil {
+		myLogger.Errorf("setPublisher error1:%s", err)
+		return shim.Error(err.Error())
+	}
+
+	myLogger.Debug("Set Publisher...done")
+
+	return shim.Success(nil)
+}
+
+// setRate set reference rate of a currency pair, publishers only
+// args: base currency, quote currency, rate
+func (c *OracleChaincode) setRate() pb.Response {
+	myLogger.Debug("Set Rate...")
+
+	if len(c.args) != 3 {
+		return shim.Error("Incorrect number of arguments. Expecting 3")
+	}
+
+	err := c.checkPublisher()
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	base := c.args[0]
+	quote := c.args[1]
+	rate, err := strconv.ParseFloat(c.args[2], 64)
+	if err != nil || rate <= 0 {
+		return shim.Error("The rate must be > 0")
+	}
+
+	err = c.putRate(&Rate{
+		Base:       base,
+		Quote:      quote,
+		Rate:       c.args[2],
+		UpdateTime: c.txTime(),
+	})
+	if err != nil {
+		myLogger.Errorf("setRate error1:%s", err)
+		return shim.Error(err.Error())
+	}
+
+	myLogger.Debug("Set Rate...done")
+
+	return shim.Success(nil)
+}
+
+// queryRate query reference rate of a currency pair, the inverse pair is used when the pair is not set
+// args: base currency, quote currency
+func (c *OracleChaincode) queryRate() pb.Response {
+	myLogger.Debug("Query Rate...")
+
+	if len(c.args) != 2 {
+		return shim.Error("Incorrect number of arguments. Expecting 2")
+	}
+
+	base := c.args[0]
+	quote := c.args[1]
+
+	rate, err := c.getRate(base, quote)
+	if err != nil {
+		myLogger.Errorf("queryRate error1:%s", err)
+		return shim.Error(err.Error())
+	}
+	if rate != nil {
+		return shim.Success([]byte(rate.Rate))
+	}
+
+	rate, err = c.getRate(quote, base)
+	if err != nil {
+		myLogger.Errorf("queryRate error2:%s", err)
+		return shim.Error(err.Error())
+	}
+	if rate == nil {
+		return shim.Error(fmt.Sprintf("No rate of pair [%s/%s]", base, quote))
+	}
+
+	inverse, err := strconv.ParseFloat(rate.Rate, 64)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	return shim.Success([]byte(strconv.FormatFloat(1/inverse, 'g', -1, 64)))
+}
+
+func (c *OracleChaincode) putRate(rate *Rate) error {
+	key, err := c.stub.CreateCompositeKey("Rate~base~quote", []string{rate.Base, rate.Quote})
+	if err != nil {
+		return err
+	}
+
+	r, err := json.Marshal(rate)
+	if err != nil {
+		return err
+	}
+
+	return c.stub.PutState(key, r)
+}
+
+func (c *OracleChaincode) getRate(base, quote string) (*Rate, error) {
+	key, err := c.stub.CreateCompositeKey("Rate~base~quote", []string{base, quote})
+	if err != nil {
+		return nil, err
+	}
+
+	rateByte, err := c.stub.GetState(key)
+	if err != nil {
+		return nil, err
+	}
+	if rateByte == nil {
+		return nil, nil
+	}
+
+	rate := new(Rate)
+	err = json.Unmarshal(rateByte, rate)
+	if err != nil {
+		return nil, err
+	}
+	return rate, nil
+}
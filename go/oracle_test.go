@@ -0,0 +1,89 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	pb "github.com/hyperledger/fabric/protos/peer"
+	"github.com/wutongtree/externality-chaincode/go/oracle"
+)
+
+// oracleInvoke invoke the oracle chaincode as Org1MSP/cn
+func oracleInvoke(e *testEnv, stub *shim.MockStub, cn string, args ...string) pb.Response {
+	var input [][]byte
+	for _, v := range args {
+		input = append(input, []byte(v))
+	}
+
+	stub.MockTransactionStart(GenerateUUID())
+	r := new(oracle.OracleChaincode).Invoke(&testStub{MockStub: stub, creator: e.creator(cn), args: input})
+	stub.MockTransactionEnd("")
+	return r
+}
+
+func TestOracleBand(t *testing.T) {
+	e := newTestEnv(t)
+
+	// the oracle is deployed by feed
+	oracleStub := shim.NewMockStub("oracle", new(oracle.OracleChaincode))
+	oracleStub.MockTransactionStart("init")
+	r := new(oracle.OracleChaincode).Init(&testStub{MockStub: oracleStub, creator: e.creator("feed")})
+	oracleStub.MockTransactionEnd("init")
+	if r.Status != shim.OK {
+		t.Fatalf("oracle Init: %s", r.Message)
+	}
+	e.stub.MockPeerChaincode("oracle", oracleStub)
+
+	if r := oracleInvoke(e, oracleStub, "alice", "setRate", "BTC", "EUR", "2"); r.Status == shim.OK {
+		t.Fatal("setRate by a non publisher must fail")
+	}
+	if r := oracleInvoke(e, oracleStub, "alice", "setPublisher", "Org1MSP/alice", "true"); r.Status == shim.OK {
+		t.Fatal("setPublisher by a non owner must fail")
+	}
+	if r := oracleInvoke(e, oracleStub, "feed", "setRate", "BTC", "EUR", "2"); r.Status != shim.OK {
+		t.Fatalf("setRate: %s", r.Message)
+	}
+
+	e.mustFail("alice", "setOracle", "oracle", "", "100")
+	e.mustInvoke("root", "setOracle", "oracle", "", "100")
+
+	e.openAccount("alice")
+	e.openAccount("bob")
+	e.fund("alice", "EUR", 1000)
+	e.fund("bob", "BTC", 1000)
+
+	// 2 EUR per BTC, inside the band
+	e.lockOrder("alice", "EUR", "b1", 100)
+	e.lockOrder("bob", "BTC", "s1", 50)
+	batch := e.exchange(match("alice", "bob", "EUR", "BTC", 100, 50, "1"))
+	if len(batch.Success) != 1 {
+		t.Fatalf("exchange inside the band: %+v", batch.Fail)
+	}
+	if got := e.asset("alice", "BTC").Count; got != 50 {
+		t.Fatalf("alice BTC: got %d, expecting 50", got)
+	}
+
+	// 2.4 EUR per BTC, 2000 bp off the oracle rate
+	e.lockOrder("alice", "EUR", "b2", 120)
+	e.lockOrder("bob", "BTC", "s2", 50)
+	batch = e.exchange(match("alice", "bob", "EUR", "BTC", 120, 50, "2"))
+	if len(batch.Success) != 0 || len(batch.Fail) != 1 || !strings.Contains(batch.Fail[0].Info, "oracle rate") {
+		t.Fatalf("exchange outside the band: %+v", batch)
+	}
+	if got := e.asset("alice", "BTC").Count; got != 50 {
+		t.Fatalf("alice BTC: got %d, expecting 50", got)
+	}
+
+	// the buy leg at the oracle rate, the sell leg paid 1.2 EUR per BTC
+	e.lockOrder("alice", "EUR", "b3", 100)
+	e.lockOrder("bob", "BTC", "s3", 50)
+	matches := match("alice", "bob", "EUR", "BTC", 100, 50, "3")
+	sell := matches[0]["sellOrder"]
+	sell.DesCount = 60
+	matches[0]["sellOrder"] = sell
+	batch = e.exchange(matches)
+	if len(batch.Success) != 0 || len(batch.Fail) != 1 || !strings.Contains(batch.Fail[0].Info, "oracle rate") {
+		t.Fatalf("exchange with the sell leg outside the band: %+v", batch)
+	}
+}
@@ -0,0 +1,346 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	pb "github.com/hyperledger/fabric/protos/peer"
+)
+
+const (
+	PauseIssuance = "issuance"
+	PauseTransfer = "transfer"
+	PauseTrading  = "trading"
+)
+
+// checkCurrencyOpen check the operation is not paused on the currency
+func (c *ExchangeChaincode) checkCurrencyOpen(name, operation string) (error, ErrType) {
+	curr, err := c.getCurrencyByName(name)
+	if err != nil {
+		return fmt.Errorf("Failed retrieving currency [%s]: [%s]", name, err), CheckErr
+	}
+	if curr == nil {
+		return nil, ErrType("")
+	}
+
+	err = curr.checkOpen(operation)
+	if err != nil {
+		return err, CheckErr
+	}
+	return nil, ErrType("")
+}
+
+// checkOpen check the operation is not paused on a loaded currency
+func (curr *Currency) checkOpen(operation string) error {
+	switch {
+	case operation == PauseIssuance && curr.IssuancePaused:
+		return fmt.Errorf("The issuance of currency [%s] is paused", curr.Name)
+	case operation == PauseTransfer && curr.TransfersPaused:
+		return fmt.Errorf("The transfer of currency [%s] is paused", curr.Name)
+	case operation == PauseTrading && curr.TradingHalted:
+		return fmt.Errorf("The trading of currency [%s] is halted", curr.Name)
+	}
+	return nil
+}
+
+// checkCreatorOrAdmin check the tx creator is the creator of the currency or an admin
+func (c *ExchangeChaincode) checkCreatorOrAdmin(curr *Currency) (string, error) {
+	caller, err := c.getCaller()
+	if err != nil {
+		return "", err
+	}
+	if caller == curr.Creator {
+		return caller, nil
+	}
+	return c.checkRole(RoleAdmin)
+}
+
+// setCurrencyPause pause or resume issuance, transfer or trading of a currency, requires the creator or an admin.
+// Transfer covers the htlc and escrow locks and payouts, their refunds stay open.
+// args: currency id, operation (issuance|transfer|trading), paused
+func (c *ExchangeChaincode) setCurrencyPause() pb.Response {
+	myLogger.Debug("Set Currency Pause...")
+
+	if len(c.args) != 3 {
+		return shim.Error("Incorrect number of arguments. Expecting 3")
+	}
+
+	name := c.args[0]
+	operation := c.args[1]
+	paused, err := strconv.ParseBool(c.args[2])
+	if err != nil {
+		return shim.Error("The paused flag must be a bool")
+	}
+
+	curr, err := c.getCurrencyByName(name)
+	if err != nil {
+		myLogger.Errorf("setCurrencyPause error1:%s", err)
+		return shim.Error(fmt.Sprintf("Failed retrieving currency [%s]: [%s]", name, err))
+	}
+	if curr == nil {
+		return shim.Error(fmt.Sprintf("The currency [%s] does not exist", name))
+	}
+
+	_, err = c.checkCreatorOrAdmin(curr)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	switch operation {
+	case PauseIssuance:
+		curr.IssuancePaused = paused
+	case PauseTransfer:
+		curr.TransfersPaused = paused
+	case PauseTrading:
+		curr.TradingHalted = paused
+	default:
+		return shim.Error(fmt.Sprintf("Unknown operation [%s]", operation))
+	}
+
+	err = c.putCurrency(curr)
+	if err != nil {
+		myLogger.Errorf("setCurrencyPause error2:%s", err)
+		return shim.Error(err.Error())
+	}
+
+	myLogger.Debug("Set Currency Pause...done")
+	return shim.Success(nil)
+}
+
+// CircuitBreaker halts a currency pair when the fill price moves more than MaxMove inside Window
+type CircuitBreaker struct {
+	DocType  string  `json:"docType,omitempty"`
+	Base     string  `json:"base"`
+	Quote    string  `json:"quote"`
+	MaxMove  int64   `json:"maxMove"` // basis points
+	Window   int64   `json:"window"`  // seconds
+	RefPrice float64 `json:"refPrice"`
+	RefTime  int64   `json:"refTime"`
+	Halted   bool    `json:"halted"`
+	HaltTime int64   `json:"haltTime"`
+}
+
+// pairKey order the currencies of a pair so that both directions share one record
+func pairKey(src, des string) (string, string) {
+	if src < des {
+		return src, des
+	}
+	return des, src
+}
+
+func (c *ExchangeChaincode) putCircuitBreaker(cb *CircuitBreaker) error {
+	key, err := c.stub.CreateCompositeKey("CircuitBreaker~base~quote", []string{cb.Base, cb.Quote})
+	if err != nil {
+		return err
+	}
+
+	cb.DocType = DocCircuitBreaker
+	r, err := json.Marshal(cb)
+	if err != nil {
+		return err
+	}
+	return c.stub.PutState(key, r)
+}
+
+func (c *ExchangeChaincode) getCircuitBreaker(src, des string) (*CircuitBreaker, error) {
+	base, quote := pairKey(src, des)
+	key, err := c.stub.CreateCompositeKey("CircuitBreaker~base~quote", []string{base, quote})
+	if err != nil {
+		return nil, err
+	}
+
+	cbByte, err := c.stub.GetState(key)
+	if err != nil {
+		return nil, err
+	}
+	if cbByte == nil {
+		return nil, nil
+	}
+
+	cb := new(CircuitBreaker)
+	err = json.Unmarshal(cbByte, cb)
+	if err != nil {
+		return nil, err
+	}
+	return cb, nil
+}
+
+// batchBreakers circuit breakers of the pairs in a batch. The writes of a transaction are not visible to its reads,
+// so the breakers are kept in memory and the changed ones written once by putCircuitBreakers.
+type batchBreakers struct {
+	breakers map[string]*CircuitBreaker
+	changed  map[string]bool
+	keys     []string // changed breakers in check order, for a deterministic write set
+}
+
+func newBatchBreakers() *batchBreakers {
+	return &batchBreakers{breakers: make(map[string]*CircuitBreaker), changed: make(map[string]bool)}
+}
+
+func (b *batchBreakers) change(cb *CircuitBreaker) {
+	key := cb.Base + "/" + cb.Quote
+	if !b.changed[key] {
+		b.changed[key] = true
+		b.keys = append(b.keys, key)
+	}
+}
+
+// getBatchBreaker the circuit breaker of the pair in the batch, read from the state on first use
+func (c *ExchangeChaincode) getBatchBreaker(breakers *batchBreakers, src, des string) (*CircuitBreaker, error) {
+	base, quote := pairKey(src, des)
+	key := base + "/" + quote
+	cb, ok := breakers.breakers[key]
+	if ok {
+		return cb, nil
+	}
+
+	cb, err := c.getCircuitBreaker(src, des)
+	if err != nil {
+		return nil, err
+	}
+	breakers.breakers[key] = cb
+	return cb, nil
+}
+
+// putCircuitBreakers write the breakers changed by the batch
+func (c *ExchangeChaincode) putCircuitBreakers(breakers *batchBreakers) error {
+	for _, key := range breakers.keys {
+		err := c.putCircuitBreaker(breakers.breakers[key])
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// breakerPrice price of base in quote of the fill of the buy order
+func breakerPrice(cb *CircuitBreaker, buyOrder *Order) float64 {
+	price := float64(buyOrder.FinalCost) / float64(buyOrder.DesCount)
+	if cb.Base != buyOrder.DesCurrency {
+		price = 1 / price
+	}
+	return price
+}
+
+// checkCircuitBreaker check the pair is not halted and the fill price of the buy order stays in the window,
+// the pair is halted when it moves too far and the tripped breaker is returned with the error
+func (c *ExchangeChaincode) checkCircuitBreaker(breakers *batchBreakers, buyOrder *Order) (*CircuitBreaker, error, ErrType) {
+	cb, err := c.getBatchBreaker(breakers, buyOrder.SrcCurrency, buyOrder.DesCurrency)
+	if err != nil {
+		return nil, err, WorldStateErr
+	}
+	if cb == nil || cb.MaxMove <= 0 {
+		return nil, nil, ErrType("")
+	}
+	if cb.Halted {
+		return nil, fmt.Errorf("The trading of pair [%s/%s] is halted", cb.Base, cb.Quote), CheckErr
+	}
+	if buyOrder.FinalCost <= 0 || buyOrder.DesCount <= 0 {
+		return nil, errors.New("The order count must be > 0"), CheckErr
+	}
+
+	// the fill opens a new window, see fillCircuitBreaker
+	price := breakerPrice(cb, buyOrder)
+	now := c.txTime()
+	if cb.RefTime == 0 || now-cb.RefTime > cb.Window {
+		return nil, nil, ErrType("")
+	}
+
+	move := math.Abs(price-cb.RefPrice) / cb.RefPrice * 10000
+	if move <= float64(cb.MaxMove) {
+		return nil, nil, ErrType("")
+	}
+
+	cb.Halted = true
+	cb.HaltTime = now
+	breakers.change(cb)
+
+	return cb, fmt.Errorf("The price [%g] of pair [%s/%s] moved beyond %d bp, trading halted", price, cb.Base, cb.Quote, cb.MaxMove), CheckErr
+}
+
+// fillCircuitBreaker take the price of a settled fill as the reference when the window of the pair is over
+func (c *ExchangeChaincode) fillCircuitBreaker(breakers *batchBreakers, buyOrder *Order) {
+	base, quote := pairKey(buyOrder.SrcCurrency, buyOrder.DesCurrency)
+	cb := breakers.breakers[base+"/"+quote]
+	if cb == nil || cb.MaxMove <= 0 {
+		return
+	}
+
+	now := c.txTime()
+	if cb.RefTime == 0 || now-cb.RefTime > cb.Window {
+		cb.RefPrice = breakerPrice(cb, buyOrder)
+		cb.RefTime = now
+		breakers.change(cb)
+	}
+}
+
+// setCircuitBreaker configure the circuit breaker of a pair and resume it, requires the admin role
+// args: currency id, currency id, max move (basis points, 0 disables), window (seconds)
+func (c *ExchangeChaincode) setCircuitBreaker() pb.Response {
+	myLogger.Debug("Set Circuit Breaker...")
+
+	if len(c.args) != 4 {
+		return shim.Error("Incorrect number of arguments. Expecting 4")
+	}
+
+	if c.args[0] == c.args[1] {
+		return shim.Error("The pair is invalid")
+	}
+	maxMove, err := strconv.ParseInt(c.args[2], 10, 64)
+	if err != nil || maxMove < 0 {
+		return shim.Error("The max move must be >= 0")
+	}
+	window, err := strconv.ParseInt(c.args[3], 10, 64)
+	if err != nil || window <= 0 {
+		return shim.Error("The window must be > 0")
+	}
+
+	_, err = c.checkRole(RoleAdmin)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	base, quote := pairKey(c.args[0], c.args[1])
+	err = c.putCircuitBreaker(&CircuitBreaker{
+		Base:    base,
+		Quote:   quote,
+		MaxMove: maxMove,
+		Window:  window,
+	})
+	if err != nil {
+		myLogger.Errorf("setCircuitBreaker error1:%s", err)
+		return shim.Error(err.Error())
+	}
+
+	myLogger.Debug("Set Circuit Breaker...done")
+	return shim.Success(nil)
+}
+
+// queryCircuitBreaker
+// args: currency id, currency id
+func (c *ExchangeChaincode) queryCircuitBreaker() pb.Response {
+	myLogger.Debug("queryCircuitBreaker...")
+
+	if len(c.args) != 2 {
+		return shim.Error("Incorrect number of arguments. Expecting 2")
+	}
+
+	cb, err := c.getCircuitBreaker(c.args[0], c.args[1])
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if cb == nil {
+		return shim.Error(NoDataErr.Error())
+	}
+
+	payload, err := json.Marshal(cb)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	return shim.Success(payload)
+}
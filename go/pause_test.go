@@ -0,0 +1,91 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestCircuitBreakerHalt(t *testing.T) {
+	e := newTestEnv(t)
+	e.now = 1500000000
+	e.openAccount("alice")
+	e.openAccount("bob")
+	e.fund("alice", "EUR", 1000)
+	e.fund("bob", "BTC", 1000)
+
+	// 10% inside an hour
+	e.mustInvoke("root", "setCircuitBreaker", "EUR", "BTC", "1000", "3600")
+
+	e.lockOrder("alice", "EUR", "b1", 100)
+	e.lockOrder("bob", "BTC", "s1", 50)
+	batch := e.exchange(match("alice", "bob", "EUR", "BTC", 100, 50, "1"))
+	if len(batch.Success) != 1 || len(batch.Halted) != 0 {
+		t.Fatalf("reference fill: %+v", batch)
+	}
+
+	// 50% up trips the breaker, the halt is reported in the batch
+	e.lockOrder("alice", "EUR", "b2", 150)
+	e.lockOrder("bob", "BTC", "s2", 50)
+	batch = e.exchange(match("alice", "bob", "EUR", "BTC", 150, 50, "2"))
+	if len(batch.Fail) != 1 || len(batch.Halted) != 1 || batch.Halted[0] != "BTC/EUR" {
+		t.Fatalf("tripping fill: %+v", batch)
+	}
+
+	// the halt is committed
+	e.lockOrder("alice", "EUR", "b3", 100)
+	e.lockOrder("bob", "BTC", "s3", 50)
+	batch = e.exchange(match("alice", "bob", "EUR", "BTC", 100, 50, "3"))
+	if len(batch.Fail) != 1 || !strings.Contains(batch.Fail[0].Info, "halted") {
+		t.Fatalf("fill of a halted pair: %+v", batch)
+	}
+}
+
+func TestCircuitBreakerReference(t *testing.T) {
+	e := newTestEnv(t)
+	e.now = 1500000000
+	e.openAccount("alice")
+	e.openAccount("bob")
+	e.fund("alice", "EUR", 1000)
+	e.fund("bob", "BTC", 1000)
+	e.mustInvoke("root", "setCircuitBreaker", "EUR", "BTC", "1000", "3600")
+
+	// a fill failing to settle does not set the reference price
+	e.lockOrder("alice", "EUR", "b1", 50)
+	e.lockOrder("bob", "BTC", "s1", 50)
+	batch := e.exchange(match("alice", "bob", "EUR", "BTC", 100, 50, "1"))
+	if len(batch.Fail) != 1 || len(batch.Halted) != 0 {
+		t.Fatalf("failed fill: %+v", batch)
+	}
+
+	// the first settled fill of the batch is the reference of the next one
+	e.lockOrder("alice", "EUR", "b2", 150)
+	e.lockOrder("alice", "EUR", "b3", 300)
+	e.lockOrder("bob", "BTC", "s2", 50)
+	e.lockOrder("bob", "BTC", "s3", 50)
+	batch = e.exchange(append(match("alice", "bob", "EUR", "BTC", 150, 50, "2"), match("alice", "bob", "EUR", "BTC", 300, 50, "3")...))
+	if len(batch.Success) != 1 || batch.Success[0] != "b2,s2" || len(batch.Halted) != 1 {
+		t.Fatalf("batch over the window: %+v", batch)
+	}
+
+	cb, err := (&ExchangeChaincode{stub: e.stub}).getCircuitBreaker("EUR", "BTC")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !cb.Halted || cb.RefPrice != 3 {
+		t.Fatalf("circuit breaker: %+v", cb)
+	}
+}
+
+func TestIssuancePause(t *testing.T) {
+	e := newTestEnv(t)
+	e.openAccount("alice")
+	e.fund("alice", "EUR", 10)
+
+	e.mustInvoke("root", "setCurrencyPause", "EUR", PauseIssuance, "true")
+	if msg := e.mustFail("root", "release", "EUR", "10"); !strings.Contains(msg, "paused") {
+		t.Fatalf("release: %s", msg)
+	}
+	if msg := e.mustFail("root", "assign", `{"currency":"EUR","assigns":[{"owner":"alice","count":1}]}`); !strings.Contains(msg, "paused") {
+		t.Fatalf("assign: %s", msg)
+	}
+}
@@ -167,9 +167,62 @@ func (c *ExchangeChaincode) queryMyAssignLog() pb.Response {
 		return shim.Error(err.Error())
 	}
 
+	vestings, err := c.getOwnerVesting(owner)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	type vestingState struct {
+		*Vesting
+		Vested    int64 `json:"vested"`
+		Claimable int64 `json:"claimable"`
+	}
+	now := c.txTime()
+	states := []vestingState{}
+	for _, v := range vestings {
+		vested := v.vestedAt(now)
+		states = append(states, vestingState{Vesting: v, Vested: vested, Claimable: vested - v.Claimed})
+	}
+
+	logs := &struct {
+		ToMe    []*AssignLog   `json:"toMe"`
+		MeTo    []*AssignLog   `json:"meTo"`
+		Vesting []vestingState `json:"vesting"`
+	}{
+		ToMe:    logToMe,
+		MeTo:    logMeTo,
+		Vesting: states,
+	}
+
+	payload, err := json.Marshal(logs)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	return shim.Success(payload)
+}
+
+// queryMyTransferLog
+func (c *ExchangeChaincode) queryMyTransferLog() pb.Response {
+	myLogger.Debug("queryMyTransferLog...")
+
+	if len(c.args) != 1 {
+		return shim.Error("Incorrect number of arguments. Expecting 1")
+	}
+	owner := c.args[0]
+	logToMe, err := c.getToTransferLog(owner)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	logMeTo, err := c.getFromTransferLog(owner)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
 	logs := &struct {
-		ToMe []*AssignLog `json:"toMe"`
-		MeTo []*AssignLog `json:"meTo"`
+		ToMe []*TransferLog `json:"toMe"`
+		MeTo []*TransferLog `json:"meTo"`
 	}{
 		ToMe: logToMe,
 		MeTo: logMeTo,
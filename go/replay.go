@@ -0,0 +1,147 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+
+	pb "github.com/hyperledger/fabric/protos/peer"
+)
+
+// AlreadyProcessed status of a batch replayed with an id already processed, nothing is changed.
+// Like shim.OK it is below shim.ERROR so the tx is endorsed as a success.
+const AlreadyProcessed = 208
+
+// kinds of processed ids
+const (
+	ProcessedBatch  = "batch"
+	ProcessedMatch  = "match"
+	ProcessedOrder  = "order"
+	ProcessedLock   = "lock"
+	ProcessedUnlock = "unlock"
+)
+
+// ProcessedID registry of the order uuids, match ids and batch ids already processed, the result is kept for batches
+type ProcessedID struct {
+	DocType       string `json:"docType,omitempty"`
+	Kind          string `json:"kind"`
+	ID            string `json:"id"`
+	TxID          string `json:"txID"`
+	ProcessedTime int64  `json:"processedTime"`
+	Result        string `json:"result,omitempty"`
+}
+
+func (c *ExchangeChaincode) putProcessed(kind, id, result string) error {
+	key, err := c.stub.CreateCompositeKey("Processed~kind~id", []string{kind, id})
+	if err != nil {
+		return err
+	}
+
+	r, err := json.Marshal(&ProcessedID{
+		DocType:       DocProcessed,
+		Kind:          kind,
+		ID:            id,
+		TxID:          c.stub.GetTxID(),
+		ProcessedTime: c.txTime(),
+		Result:        result,
+	})
+	if err != nil {
+		return err
+	}
+	return c.stub.PutState(key, r)
+}
+
+func (c *ExchangeChaincode) getProcessed(kind, id string) (*ProcessedID, error) {
+	key, err := c.stub.CreateCompositeKey("Processed~kind~id", []string{kind, id})
+	if err != nil {
+		return nil, err
+	}
+	processedByte, err := c.stub.GetState(key)
+	if err != nil {
+		return nil, err
+	}
+	if processedByte == nil {
+		return nil, nil
+	}
+
+	processed := new(ProcessedID)
+	err = json.Unmarshal(processedByte, processed)
+	if err != nil {
+		return nil, err
+	}
+	return processed, nil
+}
+
+// checkBatch returns the AlreadyProcessed response when the batch id is processed, nil otherwise.
+// An empty batch id is not tracked.
+func (c *ExchangeChaincode) checkBatch(batchID string) (*pb.Response, error) {
+	if batchID == "" {
+		return nil, nil
+	}
+	processed, err := c.getProcessed(ProcessedBatch, batchID)
+	if err != nil {
+		return nil, err
+	}
+	if processed == nil {
+		return nil, nil
+	}
+
+	return &pb.Response{
+		Status:  AlreadyProcessed,
+		Message: fmt.Sprintf("The batch [%s] is already processed in tx [%s]", batchID, processed.TxID),
+		Payload: []byte(processed.Result),
+	}, nil
+}
+
+// checkNonce check the nonce of a new order is above the last nonce of the account. A zero nonce is not tracked,
+// it is only allowed until the account uses nonces.
+func (c *ExchangeChaincode) checkNonce(owner string, nonce int64) (*Account, error, ErrType) {
+	account, err := c.getAccount(owner)
+	if err != nil {
+		return nil, fmt.Errorf("Failed retrieving account [%s]: [%s]", owner, err), WorldStateErr
+	}
+	if nonce == 0 {
+		if account != nil && account.Nonce != 0 {
+			return nil, fmt.Errorf("The nonce of account [%s] is required", owner), CheckErr
+		}
+		return nil, nil, ErrType("")
+	}
+	if account == nil {
+		return nil, fmt.Errorf("The account [%s] is not registered", owner), CheckErr
+	}
+	if nonce <= account.Nonce {
+		return nil, fmt.Errorf("The nonce [%d] of account [%s] must be above [%d]", nonce, owner, account.Nonce), CheckErr
+	}
+	return account, nil, ErrType("")
+}
+
+// isOrderExchanged check the order uuid is processed or has a txlog, txlogs predate the registry
+func (c *ExchangeChaincode) isOrderExchanged(uuid string) (bool, error) {
+	processed, err := c.getProcessed(ProcessedOrder, uuid)
+	if err != nil {
+		return false, err
+	}
+	if processed != nil {
+		return true, nil
+	}
+
+	tx, err := c.getTxLog(uuid)
+	if err != nil {
+		return false, err
+	}
+	return tx != nil && tx.UUID != "", nil
+}
+
+// recordMatch record the match id and the uuids of its orders as processed
+func (c *ExchangeChaincode) recordMatch(matchID string, orders ...string) error {
+	err := c.putProcessed(ProcessedMatch, matchID, "")
+	if err != nil {
+		return err
+	}
+	for _, uuid := range orders {
+		err = c.putProcessed(ProcessedOrder, uuid, "")
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
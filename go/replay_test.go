@@ -0,0 +1,80 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestExchangeReplay(t *testing.T) {
+	e := newTestEnv(t)
+	e.openAccount("alice")
+	e.openAccount("bob")
+	e.fund("alice", "EUR", 1000)
+	e.fund("bob", "BTC", 1000)
+	e.lockOrder("alice", "EUR", "b1", 100)
+	e.lockOrder("bob", "BTC", "s1", 50)
+
+	b, err := json.Marshal(match("alice", "bob", "EUR", "BTC", 100, 50, "1"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	e.mustInvoke("root", "exchange", string(b), "batch-1")
+	first := e.batch("chaincode_exchange")
+	if len(first.Success) != 1 {
+		t.Fatalf("batch: %+v", first)
+	}
+
+	// the same batch id returns the first result and changes nothing
+	r := e.invoke("root", "exchange", string(b), "batch-1")
+	replayed := new(BatchResult)
+	if r.Status != AlreadyProcessed || json.Unmarshal(r.Payload, replayed) != nil || len(replayed.Success) != 1 {
+		t.Fatalf("replayed batch: %d %s %s", r.Status, r.Message, r.Payload)
+	}
+
+	// the match in another batch is a duplicate
+	e.mustInvoke("root", "exchange", string(b), "batch-2")
+	if batch := e.batch("chaincode_exchange"); len(batch.Success) != 0 || len(batch.Duplicate) != 1 {
+		t.Fatalf("replayed match: %+v", batch)
+	}
+
+	if a := e.asset("alice", "BTC"); a.Count != 50 {
+		t.Fatalf("alice BTC: %+v", a)
+	}
+	if a := e.asset("bob", "EUR"); a.Count != 100 {
+		t.Fatalf("bob EUR: %+v", a)
+	}
+}
+
+func TestLockReplay(t *testing.T) {
+	e := newTestEnv(t)
+	e.openAccount("alice")
+	e.fund("alice", "EUR", 1000)
+
+	lock := func(batchID string, locks ...map[string]interface{}) *BatchResult {
+		b, err := json.Marshal(locks)
+		if err != nil {
+			t.Fatal(err)
+		}
+		e.mustInvoke("root", "lock", string(b), "true", "test", batchID)
+		return e.batch("chaincode_lock")
+	}
+	order := func(id string, nonce int64) map[string]interface{} {
+		return map[string]interface{}{"owner": "alice", "currency": "EUR", "orderId": id, "count": 10, "nonce": nonce}
+	}
+
+	// nonces rise within a batch
+	batch := lock("lock-1", order("o1", 1), order("o2", 1), order("o3", 2))
+	if len(batch.Success) != 2 || len(batch.Fail) != 1 || batch.Fail[0].Id != "o2" {
+		t.Fatalf("first batch: %+v", batch)
+	}
+
+	// a locked order is a duplicate, a used nonce fails
+	batch = lock("lock-2", order("o1", 3), order("o4", 2), order("o5", 3))
+	if len(batch.Duplicate) != 1 || len(batch.Fail) != 1 || batch.Fail[0].Id != "o4" || len(batch.Success) != 1 {
+		t.Fatalf("second batch: %+v", batch)
+	}
+
+	if a := e.asset("alice", "EUR"); a.Count != 970 || a.LockCount != 30 {
+		t.Fatalf("alice EUR: %+v", a)
+	}
+}
@@ -0,0 +1,308 @@
+package main
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"strconv"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	pb "github.com/hyperledger/fabric/protos/peer"
+	"github.com/wutongtree/externality-chaincode/go/merkle"
+)
+
+// Commitment Merkle-sum root over the balances (count + lockCount + freezeCount + withdrawCount) of all holders of a
+// currency, the root commits the Total. The balances are kept under Commitment~uuid~owner to build the inclusion proofs.
+type Commitment struct {
+	DocType    string `json:"docType,omitempty"`
+	UUID       string `json:"uuid"`
+	Currency   string `json:"currency"`
+	Root       string `json:"root"`
+	Leaves     int    `json:"leaves"`
+	Total      int64  `json:"total"`
+	Committer  string `json:"committer"`
+	CommitTime int64  `json:"commitTime"`
+}
+
+func (c *ExchangeChaincode) putCommitment(commitment *Commitment) error {
+	if commitment.UUID == "" {
+		commitment.UUID = GenerateUUID()
+	}
+	commitment.DocType = DocCommitment
+	r, err := json.Marshal(commitment)
+	if err != nil {
+		return err
+	}
+
+	err = c.stub.PutState(commitment.UUID, r)
+	if err != nil {
+		return err
+	}
+
+	return c.putCompositeValue("Commitment~currency~uuid", []string{commitment.Currency, commitment.UUID})
+}
+
+func (c *ExchangeChaincode) getCommitment(key string) (*Commitment, error) {
+	commitmentByte, err := c.stub.GetState(key)
+	if err != nil {
+		return nil, err
+	}
+	if commitmentByte == nil {
+		return nil, nil
+	}
+
+	commitment := new(Commitment)
+	err = json.Unmarshal(commitmentByte, commitment)
+	if err != nil {
+		return nil, err
+	}
+	return commitment, nil
+}
+
+// commitmentLeaf committed balance of an owner
+type commitmentLeaf struct {
+	Owner   string
+	Balance int64
+}
+
+// getCommitmentLeaves committed balances in owner order, the order of the leaves
+func (c *ExchangeChaincode) getCommitmentLeaves(id string) ([]commitmentLeaf, error) {
+	resultsIterator, err := c.stub.GetStateByPartialCompositeKey("Commitment~uuid~owner", []string{id})
+	if err != nil {
+		return nil, err
+	}
+	defer resultsIterator.Close()
+
+	var leaves []commitmentLeaf
+	for resultsIterator.HasNext() {
+		compositeKey, value, err := resultsIterator.Next()
+		if err != nil {
+			return nil, err
+		}
+		_, compositeKeyParts, err := c.stub.SplitCompositeKey(compositeKey)
+		if err != nil {
+			return nil, err
+		}
+
+		balance, err := strconv.ParseInt(string(value), 10, 64)
+		if err != nil {
+			return nil, err
+		}
+		leaves = append(leaves, commitmentLeaf{Owner: compositeKeyParts[1], Balance: balance})
+	}
+	return leaves, nil
+}
+
+func leafNodes(leaves []commitmentLeaf) []merkle.Node {
+	nodes := make([]merkle.Node, len(leaves))
+	for i, v := range leaves {
+		nodes[i] = merkle.Leaf(v.Owner, v.Balance)
+	}
+	return nodes
+}
+
+// getHolderBalances balances of all assets of the currency in owner order. The assets are scanned by owner
+// as the ones put before Asset~currency~owner~uuid are not in the holder index until migrated.
+func (c *ExchangeChaincode) getHolderBalances(currency string) ([]commitmentLeaf, error) {
+	resultsIterator, err := c.stub.GetStateByPartialCompositeKey("Asset~owner~currency~uuid", nil)
+	if err != nil {
+		return nil, err
+	}
+	defer resultsIterator.Close()
+
+	var leaves []commitmentLeaf
+	for resultsIterator.HasNext() {
+		compositeKey, _, err := resultsIterator.Next()
+		if err != nil {
+			return nil, err
+		}
+		_, compositeKeyParts, err := c.stub.SplitCompositeKey(compositeKey)
+		if err != nil {
+			return nil, err
+		}
+		if compositeKeyParts[1] != currency {
+			continue
+		}
+
+		asset, err := c.getAsset(compositeKeyParts[2])
+		if err != nil {
+			return nil, err
+		}
+		if asset == nil {
+			continue
+		}
+
+		balance := asset.Count + asset.LockCount + asset.FreezeCount + asset.WithdrawCount
+		if balance == 0 {
+			continue
+		}
+		leaves = append(leaves, commitmentLeaf{Owner: asset.Owner, Balance: balance})
+	}
+	return leaves, nil
+}
+
+// commitBalances commit the balances of all holders of a currency, requires the creator or an admin
+// args: currency id
+func (c *ExchangeChaincode) commitBalances() pb.Response {
+	myLogger.Debug("Commit Balances...")
+
+	if len(c.args) != 1 {
+		return shim.Error("Incorrect number of arguments. Expecting 1")
+	}
+
+	curr, err := c.getHolderCurrency(c.args[0])
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	committer, err := c.checkCreatorOrAdmin(curr)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	commitment := &Commitment{
+		UUID:       GenerateUUID(),
+		Currency:   curr.Name,
+		Committer:  committer,
+		CommitTime: c.txTime(),
+	}
+
+	// every holder is committed, the holder threshold only applies to the listings
+	leaves, err := c.getHolderBalances(curr.Name)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	root, err := merkle.Root(leafNodes(leaves))
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	for _, v := range leaves {
+		key, err := c.stub.CreateCompositeKey("Commitment~uuid~owner", []string{commitment.UUID, v.Owner})
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+		err = c.stub.PutState(key, []byte(strconv.FormatInt(v.Balance, 10)))
+		if err != nil {
+			myLogger.Errorf("commitBalances error1:%s", err)
+			return shim.Error(err.Error())
+		}
+	}
+	commitment.Leaves = len(leaves)
+	commitment.Root = hex.EncodeToString(root.Hash)
+	commitment.Total = root.Sum
+
+	err = c.putCommitment(commitment)
+	if err != nil {
+		myLogger.Errorf("commitBalances error2:%s", err)
+		return shim.Error(err.Error())
+	}
+
+	payload, err := json.Marshal(commitment)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	err = c.stub.SetEvent("chaincode_commitBalances", payload)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	myLogger.Debug("Commit Balances...done")
+	return shim.Success(payload)
+}
+
+// queryBalanceProof inclusion proof of the committed balance of an owner, checked offline by merkle.Verify.
+// The proof reveals the balances of the siblings, only the account bound to the caller and auditors get it.
+// args: owner, currency id, commitment id
+func (c *ExchangeChaincode) queryBalanceProof() pb.Response {
+	myLogger.Debug("queryBalanceProof...")
+
+	if len(c.args) != 3 {
+		return shim.Error("Incorrect number of arguments. Expecting 3")
+	}
+	owner := c.args[0]
+
+	account, _ := c.getCallerAccount()
+	if account == "" || account != owner {
+		_, err := c.checkRole(RoleAuditor)
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+	}
+
+	commitment, err := c.getCommitment(c.args[2])
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if commitment == nil || commitment.Currency != c.args[1] {
+		return shim.Error(fmt.Sprintf("The commitment [%s] of currency [%s] does not exist", c.args[2], c.args[1]))
+	}
+
+	leaves, err := c.getCommitmentLeaves(commitment.UUID)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	index := -1
+	for i, v := range leaves {
+		if v.Owner == owner {
+			index = i
+			break
+		}
+	}
+	if index < 0 {
+		return shim.Error(fmt.Sprintf("The owner [%s] is not in the commitment", owner))
+	}
+
+	path, err := merkle.Path(leafNodes(leaves), index)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	payload, err := json.Marshal(&merkle.Proof{
+		Commitment: commitment.UUID,
+		Currency:   commitment.Currency,
+		Owner:      owner,
+		Balance:    leaves[index].Balance,
+		Index:      index,
+		Path:       path,
+		Root:       commitment.Root,
+		Total:      commitment.Total,
+	})
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	return shim.Success(payload)
+}
+
+// queryCommitments
+// args: currency id
+func (c *ExchangeChaincode) queryCommitments() pb.Response {
+	myLogger.Debug("queryCommitments...")
+
+	if len(c.args) != 1 {
+		return shim.Error("Incorrect number of arguments. Expecting 1")
+	}
+
+	bb, err := c.getCompositeValue("Commitment~currency~uuid", []string{c.args[0]}, 1)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if len(bb) == 0 {
+		return shim.Error(NoDataErr.Error())
+	}
+
+	commitments := []*Commitment{}
+	for _, v := range bb {
+		commitment := new(Commitment)
+		err = json.Unmarshal(v, commitment)
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+		commitments = append(commitments, commitment)
+	}
+
+	payload, err := json.Marshal(commitments)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	return shim.Success(payload)
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/wutongtree/externality-chaincode/go/merkle"
+)
+
+func TestBalanceProof(t *testing.T) {
+	e := newTestEnv(t)
+	e.openAccount("alice")
+	e.openAccount("bob")
+	e.fund("alice", "EUR", 100)
+	e.fund("bob", "EUR", 50)
+	e.lockOrder("bob", "EUR", "o1", 20)
+
+	// an asset put before the holder index
+	e.stub.MockTransactionStart("legacy")
+	c := &ExchangeChaincode{stub: e.stub}
+	err := c.putAsset(&Asset{UUID: "legacy-carol", Owner: "carol", Currency: "EUR", Count: 30})
+	if err == nil {
+		err = c.delCompositeValue("Asset~currency~owner~uuid", []string{"EUR", "carol", "legacy-carol"})
+	}
+	e.stub.MockTransactionEnd("legacy")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	commitment := new(Commitment)
+	err = json.Unmarshal(e.mustInvoke("root", "commitBalances", "EUR"), commitment)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if commitment.Leaves != 3 || commitment.Total != 180 {
+		t.Fatalf("commitment: %+v", commitment)
+	}
+
+	// the owner and the auditors get the proof
+	if msg := e.mustFail("alice", "queryBalanceProof", "bob", "EUR", commitment.UUID); !strings.Contains(msg, "no permission") {
+		t.Fatalf("proof of another account: %s", msg)
+	}
+	e.mustInvoke("root", "grantRole", RoleAuditor, "Org1MSP/audit")
+	e.mustInvoke("audit", "queryBalanceProof", "carol", "EUR", commitment.UUID)
+
+	proof := new(merkle.Proof)
+	err = json.Unmarshal(e.mustInvoke("bob", "queryBalanceProof", "bob", "EUR", commitment.UUID), proof)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if proof.Balance != 50 || proof.Total != 180 {
+		t.Fatalf("proof: %+v", proof)
+	}
+	if ok, err := merkle.Verify(proof); err != nil || !ok {
+		t.Fatalf("verify: %v %v", ok, err)
+	}
+}
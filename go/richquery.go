@@ -0,0 +1,518 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	pb "github.com/hyperledger/fabric/protos/peer"
+)
+
+// docType of the stored records, used by the selectors of richQuery
+const (
+	DocAccount        = "account"
+	DocAsset          = "asset"
+	DocCurrency       = "currency"
+	DocReleaseLog     = "releaseLog"
+	DocBurnLog        = "burnLog"
+	DocAssignLog      = "assignLog"
+	DocTransferLog    = "transferLog"
+	DocLockLog        = "lockLog"
+	DocOrder          = "order"
+	DocFreezeLog      = "freezeLog"
+	DocFiatLog        = "fiatLog"
+	DocWithdrawal     = "withdrawal"
+	DocVesting        = "vesting"
+	DocEscrow         = "escrow"
+	DocHTLC           = "htlc"
+	DocProposal       = "proposal"
+	DocLimit          = "limit"
+	DocVolume         = "volume"
+	DocCandle         = "candle"
+	DocTicker         = "ticker"
+	DocOracleConfig   = "oracleConfig"
+	DocCircuitBreaker = "circuitBreaker"
+	DocStatement      = "statement"
+	DocCommitment     = "commitment"
+	DocSealedOrder    = "sealedOrder"
+	DocSealedFills    = "sealedFills"
+	DocSealKey        = "sealKey"
+	DocProcessed      = "processed"
+)
+
+const (
+	fieldString = "string"
+	fieldNumber = "number"
+	fieldBool   = "bool"
+
+	maxRichQueryLimit = 100
+	maxRichQuerySort  = 10000 // matches sorted in memory by the composite scan
+)
+
+// richEntity entity of richQuery, the index is scanned when the state database has no rich queries.
+// Owners are the fields holding the account of a record, nil when the records are public.
+type richEntity struct {
+	DocType  string
+	Index    string
+	KeyIndex int
+	Fields   map[string]string
+	Owners   []string
+}
+
+var richEntities = map[string]richEntity{
+	DocOrder: {DocOrder, "Order~owner~src~des~raw~uuid", 4, map[string]string{
+		"uuid": fieldString, "account": fieldString, "srcCurrency": fieldString, "srcCount": fieldNumber,
+		"desCurrency": fieldString, "desCount": fieldNumber, "isBuyAll": fieldBool, "expiredTime": fieldNumber,
+		"matchedTime": fieldNumber, "finishedTime": fieldNumber, "rawUUID": fieldString, "finalCost": fieldNumber,
+	}, []string{"account"}},
+	DocAsset: {DocAsset, "Asset~owner~uuid", 1, map[string]string{
+		"uuid": fieldString, "owner": fieldString, "currency": fieldString,
+		"count": fieldNumber, "lockCount": fieldNumber, "freezeCount": fieldNumber, "withdrawCount": fieldNumber,
+	}, []string{"owner"}},
+	DocReleaseLog: {DocReleaseLog, "ReleaseLog~owner~uuid", 1, map[string]string{
+		"uuid": fieldString, "currency": fieldString, "Releaser": fieldString, "cont": fieldNumber, "releaseTime": fieldNumber,
+	}, nil},
+	DocBurnLog: {DocBurnLog, "BurnLog~currency~uuid", 1, map[string]string{
+		"uuid": fieldString, "currency": fieldString, "burner": fieldString, "count": fieldNumber, "burnTime": fieldNumber,
+	}, nil},
+	DocAssignLog: {DocAssignLog, "AssignLog~to~uuid", 1, map[string]string{
+		"uuid": fieldString, "currency": fieldString, "fromUser": fieldString, "toUser": fieldString,
+		"count": fieldNumber, "assignTime": fieldNumber, "vestingUUID": fieldString, "reversalOf": fieldString, "reversedBy": fieldString,
+	}, []string{"toUser"}},
+	DocTransferLog: {DocTransferLog, "TransferLog~from~uuid", 1, map[string]string{
+		"uuid": fieldString, "currency": fieldString, "fromUser": fieldString, "toUser": fieldString,
+		"count": fieldNumber, "memo": fieldString, "transferTime": fieldNumber,
+	}, []string{"fromUser", "toUser"}},
+	DocLockLog: {DocLockLog, "LockLog~owner~curr~order~islock~uuid", 4, map[string]string{
+		"uuid": fieldString, "owner": fieldString, "currency": fieldString, "order": fieldString,
+		"isLock": fieldBool, "lockCount": fieldNumber, "lockTime": fieldNumber, "nonce": fieldNumber,
+	}, []string{"owner"}},
+	DocFreezeLog: {DocFreezeLog, "FreezeLog~owner~uuid", 1, map[string]string{
+		"uuid": fieldString, "action": fieldString, "owner": fieldString, "currency": fieldString, "count": fieldNumber,
+		"toUser": fieldString, "reason": fieldString, "operator": fieldString, "logTime": fieldNumber,
+	}, []string{"owner", "toUser"}},
+	DocFiatLog: {DocFiatLog, "FiatLog~owner~uuid", 1, map[string]string{
+		"uuid": fieldString, "action": fieldString, "owner": fieldString, "currency": fieldString, "amount": fieldNumber,
+		"bankRef": fieldString, "withdrawal": fieldString, "operator": fieldString, "logTime": fieldNumber,
+	}, []string{"owner"}},
+}
+
+var richOperators = map[string]bool{"$eq": true, "$ne": true, "$gt": true, "$gte": true, "$lt": true, "$lte": true, "$in": true}
+
+// richCondition one whitelisted condition of the filter
+type richCondition struct {
+	Field string
+	Op    string
+	Value interface{}
+}
+
+type richConditions []richCondition
+
+func (r richConditions) Len() int      { return len(r) }
+func (r richConditions) Swap(i, j int) { r[i], r[j] = r[j], r[i] }
+func (r richConditions) Less(i, j int) bool {
+	if r[i].Field != r[j].Field {
+		return r[i].Field < r[j].Field
+	}
+	return r[i].Op < r[j].Op
+}
+
+// richResult document matched by the composite scan
+type richResult struct {
+	doc map[string]interface{}
+	raw []byte
+}
+
+// richResults sort the matched documents on a field
+type richResults struct {
+	results []richResult
+	field   string
+	desc    bool
+}
+
+func (r *richResults) Len() int { return len(r.results) }
+func (r *richResults) Swap(i, j int) {
+	r.results[i], r.results[j] = r.results[j], r.results[i]
+}
+func (r *richResults) Less(i, j int) bool {
+	cmp, _ := compareRich(r.results[i].doc[r.field], r.results[j].doc[r.field])
+	if r.desc {
+		return cmp > 0
+	}
+	return cmp < 0
+}
+
+// RichQuery parsed query of richQuery
+type RichQuery struct {
+	Entity     richEntity
+	Conditions []richCondition
+	SortField  string
+	SortDesc   bool
+	Limit      int
+}
+
+func decodeJSON(b []byte, v interface{}) error {
+	d := json.NewDecoder(bytes.NewReader(b))
+	d.UseNumber()
+	return d.Decode(v)
+}
+
+// checkRichValue check the value matches the type of the field
+func checkRichValue(field, fieldType string, v interface{}) error {
+	ok := false
+	switch fieldType {
+	case fieldString:
+		_, ok = v.(string)
+	case fieldNumber:
+		_, ok = v.(json.Number)
+	case fieldBool:
+		_, ok = v.(bool)
+	}
+	if !ok {
+		return fmt.Errorf("The value of field [%s] must be a %s", field, fieldType)
+	}
+	return nil
+}
+
+// parseRichQuery validate the filter, sort and limit against the whitelist of the entity
+// filter: {"field": value, "field": {"$op": value}}, ops are $eq, $ne, $gt, $gte, $lt, $lte and $in
+// sort: "field" or "-field" for descending
+func parseRichQuery(entity, filter, sortBy, limit string) (*RichQuery, error) {
+	e, ok := richEntities[entity]
+	if !ok {
+		return nil, fmt.Errorf("Unknown entity [%s]", entity)
+	}
+	q := &RichQuery{Entity: e}
+
+	n, err := strconv.Atoi(limit)
+	if err != nil || n <= 0 || n > maxRichQueryLimit {
+		return nil, fmt.Errorf("The limit must be between 1 and %d", maxRichQueryLimit)
+	}
+	q.Limit = n
+
+	if sortBy != "" {
+		q.SortField = strings.TrimPrefix(sortBy, "-")
+		q.SortDesc = q.SortField != sortBy
+		if _, ok := e.Fields[q.SortField]; !ok {
+			return nil, fmt.Errorf("Unknown sort field [%s]", q.SortField)
+		}
+	}
+
+	if filter == "" {
+		return q, nil
+	}
+	var fields map[string]interface{}
+	err = decodeJSON([]byte(filter), &fields)
+	if err != nil {
+		return nil, fmt.Errorf("Failed unmarshalling filter: [%s]", err)
+	}
+	for field, v := range fields {
+		fieldType, ok := e.Fields[field]
+		if !ok {
+			return nil, fmt.Errorf("Unknown filter field [%s]", field)
+		}
+
+		ops, ok := v.(map[string]interface{})
+		if !ok {
+			ops = map[string]interface{}{"$eq": v}
+		}
+		for op, value := range ops {
+			if !richOperators[op] {
+				return nil, fmt.Errorf("Unknown operator [%s]", op)
+			}
+			if op == "$in" {
+				values, ok := value.([]interface{})
+				if !ok || len(values) == 0 {
+					return nil, fmt.Errorf("The value of $in must be a non-empty array")
+				}
+				for _, v := range values {
+					err = checkRichValue(field, fieldType, v)
+					if err != nil {
+						return nil, err
+					}
+				}
+			} else {
+				err = checkRichValue(field, fieldType, value)
+				if err != nil {
+					return nil, err
+				}
+			}
+			q.Conditions = append(q.Conditions, richCondition{Field: field, Op: op, Value: value})
+		}
+	}
+
+	// deterministic order of the selector and of the evaluation
+	sort.Sort(richConditions(q.Conditions))
+	return q, nil
+}
+
+// mango translate the query into a CouchDB Mango query
+func (q *RichQuery) mango() ([]byte, error) {
+	selector := map[string]interface{}{"docType": q.Entity.DocType}
+	for _, v := range q.Conditions {
+		ops, ok := selector[v.Field].(map[string]interface{})
+		if !ok {
+			ops = map[string]interface{}{}
+			selector[v.Field] = ops
+		}
+		ops[v.Op] = v.Value
+	}
+
+	query := map[string]interface{}{
+		"selector": selector,
+		"limit":    q.Limit,
+	}
+	if q.SortField != "" {
+		dir := "asc"
+		if q.SortDesc {
+			dir = "desc"
+		}
+		query["sort"] = []map[string]string{{q.SortField: dir}}
+	}
+	return json.Marshal(query)
+}
+
+// compareRich compare two values of the same field type
+func compareRich(a, b interface{}) (int, bool) {
+	switch x := a.(type) {
+	case string:
+		y, ok := b.(string)
+		if !ok {
+			return 0, false
+		}
+		return strings.Compare(x, y), true
+	case json.Number:
+		y, ok := b.(json.Number)
+		if !ok {
+			return 0, false
+		}
+		fx, err1 := x.Float64()
+		fy, err2 := y.Float64()
+		if err1 != nil || err2 != nil {
+			return 0, false
+		}
+		switch {
+		case fx < fy:
+			return -1, true
+		case fx > fy:
+			return 1, true
+		}
+		return 0, true
+	case bool:
+		y, ok := b.(bool)
+		if !ok {
+			return 0, false
+		}
+		switch {
+		case x == y:
+			return 0, true
+		case !x:
+			return -1, true
+		}
+		return 1, true
+	}
+	return 0, false
+}
+
+// match evaluate the conditions on a document, as the selector would
+func (q *RichQuery) match(doc map[string]interface{}) bool {
+	if doc["docType"] != q.Entity.DocType {
+		return false
+	}
+
+	for _, v := range q.Conditions {
+		value, exists := doc[v.Field]
+		if !exists {
+			return false
+		}
+
+		if v.Op == "$in" {
+			found := false
+			for _, item := range v.Value.([]interface{}) {
+				if cmp, ok := compareRich(value, item); ok && cmp == 0 {
+					found = true
+					break
+				}
+			}
+			if !found {
+				return false
+			}
+			continue
+		}
+
+		cmp, ok := compareRich(value, v.Value)
+		if !ok {
+			return false
+		}
+		switch v.Op {
+		case "$eq":
+			ok = cmp == 0
+		case "$ne":
+			ok = cmp != 0
+		case "$gt":
+			ok = cmp > 0
+		case "$gte":
+			ok = cmp >= 0
+		case "$lt":
+			ok = cmp < 0
+		case "$lte":
+			ok = cmp <= 0
+		}
+		if !ok {
+			return false
+		}
+	}
+	return true
+}
+
+// execMango run the query on CouchDB
+func (c *ExchangeChaincode) execMango(q *RichQuery) ([]json.RawMessage, error) {
+	query, err := q.mango()
+	if err != nil {
+		return nil, err
+	}
+
+	resultsIterator, err := c.stub.GetQueryResult(string(query))
+	if err != nil {
+		return nil, err
+	}
+	defer resultsIterator.Close()
+
+	docs := []json.RawMessage{}
+	for resultsIterator.HasNext() && len(docs) < q.Limit {
+		_, value, err := resultsIterator.Next()
+		if err != nil {
+			return nil, err
+		}
+		docs = append(docs, json.RawMessage(value))
+	}
+	return docs, nil
+}
+
+// execScan run the query on the composite index of the entity, for state databases without rich queries
+func (c *ExchangeChaincode) execScan(q *RichQuery) ([]json.RawMessage, error) {
+	resultsIterator, err := c.stub.GetStateByPartialCompositeKey(q.Entity.Index, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer resultsIterator.Close()
+
+	var results []richResult
+	for resultsIterator.HasNext() {
+		if q.SortField == "" && len(results) == q.Limit {
+			break
+		}
+
+		compositeKey, _, err := resultsIterator.Next()
+		if err != nil {
+			return nil, err
+		}
+		_, compositeKeyParts, err := c.stub.SplitCompositeKey(compositeKey)
+		if err != nil {
+			return nil, err
+		}
+
+		raw, err := c.stub.GetState(compositeKeyParts[q.Entity.KeyIndex])
+		if err != nil {
+			return nil, err
+		}
+		if raw == nil {
+			continue
+		}
+
+		var doc map[string]interface{}
+		err = decodeJSON(raw, &doc)
+		if err != nil {
+			return nil, err
+		}
+		// records put before the docType are of the entity of their index
+		if _, ok := doc["docType"]; !ok {
+			doc["docType"] = q.Entity.DocType
+		}
+		if !q.match(doc) {
+			continue
+		}
+		if len(results) == maxRichQuerySort {
+			return nil, errors.New("Too many results to sort, narrow the filter")
+		}
+		results = append(results, richResult{doc: doc, raw: raw})
+	}
+
+	if q.SortField != "" {
+		sort.Stable(&richResults{results: results, field: q.SortField, desc: q.SortDesc})
+	}
+
+	docs := []json.RawMessage{}
+	for _, v := range results {
+		if len(docs) == q.Limit {
+			break
+		}
+		docs = append(docs, json.RawMessage(v.raw))
+	}
+	return docs, nil
+}
+
+// checkRichQueryCaller the auditor and compliance roles query every record, the other callers only the records
+// of their bound account: the filter must match it on one of the owner fields of the entity
+func (c *ExchangeChaincode) checkRichQueryCaller(q *RichQuery) error {
+	if len(q.Entity.Owners) == 0 {
+		return nil
+	}
+	_, err := c.checkRole(RoleAuditor, RoleCompliance)
+	if err == nil {
+		return nil
+	}
+
+	account, err := c.getCallerAccount()
+	if err != nil {
+		return err
+	}
+	for _, v := range q.Conditions {
+		if v.Op == "$eq" && v.Value == account && containsString(q.Entity.Owners, v.Field) {
+			return nil
+		}
+	}
+	return fmt.Errorf("The query of [%s] must filter %s on the account of the caller", q.Entity.DocType, strings.Join(q.Entity.Owners, " or "))
+}
+
+// richQuery query orders, assets or logs with a whitelisted filter, translated to a Mango selector on CouchDB.
+// The composite index of the entity is scanned when the state database has no rich queries (LevelDB, MockStub).
+// Without the auditor or compliance role the filter must match the account of the caller.
+// args: entity, filter, sort, limit
+func (c *ExchangeChaincode) richQuery() pb.Response {
+	myLogger.Debug("richQuery...")
+
+	if len(c.args) != 4 {
+		return shim.Error("Incorrect number of arguments. Expecting 4")
+	}
+
+	q, err := parseRichQuery(c.args[0], c.args[1], c.args[2], c.args[3])
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	err = c.checkRichQueryCaller(q)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	docs, err := c.execMango(q)
+	if err != nil {
+		myLogger.Warningf("richQuery falls back to the composite scan: %s", err)
+		docs, err = c.execScan(q)
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+	}
+
+	payload, err := json.Marshal(docs)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	return shim.Success(payload)
+}
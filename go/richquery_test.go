@@ -0,0 +1,99 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestRichQueryLegacyDocType(t *testing.T) {
+	e := newTestEnv(t)
+	e.openAccount("alice")
+	e.openAccount("bob")
+	e.fund("alice", "EUR", 10)
+	e.fund("bob", "EUR", 20)
+
+	// records put before the docType
+	e.stub.MockTransactionStart("legacy")
+	for _, owner := range []string{"alice", "bob"} {
+		asset := e.asset(owner, "EUR")
+		b, err := json.Marshal(map[string]interface{}{"uuid": asset.UUID, "owner": asset.Owner, "currency": asset.Currency, "count": asset.Count})
+		if err == nil {
+			err = e.stub.PutState(asset.UUID, b)
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	e.stub.MockTransactionEnd("legacy")
+
+	query := func() int {
+		var docs []json.RawMessage
+		err := json.Unmarshal(e.mustInvoke("root", "richQuery", DocAsset, `{"currency":"EUR"}`, "", "10"), &docs)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return len(docs)
+	}
+	if n := query(); n != 2 {
+		t.Fatalf("legacy assets found by the scan: %d, expecting 2", n)
+	}
+
+	var pages, updated int
+	bookmark := ""
+	for {
+		result := new(MigrateResult)
+		err := json.Unmarshal(e.mustInvoke("root", "migrate", MigrateDocTypes, bookmark, "3"), result)
+		if err != nil {
+			t.Fatal(err)
+		}
+		pages++
+		updated += result.Updated
+		bookmark = result.Bookmark
+		if bookmark == "" {
+			break
+		}
+	}
+	if updated != 2 || pages < 2 {
+		t.Fatalf("got %d pages and %d records, expecting 2 records", pages, updated)
+	}
+	if asset := e.asset("bob", "EUR"); asset.DocType != DocAsset || asset.Count != 20 {
+		t.Fatalf("migrated asset: %+v", asset)
+	}
+	if n := query(); n != 2 {
+		t.Fatalf("migrated assets found by the scan: %d, expecting 2", n)
+	}
+}
+
+func TestRichQueryCaller(t *testing.T) {
+	e := newTestEnv(t)
+	e.openAccount("alice")
+	e.openAccount("bob")
+	e.fund("alice", "EUR", 10)
+	e.fund("bob", "EUR", 20)
+	e.mustInvoke("alice", "transfer", "EUR", "bob", "5", "")
+	e.mustInvoke("root", "grantRole", RoleAuditor, "Org1MSP/audit")
+
+	count := func(cn, entity, filter string) int {
+		var docs []json.RawMessage
+		err := json.Unmarshal(e.mustInvoke(cn, "richQuery", entity, filter, "", "10"), &docs)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return len(docs)
+	}
+
+	// an account only reads its own records
+	e.mustFail("alice", "richQuery", DocAsset, `{"currency":"EUR"}`, "", "10")
+	e.mustFail("alice", "richQuery", DocAsset, `{"owner":"bob"}`, "", "10")
+	e.mustFail("mallory", "richQuery", DocAsset, `{"owner":"mallory"}`, "", "10")
+	if n := count("alice", DocAsset, `{"owner":"alice","currency":"EUR"}`); n != 1 {
+		t.Fatalf("alice assets: %d, expecting 1", n)
+	}
+	if n := count("bob", DocTransferLog, `{"toUser":"bob"}`); n != 1 {
+		t.Fatalf("bob incoming transfers: %d, expecting 1", n)
+	}
+
+	if n := count("audit", DocAsset, `{"currency":"EUR"}`); n != 2 {
+		t.Fatalf("assets read by the auditor: %d, expecting 2", n)
+	}
+}
@@ -0,0 +1,271 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	pb "github.com/hyperledger/fabric/protos/peer"
+)
+
+// Ring orders settled as a cycle: each order pays finalCost of its srcCurrency to the next order,
+// which receives it as its desCount, the last order pays the first one
+type Ring struct {
+	UUID   string   `json:"uuid"`
+	Orders []*Order `json:"orders"`
+}
+
+// checkRing check every leg of the ring is balanced
+func checkRing(ring *Ring) error {
+	if ring.UUID == "" {
+		return errors.New("The ring uuid is empty")
+	}
+	if len(ring.Orders) < 2 {
+		return errors.New("The ring must have at least 2 orders")
+	}
+
+	uuids := make(map[string]bool)
+	accounts := make(map[string]bool)
+	for i, order := range ring.Orders {
+		if order == nil || order.UUID == "" {
+			return fmt.Errorf("The order %d of the ring is empty", i)
+		}
+		if uuids[order.UUID] {
+			return fmt.Errorf("The order [%s] is repeated in the ring", order.UUID)
+		}
+		uuids[order.UUID] = true
+		if accounts[order.Account] {
+			return fmt.Errorf("The account [%s] is repeated in the ring", order.Account)
+		}
+		accounts[order.Account] = true
+
+		if order.FinalCost <= 0 || order.DesCount <= 0 {
+			return fmt.Errorf("The count of order [%s] must be > 0", order.UUID)
+		}
+		if order.SrcCurrency == order.DesCurrency {
+			return fmt.Errorf("The order [%s] exchanges [%s] for itself", order.UUID, order.SrcCurrency)
+		}
+
+		next := ring.Orders[(i+1)%len(ring.Orders)]
+		if next == nil || order.SrcCurrency != next.DesCurrency || order.FinalCost != next.DesCount {
+			return fmt.Errorf("The ring leg from order [%s] is not balanced", order.UUID)
+		}
+	}
+	return nil
+}
+
+// settleRing settle a ring of orders atomically, any failing order fails the whole ring.
+// The orders go through the checks of exchange, a processed ring uuid returns AlreadyProcessed.
+// The result is the payload: a ring tripping a circuit breaker is failed with the halted pair, the halt is kept.
+// Rings are not published to the market data, their legs are not two-sided trades.
+// args: ring json {uuid, orders}
+func (c *ExchangeChaincode) settleRing() pb.Response {
+	myLogger.Debug("Settle Ring...")
+
+	if len(c.args) != 1 {
+		return shim.Error("Incorrect number of arguments. Expecting 1")
+	}
+
+	ring := new(Ring)
+	err := json.Unmarshal([]byte(c.args[0]), ring)
+	if err != nil {
+		myLogger.Errorf("settleRing error1:%s", err)
+		return shim.Error("Failed unmarshalling ring")
+	}
+	err = checkRing(ring)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	processed, err := c.getProcessed(ProcessedMatch, ring.UUID)
+	if err != nil {
+		myLogger.Errorf("settleRing error2:%s", err)
+		return shim.Error(err.Error())
+	}
+	if processed != nil {
+		return pb.Response{
+			Status:  AlreadyProcessed,
+			Message: fmt.Sprintf("The ring [%s] is already processed in tx [%s]", ring.UUID, processed.TxID),
+		}
+	}
+
+	oracle, err := c.getOracleConfig()
+	if err != nil {
+		myLogger.Errorf("settleRing error3:%s", err)
+		return shim.Error(err.Error())
+	}
+	signatureRequired, err := c.isOrderSignatureRequired()
+	if err != nil {
+		myLogger.Errorf("settleRing error4:%s", err)
+		return shim.Error(err.Error())
+	}
+
+	// the circuit breakers first, a trip is committed and reported in the result instead of failing the tx
+	breakers := newBatchBreakers()
+	for _, order := range ring.Orders {
+		tripped, err, errType := c.checkCircuitBreaker(breakers, order)
+		if errType == WorldStateErr {
+			myLogger.Errorf("settleRing error13:%s", err)
+			return shim.Error(err.Error())
+		} else if tripped != nil {
+			putErr := c.putCircuitBreakers(breakers)
+			if putErr != nil {
+				myLogger.Errorf("settleRing error19:%s", putErr)
+				return shim.Error(putErr.Error())
+			}
+			return c.ringResult(&BatchResult{
+				EventName: "chaincode_settleRing",
+				Fail:      []FailInfo{{Id: ring.UUID, Info: err.Error()}},
+				Halted:    []string{tripped.Base + "/" + tripped.Quote},
+			})
+		} else if err != nil {
+			return shim.Error(err.Error())
+		}
+	}
+
+	// check every order before any change
+	volumes := newBatchVolumes()
+	uuids := make([]string, len(ring.Orders))
+	for i, order := range ring.Orders {
+		uuids[i] = order.UUID
+
+		exchanged, err := c.isOrderExchanged(order.UUID)
+		if err != nil {
+			myLogger.Errorf("settleRing error5:%s", err)
+			return shim.Error(err.Error())
+		}
+		if exchanged {
+			return shim.Error(fmt.Sprintf("The order [%s] is already exchanged", order.UUID))
+		}
+
+		err, errType := c.checkAccountActive(order.Account)
+		if errType == WorldStateErr {
+			myLogger.Errorf("settleRing error14:%s", err)
+			return shim.Error(err.Error())
+		} else if err != nil {
+			return shim.Error(err.Error())
+		}
+		err, errType = c.checkSealKey(order)
+		if errType == WorldStateErr {
+			myLogger.Errorf("settleRing error18:%s", err)
+			return shim.Error(err.Error())
+		} else if err != nil {
+			return shim.Error(err.Error())
+		}
+		err, errType = c.checkOrderSignature(order, signatureRequired)
+		if errType == WorldStateErr {
+			myLogger.Errorf("settleRing error15:%s", err)
+			return shim.Error(err.Error())
+		} else if err != nil {
+			return shim.Error(err.Error())
+		}
+		err, errType = c.checkCurrencyOpen(order.SrcCurrency, PauseTrading)
+		if errType == WorldStateErr {
+			myLogger.Errorf("settleRing error16:%s", err)
+			return shim.Error(err.Error())
+		} else if err != nil {
+			return shim.Error(err.Error())
+		}
+
+		if oracle != nil && oracle.Chaincode != "" {
+			err = c.checkOraclePrice(oracle, order)
+			if err != nil {
+				return shim.Error(err.Error())
+			}
+		}
+
+		volume, err, errType := c.checkLimit(volumes, order.Account, order.SrcCurrency, order.FinalCost)
+		if errType == WorldStateErr {
+			myLogger.Errorf("settleRing error17:%s", err)
+			return shim.Error(err.Error())
+		} else if err != nil {
+			return shim.Error(err.Error())
+		}
+		// the ring settles all legs or none
+		volumes.add(volume, order.FinalCost)
+	}
+
+	// same lock accounting as execTx
+	for _, order := range ring.Orders {
+		err, errType := c.settleOrder(order)
+		if errType == WorldStateErr {
+			myLogger.Errorf("settleRing error6:%s", err)
+			return shim.Error(err.Error())
+		} else if err != nil {
+			return shim.Error(err.Error())
+		}
+	}
+	err = c.putVolumes(volumes)
+	if err != nil {
+		myLogger.Errorf("settleRing error7:%s", err)
+		return shim.Error(err.Error())
+	}
+	for _, order := range ring.Orders {
+		c.fillCircuitBreaker(breakers, order)
+	}
+	err = c.putCircuitBreakers(breakers)
+	if err != nil {
+		myLogger.Errorf("settleRing error19:%s", err)
+		return shim.Error(err.Error())
+	}
+
+	err = c.recordMatch(ring.UUID, uuids...)
+	if err != nil {
+		myLogger.Errorf("settleRing error8:%s", err)
+		return shim.Error(err.Error())
+	}
+
+	// txlog, each order pays the next one and is paid by the previous one
+	n := len(ring.Orders)
+	for i, order := range ring.Orders {
+		err = c.putOrderLog(order)
+		if err != nil {
+			myLogger.Errorf("settleRing error9:%s", err)
+			return shim.Error(err.Error())
+		}
+		err = c.putCompositeValue("Order~uuid", []string{order.UUID})
+		if err != nil {
+			myLogger.Errorf("settleRing error10:%s", err)
+			return shim.Error(err.Error())
+		}
+
+		err = c.putStatement(order.Account, order.SrcCurrency, &StatementEntry{
+			Kind:         StatementFill,
+			Ref:          order.UUID,
+			Counterparty: ring.Orders[(i+1)%n].Account,
+			Locked:       -order.FinalCost,
+		})
+		if err != nil {
+			myLogger.Errorf("settleRing error11:%s", err)
+			return shim.Error(err.Error())
+		}
+		err = c.putStatement(order.Account, order.DesCurrency, &StatementEntry{
+			Kind:         StatementFill,
+			Ref:          order.UUID,
+			Counterparty: ring.Orders[(i+n-1)%n].Account,
+			Available:    order.DesCount,
+		})
+		if err != nil {
+			myLogger.Errorf("settleRing error11:%s", err)
+			return shim.Error(err.Error())
+		}
+	}
+
+	myLogger.Debug("Settle Ring...done")
+	return c.ringResult(&BatchResult{EventName: "chaincode_settleRing", Success: []string{ring.UUID}})
+}
+
+// ringResult send the result of the ring as the event and the payload
+func (c *ExchangeChaincode) ringResult(batch *BatchResult) pb.Response {
+	result, err := json.Marshal(batch)
+	if err != nil {
+		myLogger.Errorf("settleRing error12:%s", err)
+		return shim.Error(err.Error())
+	}
+	err = c.stub.SetEvent(batch.EventName, result)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	return shim.Success(result)
+}
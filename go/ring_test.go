@@ -0,0 +1,97 @@
+package main
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+// ring alice pays eur for btc of carol, bob pays gbp for eur of alice, carol pays btc for gbp of bob
+func ring(id string, eur, btc, gbp int64) string {
+	b, err := json.Marshal(&Ring{UUID: id, Orders: []*Order{
+		{UUID: "a" + id, RawUUID: "a" + id, Account: "alice", SrcCurrency: "EUR", SrcCount: eur, FinalCost: eur, DesCurrency: "BTC", DesCount: btc},
+		{UUID: "b" + id, RawUUID: "b" + id, Account: "bob", SrcCurrency: "GBP", SrcCount: gbp, FinalCost: gbp, DesCurrency: "EUR", DesCount: eur},
+		{UUID: "c" + id, RawUUID: "c" + id, Account: "carol", SrcCurrency: "BTC", SrcCount: btc, FinalCost: btc, DesCurrency: "GBP", DesCount: gbp},
+	}})
+	if err != nil {
+		panic(err)
+	}
+	return string(b)
+}
+
+func newRingEnv(t *testing.T) *testEnv {
+	e := newTestEnv(t)
+	e.now = 1500000000
+	for _, v := range []struct{ owner, currency string }{{"alice", "EUR"}, {"bob", "GBP"}, {"carol", "BTC"}} {
+		e.openAccount(v.owner)
+		e.fund(v.owner, v.currency, 1000)
+	}
+	return e
+}
+
+func (e *testEnv) lockRing(id string, eur, btc, gbp int64) {
+	e.lockOrder("alice", "EUR", "a"+id, eur)
+	e.lockOrder("bob", "GBP", "b"+id, gbp)
+	e.lockOrder("carol", "BTC", "c"+id, btc)
+}
+
+func TestSettleRing(t *testing.T) {
+	e := newRingEnv(t)
+	e.lockRing("1", 100, 50, 80)
+
+	result := new(BatchResult)
+	err := json.Unmarshal(e.mustInvoke("root", "settleRing", ring("1", 100, 50, 80)), result)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(result.Success) != 1 {
+		t.Fatalf("ring: %+v", result)
+	}
+	for _, v := range []struct {
+		owner, currency string
+		count           int64
+	}{{"alice", "BTC", 50}, {"bob", "EUR", 100}, {"carol", "GBP", 80}, {"alice", "EUR", 900}} {
+		if got := e.asset(v.owner, v.currency); got.Count != v.count || got.LockCount != 0 {
+			t.Fatalf("%s %s: %+v, expecting %d", v.owner, v.currency, got, v.count)
+		}
+	}
+
+	if r := e.invoke("root", "settleRing", ring("1", 100, 50, 80)); r.Status != AlreadyProcessed {
+		t.Fatalf("replayed ring: %d %s", r.Status, r.Message)
+	}
+
+	// an unbalanced leg fails the whole ring
+	e.lockRing("2", 100, 50, 80)
+	bad := strings.Replace(ring("2", 100, 50, 80), `"finalCost":80`, `"finalCost":70`, 1)
+	e.mustFail("root", "settleRing", bad)
+	if got := e.asset("bob", "GBP"); got.LockCount != 80 {
+		t.Fatalf("bob GBP after the failed ring: %+v", got)
+	}
+}
+
+func TestSettleRingHalt(t *testing.T) {
+	e := newRingEnv(t)
+	e.mustInvoke("root", "setCircuitBreaker", "EUR", "BTC", "1000", "3600")
+
+	e.lockRing("1", 100, 50, 80)
+	e.mustInvoke("root", "settleRing", ring("1", 100, 50, 80))
+
+	// 50% up trips the breaker, the ring fails in the result and the halt is committed
+	e.lockRing("2", 150, 50, 80)
+	result := new(BatchResult)
+	err := json.Unmarshal(e.mustInvoke("root", "settleRing", ring("2", 150, 50, 80)), result)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(result.Success) != 0 || len(result.Fail) != 1 || len(result.Halted) != 1 || result.Halted[0] != "BTC/EUR" {
+		t.Fatalf("tripping ring: %+v", result)
+	}
+	if got := e.asset("alice", "EUR"); got.LockCount != 150 {
+		t.Fatalf("alice EUR after the tripping ring: %+v", got)
+	}
+
+	e.lockRing("3", 100, 50, 80)
+	if msg := e.mustFail("root", "settleRing", ring("3", 100, 50, 80)); !strings.Contains(msg, "halted") {
+		t.Fatalf("ring of a halted pair: %s", msg)
+	}
+}
@@ -0,0 +1,169 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	pb "github.com/hyperledger/fabric/protos/peer"
+)
+
+const (
+	RoleAdmin      = "admin"
+	RoleCompliance = "compliance"
+	RoleCustodian  = "custodian"
+	RoleAuditor    = "auditor"
+)
+
+var allRoles = []string{RoleAdmin, RoleCompliance, RoleCustodian, RoleAuditor}
+
+func (c *ExchangeChaincode) putRole(role, identity string) error {
+	return c.putCompositeValue("Role~role~identity", []string{role, identity})
+}
+
+func (c *ExchangeChaincode) delRole(role, identity string) error {
+	key, err := c.stub.CreateCompositeKey("Role~role~identity", []string{role, identity})
+	if err != nil {
+		return err
+	}
+	return c.stub.DelState(key)
+}
+
+func (c *ExchangeChaincode) hasRole(role, identity string) (bool, error) {
+	key, err := c.stub.CreateCompositeKey("Role~role~identity", []string{role, identity})
+	if err != nil {
+		return false, err
+	}
+	b, err := c.stub.GetState(key)
+	if err != nil {
+		return false, err
+	}
+	return b != nil, nil
+}
+
+// getRoleMembers
+func (c *ExchangeChaincode) getRoleMembers(role string) ([]string, error) {
+	resultsIterator, err := c.stub.GetStateByPartialCompositeKey("Role~role~identity", []string{role})
+	if err != nil {
+		return nil, err
+	}
+	defer resultsIterator.Close()
+
+	var members []string
+	for resultsIterator.HasNext() {
+		compositeKey, _, err := resultsIterator.Next()
+		if err != nil {
+			return nil, err
+		}
+
+		_, compositeKeyParts, err := c.stub.SplitCompositeKey(compositeKey)
+		if err != nil {
+			return nil, err
+		}
+		members = append(members, compositeKeyParts[1])
+	}
+
+	return members, nil
+}
+
+// checkRole check the tx creator holds one of the roles, admin holds all roles
+func (c *ExchangeChaincode) checkRole(roles ...string) (string, error) {
+	caller, err := c.getCaller()
+	if err != nil {
+		return "", err
+	}
+
+	for _, role := range append([]string{RoleAdmin}, roles...) {
+		ok, err := c.hasRole(role, caller)
+		if err != nil {
+			return "", err
+		}
+		if ok {
+			return caller, nil
+		}
+	}
+
+	return "", fmt.Errorf("[%s] has no permission, expecting role %v", caller, roles)
+}
+
+// grantRole grant a role to an identity
+// args: role, identity (mspid/common name)
+func (c *ExchangeChaincode) grantRole() pb.Response {
+	myLogger.Debug("Grant Role...")
+
+	if len(c.args) != 2 {
+		return shim.Error("Incorrect number of arguments. Expecting 2")
+	}
+
+	role := c.args[0]
+	identity := c.args[1]
+	if !containsString(allRoles, role) {
+		return shim.Error(fmt.Sprintf("Unknown role [%s]", role))
+	}
+
+	_, err := c.checkRole(RoleAdmin)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	err = c.putRole(role, identity)
+	if err != nil {
+		myLogger.Errorf("grantRole error1:%s", err)
+		return shim.Error(err.Error())
+	}
+
+	myLogger.Debug("Grant Role...done")
+	return shim.Success(nil)
+}
+
+// revokeRole revoke a role from an identity
+// args: role, identity (mspid/common name)
+func (c *ExchangeChaincode) revokeRole() pb.Response {
+	myLogger.Debug("Revoke Role...")
+
+	if len(c.args) != 2 {
+		return shim.Error("Incorrect number of arguments. Expecting 2")
+	}
+
+	role := c.args[0]
+	identity := c.args[1]
+
+	caller, err := c.checkRole(RoleAdmin)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if role == RoleAdmin && identity == caller {
+		return shim.Error("Can't revoke the admin role of yourself")
+	}
+
+	err = c.delRole(role, identity)
+	if err != nil {
+		myLogger.Errorf("revokeRole error1:%s", err)
+		return shim.Error(err.Error())
+	}
+
+	myLogger.Debug("Revoke Role...done")
+	return shim.Success(nil)
+}
+
+// queryRole
+// args: role
+func (c *ExchangeChaincode) queryRole() pb.Response {
+	myLogger.Debug("queryRole...")
+
+	if len(c.args) != 1 {
+		return shim.Error("Incorrect number of arguments. Expecting 1")
+	}
+
+	members, err := c.getRoleMembers(c.args[0])
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	payload, err := json.Marshal(members)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	return shim.Success(payload)
+}
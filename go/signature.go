@@ -0,0 +1,247 @@
+package main
+
+import (
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"strconv"
+
+	"github.com/hyperledger/fabric/bccsp"
+	"github.com/hyperledger/fabric/bccsp/factory"
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	pb "github.com/hyperledger/fabric/protos/peer"
+)
+
+// key of the order signature policy, when set every order of an exchange must be signed
+const orderSignatureKey = "OrderSignatureRequired"
+
+// OrderTerms raw order as signed by the account holder, Nonce is the nonce of the lock of the raw order.
+// The signed message is the json of the terms, fields in this order and without spaces, no nonce when it is 0.
+type OrderTerms struct {
+	RawUUID     string `json:"rawUUID"`
+	Account     string `json:"account"`
+	SrcCurrency string `json:"srcCurrency"`
+	SrcCount    int64  `json:"srcCount"`
+	DesCurrency string `json:"desCurrency"`
+	DesCount    int64  `json:"desCount"`
+	IsBuyAll    bool   `json:"isBuyAll"`
+	ExpiredTime int64  `json:"expiredTime"`
+	Nonce       int64  `json:"nonce,omitempty"`
+}
+
+// importOrderKey import a PEM encoded ECDSA public key or certificate
+func importOrderKey(keyPem string) (bccsp.Key, error) {
+	block, _ := pem.Decode([]byte(keyPem))
+	if block == nil {
+		return nil, errors.New("The key is not PEM encoded")
+	}
+
+	var key bccsp.Key
+	var err error
+	switch block.Type {
+	case "CERTIFICATE":
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, fmt.Errorf("Failed parsing certificate: [%s]", err)
+		}
+		key, err = factory.GetDefault().KeyImport(cert, &bccsp.X509PublicKeyImportOpts{Temporary: true})
+	case "PUBLIC KEY":
+		key, err = factory.GetDefault().KeyImport(block.Bytes, &bccsp.ECDSAPKIXPublicKeyImportOpts{Temporary: true})
+	default:
+		return nil, fmt.Errorf("Unknown PEM type [%s], expecting CERTIFICATE or PUBLIC KEY", block.Type)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if key.Symmetric() || key.Private() {
+		return nil, errors.New("The key must be a public key")
+	}
+	return key, nil
+}
+
+// verifyOrderSignature verify the ECDSA signature (DER, low-S, base64) of the SHA-256 of the signed message
+func verifyOrderSignature(key bccsp.Key, terms *OrderTerms, signature string) error {
+	sig, err := base64.StdEncoding.DecodeString(signature)
+	if err != nil {
+		return errors.New("The order signature is not base64 encoded")
+	}
+	msg, err := json.Marshal(terms)
+	if err != nil {
+		return err
+	}
+	digest, err := sha256Digest(msg)
+	if err != nil {
+		return err
+	}
+
+	ok, err := factory.GetDefault().Verify(key, sig, digest, nil)
+	if err != nil {
+		return fmt.Errorf("Failed verifying the order signature: [%s]", err)
+	}
+	if !ok {
+		return errors.New("The order signature is invalid")
+	}
+	return nil
+}
+
+func (c *ExchangeChaincode) isOrderSignatureRequired() (bool, error) {
+	requiredByte, err := c.stub.GetState(orderSignatureKey)
+	if err != nil {
+		return false, err
+	}
+	return string(requiredByte) == "true", nil
+}
+
+// checkOrderSignature check the order matches the terms signed by its account.
+// Orders of accounts without a registered key pass unless signatures are required.
+func (c *ExchangeChaincode) checkOrderSignature(order *Order, required bool) (error, ErrType) {
+	account, err := c.getAccount(order.Account)
+	if err != nil {
+		return fmt.Errorf("Failed retrieving account [%s]: [%s]", order.Account, err), WorldStateErr
+	}
+	if account == nil || account.PublicKey == "" {
+		if required || order.Signature != "" {
+			return fmt.Errorf("The account [%s] has no registered key", order.Account), CheckErr
+		}
+		return nil, ErrType("")
+	}
+
+	terms := order.Terms
+	if terms == nil || order.Signature == "" {
+		return fmt.Errorf("The order [%s] is not signed", order.UUID), CheckErr
+	}
+	if terms.RawUUID != order.RawUUID || terms.Account != order.Account ||
+		terms.SrcCurrency != order.SrcCurrency || terms.DesCurrency != order.DesCurrency ||
+		terms.IsBuyAll != order.IsBuyAll {
+		return fmt.Errorf("The order [%s] does not match its signed terms", order.UUID), CheckErr
+	}
+	if terms.ExpiredTime > 0 && c.txTime() > terms.ExpiredTime {
+		return fmt.Errorf("The order [%s] is expired", order.UUID), CheckErr
+	}
+	lockLog, err := c.getLockLogByParm(order.Account, order.SrcCurrency, order.RawUUID, true)
+	if err != nil {
+		return fmt.Errorf("Failed retrieving the lock of order [%s]: [%s]", order.RawUUID, err), WorldStateErr
+	}
+	lockNonce := int64(0)
+	if lockLog != nil {
+		lockNonce = lockLog.Nonce
+	}
+	if terms.Nonce != lockNonce {
+		return fmt.Errorf("The order [%s] is not signed with the nonce of its lock", order.UUID), CheckErr
+	}
+	if order.FinalCost <= 0 || order.DesCount <= 0 {
+		return fmt.Errorf("The fill of order [%s] must be > 0", order.UUID), CheckErr
+	}
+	// the fill may not pay more than the signed price: finalCost / desCount <= srcCount / desCount of the terms
+	if order.FinalCost > terms.SrcCount ||
+		new(big.Int).Mul(big.NewInt(order.FinalCost), big.NewInt(terms.DesCount)).Cmp(
+			new(big.Int).Mul(big.NewInt(order.DesCount), big.NewInt(terms.SrcCount))) > 0 {
+		return fmt.Errorf("The fill of order [%s] is worse than its signed price", order.UUID), CheckErr
+	}
+	// with the earlier fills of the raw order, the fills may not spend more than signed nor buy more for a buy-all
+	fills, err := c.getRawFills(order.Account, order.SrcCurrency, order.DesCurrency, order.RawUUID)
+	if err != nil {
+		return fmt.Errorf("Failed retrieving the fills of order [%s]: [%s]", order.RawUUID, err), WorldStateErr
+	}
+	if fills.FinalCost+order.FinalCost > terms.SrcCount {
+		return fmt.Errorf("The fills of order [%s] exceed its signed amount", order.RawUUID), CheckErr
+	}
+	if terms.IsBuyAll && fills.DesCount+order.DesCount > terms.DesCount {
+		return fmt.Errorf("The fills of order [%s] exceed its signed count", order.RawUUID), CheckErr
+	}
+
+	key, err := importOrderKey(account.PublicKey)
+	if err != nil {
+		return err, CheckErr
+	}
+	err = verifyOrderSignature(key, terms, order.Signature)
+	if err != nil {
+		return fmt.Errorf("The order [%s]: %s", order.UUID, err), CheckErr
+	}
+	return nil, ErrType("")
+}
+
+// setAccountKey register the key verifying the orders of an account, by the account itself or the compliance role.
+// An empty key removes it.
+// args: account, PEM encoded ECDSA public key or certificate
+func (c *ExchangeChaincode) setAccountKey() pb.Response {
+	myLogger.Debug("Set Account Key...")
+
+	if len(c.args) != 2 {
+		return shim.Error("Incorrect number of arguments. Expecting 2")
+	}
+	name := c.args[0]
+	keyPem := c.args[1]
+
+	caller, err := c.getCaller()
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	owner, _ := c.getCallerAccount()
+	if owner != name {
+		caller, err = c.checkRole(RoleCompliance)
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+	}
+
+	if keyPem != "" {
+		_, err = importOrderKey(keyPem)
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+	}
+
+	account, err := c.getAccount(name)
+	if err != nil {
+		myLogger.Errorf("setAccountKey error1:%s", err)
+		return shim.Error(fmt.Sprintf("Failed retrieving account [%s]: [%s]", name, err))
+	}
+	if account == nil {
+		return shim.Error(fmt.Sprintf("The account [%s] is not registered", name))
+	}
+
+	account.PublicKey = keyPem
+	account.UpdateTime = c.txTime()
+	account.UpdatedBy = caller
+	err = c.putAccount(account)
+	if err != nil {
+		myLogger.Errorf("setAccountKey error2:%s", err)
+		return shim.Error(err.Error())
+	}
+
+	myLogger.Debug("Set Account Key...done")
+	return shim.Success(nil)
+}
+
+// setOrderSignatureRequired require signed orders from every account, admin only
+// args: true|false
+func (c *ExchangeChaincode) setOrderSignatureRequired() pb.Response {
+	myLogger.Debug("Set Order Signature Required...")
+
+	if len(c.args) != 1 {
+		return shim.Error("Incorrect number of arguments. Expecting 1")
+	}
+	required, err := strconv.ParseBool(c.args[0])
+	if err != nil {
+		return shim.Error("Expecting true or false")
+	}
+
+	_, err = c.checkRole()
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	err = c.stub.PutState(orderSignatureKey, []byte(strconv.FormatBool(required)))
+	if err != nil {
+		myLogger.Errorf("setOrderSignatureRequired error1:%s", err)
+		return shim.Error(err.Error())
+	}
+
+	myLogger.Debug("Set Order Signature Required...done")
+	return shim.Success(nil)
+}
@@ -0,0 +1,177 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/asn1"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"math/big"
+	"strings"
+	"testing"
+)
+
+// orderSigner ECDSA key of an account signing its orders
+type orderSigner struct {
+	t   *testing.T
+	key *ecdsa.PrivateKey
+}
+
+func newOrderSigner(t *testing.T) *orderSigner {
+	k, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &orderSigner{t: t, key: k}
+}
+
+func (s *orderSigner) publicKey() string {
+	der, err := x509.MarshalPKIXPublicKey(&s.key.PublicKey)
+	if err != nil {
+		s.t.Fatal(err)
+	}
+	return string(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}))
+}
+
+// sign set the terms and the low-S signature of the order
+func (s *orderSigner) sign(order *Order, terms *OrderTerms) {
+	msg, err := json.Marshal(terms)
+	if err != nil {
+		s.t.Fatal(err)
+	}
+	digest := sha256.Sum256(msg)
+	r, sv, err := ecdsa.Sign(rand.Reader, s.key, digest[:])
+	if err != nil {
+		s.t.Fatal(err)
+	}
+	halfOrder := new(big.Int).Rsh(elliptic.P256().Params().N, 1)
+	if sv.Cmp(halfOrder) > 0 {
+		sv.Sub(elliptic.P256().Params().N, sv)
+	}
+	sig, err := asn1.Marshal(struct{ R, S *big.Int }{r, sv})
+	if err != nil {
+		s.t.Fatal(err)
+	}
+	order.Terms = terms
+	order.Signature = base64.StdEncoding.EncodeToString(sig)
+}
+
+func TestSignedOrderFills(t *testing.T) {
+	e := newTestEnv(t)
+	e.openAccount("alice")
+	e.openAccount("bob")
+	e.fund("alice", "EUR", 1000)
+	e.fund("bob", "BTC", 1000)
+	e.lockOrder("alice", "EUR", "r1", 100)
+	for _, id := range []string{"s1", "s2", "s3"} {
+		e.lockOrder("bob", "BTC", id, 50)
+	}
+
+	alice := newOrderSigner(t)
+	e.mustInvoke("alice", "setAccountKey", "alice", alice.publicKey())
+	terms := &OrderTerms{RawUUID: "r1", Account: "alice", SrcCurrency: "EUR", SrcCount: 100, DesCurrency: "BTC", DesCount: 50}
+
+	fill := func(uuid string, cost, count int64, suffix string) []map[string]Order {
+		m := match("alice", "bob", "EUR", "BTC", cost, count, suffix)
+		buy := Order{UUID: uuid, RawUUID: "r1", Account: "alice", SrcCurrency: "EUR", SrcCount: 100,
+			DesCurrency: "BTC", DesCount: count, FinalCost: cost, IsBuyAll: terms.IsBuyAll}
+		alice.sign(&buy, terms)
+		m[0]["buyOrder"] = buy
+		return m
+	}
+
+	if batch := e.exchange(fill("r1-1", 60, 30, "1")); len(batch.Success) != 1 {
+		t.Fatalf("first fill: %+v", batch)
+	}
+	// 60 + 60 is above the signed 100
+	batch := e.exchange(fill("r1-2", 60, 30, "2"))
+	if len(batch.Fail) != 1 || !strings.Contains(batch.Fail[0].Info, "signed amount") {
+		t.Fatalf("overfill: %+v", batch)
+	}
+	if batch = e.exchange(fill("r1-3", 40, 20, "3")); len(batch.Success) != 1 {
+		t.Fatalf("last fill: %+v", batch)
+	}
+}
+
+func TestSignedBuyAllCount(t *testing.T) {
+	e := newTestEnv(t)
+	e.openAccount("alice")
+	e.openAccount("bob")
+	e.fund("alice", "EUR", 1000)
+	e.fund("bob", "BTC", 1000)
+	e.lockOrder("alice", "EUR", "r1", 100)
+	e.lockOrder("bob", "BTC", "s1", 40)
+	e.lockOrder("bob", "BTC", "s2", 40)
+
+	alice := newOrderSigner(t)
+	e.mustInvoke("alice", "setAccountKey", "alice", alice.publicKey())
+	terms := &OrderTerms{RawUUID: "r1", Account: "alice", SrcCurrency: "EUR", SrcCount: 100, DesCurrency: "BTC", DesCount: 50, IsBuyAll: true}
+
+	fill := func(uuid string, cost, count int64, suffix string) []map[string]Order {
+		m := match("alice", "bob", "EUR", "BTC", cost, count, suffix)
+		buy := Order{UUID: uuid, RawUUID: "r1", Account: "alice", SrcCurrency: "EUR", SrcCount: 100,
+			DesCurrency: "BTC", DesCount: count, FinalCost: cost, IsBuyAll: true}
+		alice.sign(&buy, terms)
+		m[0]["buyOrder"] = buy
+		return m
+	}
+
+	if batch := e.exchange(fill("r1-1", 40, 40, "1")); len(batch.Success) != 1 {
+		t.Fatalf("first fill: %+v", batch)
+	}
+	// 40 + 40 is above the signed 50 to buy
+	batch := e.exchange(fill("r1", 40, 40, "2"))
+	if len(batch.Fail) != 1 || !strings.Contains(batch.Fail[0].Info, "signed count") {
+		t.Fatalf("overfill: %+v", batch)
+	}
+}
+
+func TestSignedOrderNonce(t *testing.T) {
+	e := newTestEnv(t)
+	e.openAccount("alice")
+	e.openAccount("bob")
+	e.fund("alice", "EUR", 1000)
+	e.fund("bob", "BTC", 1000)
+	e.lockOrder("bob", "BTC", "s1", 50)
+	e.lockOrder("bob", "BTC", "s2", 50)
+
+	lock := func(orderID string, nonce int64) *BatchResult {
+		b, err := json.Marshal([]map[string]interface{}{{"owner": "alice", "currency": "EUR", "orderId": orderID, "count": 100, "nonce": nonce}})
+		if err != nil {
+			t.Fatal(err)
+		}
+		e.mustInvoke("root", "lock", string(b), "true", "test")
+		return e.batch("chaincode_lock")
+	}
+	if batch := lock("r1", 1); len(batch.Success) != 1 {
+		t.Fatalf("lock with a nonce: %+v", batch)
+	}
+	// once used the nonce is mandatory
+	if batch := lock("r2", 0); len(batch.Fail) != 1 || !strings.Contains(batch.Fail[0].Info, "required") {
+		t.Fatalf("lock without a nonce: %+v", batch)
+	}
+
+	alice := newOrderSigner(t)
+	e.mustInvoke("alice", "setAccountKey", "alice", alice.publicKey())
+	fill := func(nonce int64, suffix string) []map[string]Order {
+		m := match("alice", "bob", "EUR", "BTC", 100, 50, suffix)
+		buy := Order{UUID: "r1-" + suffix, RawUUID: "r1", Account: "alice", SrcCurrency: "EUR", SrcCount: 100,
+			DesCurrency: "BTC", DesCount: 50, FinalCost: 100}
+		alice.sign(&buy, &OrderTerms{RawUUID: "r1", Account: "alice", SrcCurrency: "EUR", SrcCount: 100,
+			DesCurrency: "BTC", DesCount: 50, Nonce: nonce})
+		m[0]["buyOrder"] = buy
+		return m
+	}
+
+	batch := e.exchange(fill(2, "1"))
+	if len(batch.Fail) != 1 || !strings.Contains(batch.Fail[0].Info, "nonce") {
+		t.Fatalf("terms signed with another nonce: %+v", batch)
+	}
+	if batch = e.exchange(fill(1, "2")); len(batch.Success) != 1 {
+		t.Fatalf("terms signed with the lock nonce: %+v", batch)
+	}
+}
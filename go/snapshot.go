@@ -0,0 +1,444 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	pb "github.com/hyperledger/fabric/protos/peer"
+)
+
+// SnapshotRecords entity of the records stored under simple keys (uuid, OracleConfig)
+const SnapshotRecords = "records"
+
+const maxSnapshotPageSize = 500
+
+// snapshotIndexes composite keys exported by exportState, records and indexes keep their names on import
+var snapshotIndexes = []string{
+	"Account~identity~uuid",
+	"Account~name~uuid",
+	"Asset~currency~owner~uuid",
+	"Asset~owner~currency~uuid",
+	"Asset~owner~uuid",
+	"AssignLog~from~uuid",
+	"AssignLog~to~uuid",
+	"BurnLog~currency~uuid",
+	"Candle~base~quote~interval~start",
+	"CircuitBreaker~base~quote",
+	"Commitment~currency~uuid",
+	"Commitment~uuid~owner",
+	"Currency~name~uuid",
+	"Currency~owner~uuid",
+	"Currency~uuid",
+	"Escrow~approver~uuid",
+	"Escrow~beneficiary~uuid",
+	"Escrow~owner~uuid",
+	"FiatLog~bankRef~uuid",
+	"FiatLog~currency~uuid",
+	"FiatLog~owner~uuid",
+	"FreezeLog~owner~uuid",
+	"FreezeLog~to~uuid",
+	"HTLC~owner~uuid",
+	"HTLC~recipient~uuid",
+	"Limit~scope~subject~currency",
+	"LockLog~owner~curr~order~islock~uuid",
+	"Order~owner~src~des~raw~uuid",
+	"Processed~kind~id",
+	"Order~uuid",
+	"Proposal~currency~uuid",
+	"ReleaseLog~owner~uuid",
+	"Role~role~identity",
+	"SealKey~owner~currency~raw",
+	"SealedFills~mac",
+	"Statement~owner~currency~time~tx~seq",
+	"Ticker~base~quote",
+	"TransferLog~from~uuid",
+	"TransferLog~to~uuid",
+	"Vesting~currency~uuid",
+	"Vesting~owner~uuid",
+	"Volume~account~currency~day",
+	"Withdrawal~owner~uuid",
+}
+
+// SnapshotEntry one key of the state, Key is set for records and Attributes for composite keys
+type SnapshotEntry struct {
+	Key        string   `json:"key,omitempty"`
+	Attributes []string `json:"attributes,omitempty"`
+	Value      []byte   `json:"value"`
+}
+
+// SnapshotPage page of an entity, Hash chains the entries from PrevHash: hash = sha256(hash || json(entry)).
+// The chain of an entity starts at sha256(entity) and Bookmark is empty on the last page.
+type SnapshotPage struct {
+	Entity   string           `json:"entity"`
+	PrevHash string           `json:"prevHash"`
+	Hash     string           `json:"hash"`
+	Entries  []*SnapshotEntry `json:"entries"`
+	Bookmark string           `json:"bookmark"`
+}
+
+// snapshotBookmark position of the export, the last exported key and the chain hash up to it
+type snapshotBookmark struct {
+	Key  string `json:"key"`
+	Hash string `json:"hash"`
+}
+
+func snapshotSeed(entity string) string {
+	h := sha256.Sum256([]byte(entity))
+	return hex.EncodeToString(h[:])
+}
+
+// chainSnapshot hash the entries after prevHash
+func chainSnapshot(prevHash string, entries []*SnapshotEntry) (string, error) {
+	hash, err := hex.DecodeString(prevHash)
+	if err != nil {
+		return "", fmt.Errorf("Invalid hash [%s]", prevHash)
+	}
+
+	for _, v := range entries {
+		b, err := json.Marshal(v)
+		if err != nil {
+			return "", err
+		}
+		h := sha256.New()
+		h.Write(hash)
+		h.Write(b)
+		hash = h.Sum(nil)
+	}
+	return hex.EncodeToString(hash), nil
+}
+
+// isCompositeKey composite keys separate their parts with U+0000, which simple keys never contain
+func isCompositeKey(key string) bool {
+	return strings.ContainsRune(key, 0)
+}
+
+func isSnapshotEntity(entity string) bool {
+	return entity == SnapshotRecords || containsString(snapshotIndexes, entity)
+}
+
+// snapshotIterator iterate the keys of the entity after the key after, from the first key when it is empty.
+// The records are the simple keys.
+func (c *ExchangeChaincode) snapshotIterator(entity, after string) (shim.StateQueryIteratorInterface, error) {
+	start, end := "", ""
+	if entity != SnapshotRecords {
+		prefix, err := c.stub.CreateCompositeKey(entity, nil)
+		if err != nil {
+			return nil, err
+		}
+		start, end = prefix, prefix+string(utf8.MaxRune)
+	}
+	if after != "" {
+		// the first key above after
+		start = after + "\x00"
+		if end == "" {
+			end = string(utf8.MaxRune)
+		}
+	}
+	return c.stub.GetStateByRange(start, end)
+}
+
+// exportState page of the keys of an entity in key order, requires the auditor role. The pages are read at
+// query time, pause the exchange while exporting to get a point-in-time backup.
+// args: entity (records or a composite index name), bookmark (empty for the first page), page size
+func (c *ExchangeChaincode) exportState() pb.Response {
+	myLogger.Debug("exportState...")
+
+	if len(c.args) != 3 {
+		return shim.Error("Incorrect number of arguments. Expecting 3")
+	}
+
+	entity := c.args[0]
+	if !isSnapshotEntity(entity) {
+		return shim.Error(fmt.Sprintf("Unknown entity [%s]", entity))
+	}
+	pageSize, err := strconv.Atoi(c.args[2])
+	if err != nil || pageSize <= 0 || pageSize > maxSnapshotPageSize {
+		return shim.Error(fmt.Sprintf("The page size must be between 1 and %d", maxSnapshotPageSize))
+	}
+
+	// the whole state, every account and balance
+	_, err = c.checkRole(RoleAuditor)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	mark := snapshotBookmark{Hash: snapshotSeed(entity)}
+	if c.args[1] != "" {
+		b, err := base64.StdEncoding.DecodeString(c.args[1])
+		if err == nil {
+			err = json.Unmarshal(b, &mark)
+		}
+		if err != nil {
+			return shim.Error("Invalid bookmark")
+		}
+	}
+
+	resultsIterator, err := c.snapshotIterator(entity, mark.Key)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	defer resultsIterator.Close()
+
+	page := &SnapshotPage{Entity: entity, PrevHash: mark.Hash, Entries: []*SnapshotEntry{}}
+	lastKey := ""
+	for resultsIterator.HasNext() {
+		key, value, err := resultsIterator.Next()
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+		entry := &SnapshotEntry{Value: value}
+		if entity == SnapshotRecords {
+			// composite keys are exported with their index
+			if isCompositeKey(key) {
+				continue
+			}
+			entry.Key = key
+		} else {
+			_, entry.Attributes, err = c.stub.SplitCompositeKey(key)
+			if err != nil {
+				return shim.Error(err.Error())
+			}
+		}
+
+		if len(page.Entries) == pageSize {
+			// there is a next page
+			page.Bookmark = lastKey
+			break
+		}
+		page.Entries = append(page.Entries, entry)
+		lastKey = key
+	}
+
+	page.Hash, err = chainSnapshot(page.PrevHash, page.Entries)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if page.Bookmark != "" {
+		b, err := json.Marshal(&snapshotBookmark{Key: page.Bookmark, Hash: page.Hash})
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+		page.Bookmark = base64.StdEncoding.EncodeToString(b)
+	}
+
+	payload, err := json.Marshal(page)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	return shim.Success(payload)
+}
+
+// SnapshotImport import of an entity started by beginImport, Hash chains the pages imported so far and
+// the import is Done when the last page reaches FinalHash
+type SnapshotImport struct {
+	Entity    string `json:"entity"`
+	FinalHash string `json:"finalHash"`
+	Hash      string `json:"hash"`
+	Done      bool   `json:"done"`
+	Operator  string `json:"operator"`
+	BeginTime int64  `json:"beginTime"`
+}
+
+func (c *ExchangeChaincode) putSnapshotImport(imp *SnapshotImport) error {
+	key, err := c.stub.CreateCompositeKey("Import~entity", []string{imp.Entity})
+	if err != nil {
+		return err
+	}
+
+	r, err := json.Marshal(imp)
+	if err != nil {
+		return err
+	}
+	return c.stub.PutState(key, r)
+}
+
+func (c *ExchangeChaincode) getSnapshotImport(entity string) (*SnapshotImport, error) {
+	key, err := c.stub.CreateCompositeKey("Import~entity", []string{entity})
+	if err != nil {
+		return nil, err
+	}
+
+	impByte, err := c.stub.GetState(key)
+	if err != nil {
+		return nil, err
+	}
+	if impByte == nil {
+		return nil, nil
+	}
+
+	imp := new(SnapshotImport)
+	err = json.Unmarshal(impByte, imp)
+	if err != nil {
+		return nil, err
+	}
+	return imp, nil
+}
+
+// clearSnapshotEntity delete the keys of the entity before its pages are imported, the admin role of
+// the caller is kept so the import of the roles can't lock it out
+func (c *ExchangeChaincode) clearSnapshotEntity(entity, caller string) error {
+	resultsIterator, err := c.snapshotIterator(entity, "")
+	if err != nil {
+		return err
+	}
+	defer resultsIterator.Close()
+
+	keep, err := c.stub.CreateCompositeKey("Role~role~identity", []string{RoleAdmin, caller})
+	if err != nil {
+		return err
+	}
+
+	var keys []string
+	for resultsIterator.HasNext() {
+		key, _, err := resultsIterator.Next()
+		if err != nil {
+			return err
+		}
+		if entity == SnapshotRecords && isCompositeKey(key) {
+			continue
+		}
+		if key == keep {
+			continue
+		}
+		keys = append(keys, key)
+	}
+
+	for _, key := range keys {
+		err = c.stub.DelState(key)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// beginImport start the import of an entity, requires the admin role. The keys of the entity are replaced
+// by the pages passed to importState, which must chain up to the final hash of the export. Beginning again
+// restarts the import.
+// args: entity, final hash (hash of the last page of exportState)
+func (c *ExchangeChaincode) beginImport() pb.Response {
+	myLogger.Debug("Begin Import...")
+
+	if len(c.args) != 2 {
+		return shim.Error("Incorrect number of arguments. Expecting 2")
+	}
+
+	caller, err := c.checkRole(RoleAdmin)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	entity := c.args[0]
+	if !isSnapshotEntity(entity) {
+		return shim.Error(fmt.Sprintf("Unknown entity [%s]", entity))
+	}
+	finalHash, err := hex.DecodeString(c.args[1])
+	if err != nil || len(finalHash) != sha256.Size {
+		return shim.Error("The final hash must be a hex sha256")
+	}
+
+	err = c.clearSnapshotEntity(entity, caller)
+	if err != nil {
+		myLogger.Errorf("beginImport error1:%s", err)
+		return shim.Error(err.Error())
+	}
+
+	err = c.putSnapshotImport(&SnapshotImport{
+		Entity:    entity,
+		FinalHash: hex.EncodeToString(finalHash),
+		Hash:      snapshotSeed(entity),
+		Operator:  caller,
+		BeginTime: c.txTime(),
+	})
+	if err != nil {
+		myLogger.Errorf("beginImport error2:%s", err)
+		return shim.Error(err.Error())
+	}
+
+	myLogger.Debug("Begin Import...done")
+	return shim.Success(nil)
+}
+
+// importState write a page of exportState, requires the admin role. The pages of an entity must be
+// imported in order after beginImport: the page must continue the chain of the last imported one, its hash
+// must match and the last page must end on the final hash.
+// args: page (json of exportState)
+func (c *ExchangeChaincode) importState() pb.Response {
+	myLogger.Debug("Import State...")
+
+	if len(c.args) != 1 {
+		return shim.Error("Incorrect number of arguments. Expecting 1")
+	}
+
+	_, err := c.checkRole(RoleAdmin)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	page := new(SnapshotPage)
+	err = json.Unmarshal([]byte(c.args[0]), page)
+	if err != nil {
+		return shim.Error(fmt.Sprintf("Failed unmarshalling page: [%s]", err))
+	}
+	if !isSnapshotEntity(page.Entity) {
+		return shim.Error(fmt.Sprintf("Unknown entity [%s]", page.Entity))
+	}
+
+	imp, err := c.getSnapshotImport(page.Entity)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if imp == nil || imp.Done {
+		return shim.Error(fmt.Sprintf("The import of [%s] is not begun", page.Entity))
+	}
+	if page.PrevHash != imp.Hash {
+		return shim.Error(fmt.Sprintf("The page does not continue the imported chain [%s]", imp.Hash))
+	}
+
+	hash, err := chainSnapshot(page.PrevHash, page.Entries)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if hash != page.Hash {
+		return shim.Error("The hash of the page does not match its entries")
+	}
+	if page.Bookmark == "" && hash != imp.FinalHash {
+		return shim.Error(fmt.Sprintf("The last page does not end on the final hash [%s]", imp.FinalHash))
+	}
+
+	for _, v := range page.Entries {
+		key := v.Key
+		if page.Entity != SnapshotRecords {
+			key, err = c.stub.CreateCompositeKey(page.Entity, v.Attributes)
+			if err != nil {
+				return shim.Error(err.Error())
+			}
+		}
+		if key == "" || (page.Entity == SnapshotRecords && isCompositeKey(key)) {
+			return shim.Error("Invalid key in the page")
+		}
+
+		err = c.stub.PutState(key, v.Value)
+		if err != nil {
+			myLogger.Errorf("importState error2:%s", err)
+			return shim.Error(err.Error())
+		}
+	}
+
+	imp.Hash = hash
+	imp.Done = page.Bookmark == ""
+	err = c.putSnapshotImport(imp)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	myLogger.Debug("Import State...done")
+	return shim.Success([]byte(hash))
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+// exportPages the pages of the entity exported from e
+func exportPages(e *testEnv, entity string) ([]string, string) {
+	var pages []string
+	var hash string
+	bookmark := ""
+	for {
+		b := e.mustInvoke("root", "exportState", entity, bookmark, "1")
+		page := new(SnapshotPage)
+		err := json.Unmarshal(b, page)
+		if err != nil {
+			e.t.Fatal(err)
+		}
+		pages = append(pages, string(b))
+		hash = page.Hash
+		bookmark = page.Bookmark
+		if bookmark == "" {
+			return pages, hash
+		}
+	}
+}
+
+func TestImportState(t *testing.T) {
+	src := newTestEnv(t)
+	src.mustInvoke("root", "grantRole", RoleCustodian, "Org1MSP/bank")
+	pages, hash := exportPages(src, "Role~role~identity")
+	if len(pages) < 2 {
+		t.Fatalf("pages: %d", len(pages))
+	}
+
+	e := newTestEnv(t)
+	e.mustInvoke("root", "grantRole", RoleAdmin, "Org1MSP/ops")
+
+	// pages are imported after the begin, in order, up to the final hash
+	if msg := e.mustFail("ops", "importState", pages[0]); !strings.Contains(msg, "not begun") {
+		t.Fatalf("import before the begin: %s", msg)
+	}
+	e.mustInvoke("ops", "beginImport", "Role~role~identity", snapshotSeed("other"))
+	for _, page := range pages[:len(pages)-1] {
+		e.mustInvoke("ops", "importState", page)
+	}
+	if msg := e.mustFail("ops", "importState", pages[len(pages)-1]); !strings.Contains(msg, "final hash") {
+		t.Fatalf("last page of another snapshot: %s", msg)
+	}
+
+	// beginning again restarts the chain
+	e.mustInvoke("ops", "beginImport", "Role~role~identity", hash)
+	e.mustFail("ops", "importState", pages[1])
+	for _, page := range pages {
+		e.mustInvoke("ops", "importState", page)
+	}
+	e.mustFail("ops", "importState", pages[0])
+
+	// the importing admin keeps its role
+	c := &ExchangeChaincode{stub: e.stub}
+	for _, identity := range []string{"Org1MSP/ops", "Org1MSP/root"} {
+		ok, err := c.hasRole(RoleAdmin, identity)
+		if err != nil || !ok {
+			t.Fatalf("admin %s after the import: %v %v", identity, ok, err)
+		}
+	}
+	if ok, err := c.hasRole(RoleCustodian, "Org1MSP/bank"); err != nil || !ok {
+		t.Fatalf("imported custodian: %v %v", ok, err)
+	}
+}
+
+func TestExportStatePages(t *testing.T) {
+	e := newTestEnv(t)
+	e.openAccount("alice")
+	e.fund("alice", "EUR", 100)
+	e.mustInvoke("root", "grantRole", RoleAuditor, "Org1MSP/audit")
+
+	e.mustFail("alice", "exportState", SnapshotRecords, "", "10")
+	e.mustInvoke("audit", "exportState", SnapshotRecords, "", "10")
+
+	// the pages resume after the bookmark, each record once and in the order of a single page
+	for _, entity := range []string{SnapshotRecords, "Asset~owner~uuid"} {
+		all := new(SnapshotPage)
+		err := json.Unmarshal(e.mustInvoke("root", "exportState", entity, "", itoa(maxSnapshotPageSize)), all)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(all.Entries) == 0 || all.Bookmark != "" {
+			t.Fatalf("%s in one page: %+v", entity, all)
+		}
+
+		pages, hash := exportPages(e, entity)
+		if len(pages) != len(all.Entries) || hash != all.Hash {
+			t.Fatalf("%s: %d pages ending at %s, expecting %d ending at %s", entity, len(pages), hash, len(all.Entries), all.Hash)
+		}
+	}
+}
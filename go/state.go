@@ -20,6 +20,15 @@ func (c *ExchangeChaincode) putCompositeValue(indexName string, compositeValue [
 	return nil
 }
 
+func (c *ExchangeChaincode) delCompositeValue(indexName string, compositeValue []string) error {
+	indexKey, err := c.stub.CreateCompositeKey(indexName, compositeValue)
+	if err != nil {
+		return err
+	}
+
+	return c.stub.DelState(indexKey)
+}
+
 func (c *ExchangeChaincode) getCompositeValue(indexName string, compositeValue []string, keyIndex int) ([][]byte, error) {
 	var bb [][]byte
 
@@ -53,17 +62,23 @@ func (c *ExchangeChaincode) getCompositeValue(indexName string, compositeValue [
 
 // Asset Asset
 type Asset struct {
-	UUID      string `json:"uuid"`
-	Owner     string `json:"owner"`
-	Currency  string `json:"currency"`
-	Count     int64  `json:"count"`
-	LockCount int64  `json:"lockCount"`
+	DocType     string `json:"docType,omitempty"`
+	UUID        string `json:"uuid"`
+	Owner       string `json:"owner"`
+	Currency    string `json:"currency"`
+	Count       int64  `json:"count"`
+	LockCount   int64  `json:"lockCount"`
+	FreezeCount int64  `json:"freezeCount"`
+
+	// requested for withdrawal and not settled by the custodian, apart from the order locks
+	WithdrawCount int64 `json:"withdrawCount"`
 }
 
 func (c *ExchangeChaincode) putAsset(asset *Asset) error {
 	if asset.UUID == "" {
 		asset.UUID = GenerateUUID()
 	}
+	asset.DocType = DocAsset
 	r, err := json.Marshal(asset)
 	if err != nil {
 		return err
@@ -84,6 +99,11 @@ func (c *ExchangeChaincode) putAsset(asset *Asset) error {
 		return err
 	}
 
+	err = c.putCompositeValue("Asset~currency~owner~uuid", []string{asset.Currency, asset.Owner, asset.UUID})
+	if err != nil {
+		return err
+	}
+
 	return nil
 }
 
@@ -92,8 +112,11 @@ func (c *ExchangeChaincode) getAsset(key string) (*Asset, error) {
 	if err != nil {
 		return nil, err
 	}
+	if assetByte == nil {
+		return nil, nil
+	}
 
-	var asset *Asset
+	asset := new(Asset)
 	err = json.Unmarshal(assetByte, asset)
 	if err != nil {
 		return nil, err
@@ -107,8 +130,11 @@ func (c *ExchangeChaincode) getOwnerOneAsset(owner, currency string) (*Asset, er
 	if err != nil {
 		return nil, err
 	}
+	if len(bb) == 0 {
+		return nil, nil
+	}
 
-	var asset *Asset
+	asset := new(Asset)
 	err = json.Unmarshal(bb[0], asset)
 	if err != nil {
 		return nil, err
@@ -125,7 +151,7 @@ func (c *ExchangeChaincode) getOwnerAllAsset(owner string) ([]*Asset, error) {
 
 	var assets []*Asset
 	for _, v := range bb {
-		var asset *Asset
+		asset := new(Asset)
 		err = json.Unmarshal(v, asset)
 		if err != nil {
 			return nil, err
@@ -138,12 +164,32 @@ func (c *ExchangeChaincode) getOwnerAllAsset(owner string) ([]*Asset, error) {
 
 // Currency Currency
 type Currency struct {
+	DocType    string `json:"docType,omitempty"`
 	UUID       string `json:"uuid"`
 	Name       string `json:"name"`
 	Count      int64  `json:"count"`
 	LeftCount  int64  `json:"leftCount"`
 	Creator    string `json:"creator"`
 	CreateTime int64  `json:"createTime"`
+	Base       bool   `json:"base"` // fiat-backed, can't be released
+
+	DisplayName    string `json:"displayName"`
+	Symbol         string `json:"symbol"`
+	Decimals       int    `json:"decimals"`
+	DescriptionURI string `json:"descriptionURI"`
+	SupplyCap      int64  `json:"supplyCap"`    // 0 means no cap
+	MintDisabled   bool   `json:"mintDisabled"` // currencies created before the flag stay mintable
+	Burnable       bool   `json:"burnable"`
+
+	PendingCreator  string   `json:"pendingCreator"`
+	Issuers         []string `json:"issuers"`
+	IssuerThreshold int      `json:"issuerThreshold"` // 0 means the creator issues alone
+
+	HolderThreshold int64 `json:"holderThreshold"` // holders with a balance at or below it are not listed
+
+	IssuancePaused  bool `json:"issuancePaused"`
+	TransfersPaused bool `json:"transfersPaused"`
+	TradingHalted   bool `json:"tradingHalted"`
 }
 
 // putCurrency putCurrency
@@ -151,6 +197,7 @@ func (c *ExchangeChaincode) putCurrency(currency *Currency) error {
 	if currency.UUID == "" {
 		currency.UUID = GenerateUUID()
 	}
+	currency.DocType = DocCurrency
 	r, err := json.Marshal(currency)
 	if err != nil {
 		return err
@@ -183,8 +230,11 @@ func (c *ExchangeChaincode) getCurrency(key string) (*Currency, error) {
 	if err != nil {
 		return nil, err
 	}
+	if currByte == nil {
+		return nil, nil
+	}
 
-	var curr *Currency
+	curr := new(Currency)
 	err = json.Unmarshal(currByte, curr)
 	if err != nil {
 		return nil, err
@@ -198,7 +248,11 @@ func (c *ExchangeChaincode) getCurrencyByName(name string) (*Currency, error) {
 	if err != nil {
 		return nil, err
 	}
-	var curr *Currency
+	if len(bb) == 0 {
+		return nil, nil
+	}
+
+	curr := new(Currency)
 	err = json.Unmarshal(bb[0], curr)
 	if err != nil {
 		return nil, err
@@ -215,7 +269,7 @@ func (c *ExchangeChaincode) getAllCurrency() ([]*Currency, error) {
 
 	var currs []*Currency
 	for _, v := range bb {
-		var curr *Currency
+		curr := new(Currency)
 		err = json.Unmarshal(v, curr)
 		if err != nil {
 			return nil, err
@@ -236,7 +290,7 @@ func (c *ExchangeChaincode) getMyCurrency(owner string) ([]*Currency, error) {
 
 	var currs []*Currency
 	for _, v := range bb {
-		var curr *Currency
+		curr := new(Currency)
 		err = json.Unmarshal(v, curr)
 		if err != nil {
 			return nil, err
@@ -249,18 +303,57 @@ func (c *ExchangeChaincode) getMyCurrency(owner string) ([]*Currency, error) {
 }
 
 type ReleaseLog struct {
+	DocType     string `json:"docType,omitempty"`
 	UUID        string `json:"uuid"`
 	Currency    string `json:"currency"`
-	Releaser    string `json:"releaser`
+	Releaser    string `json:"Releaser"`
 	Count       int64  `json:"cont"`
 	ReleaseTime int64  `json:"releaseTime"`
 }
 
+// BurnLog burn of unassigned currency, Burner is the currency creator like ReleaseLog.Releaser
+type BurnLog struct {
+	DocType  string `json:"docType,omitempty"`
+	UUID     string `json:"uuid"`
+	Currency string `json:"currency"`
+	Burner   string `json:"burner"`
+	Count    int64  `json:"count"`
+	BurnTime int64  `json:"burnTime"`
+}
+
+func (c *ExchangeChaincode) putBurnLog(log *BurnLog) error {
+	if log.UUID == "" {
+		log.UUID = GenerateUUID()
+	}
+	log.DocType = DocBurnLog
+	r, err := json.Marshal(log)
+	if err != nil {
+		return err
+	}
+
+	err = c.stub.PutState(log.UUID, r)
+	if err != nil {
+		return err
+	}
+
+	err = c.putCompositeValue("BurnLog~currency~uuid", []string{log.Currency, log.UUID})
+	if err != nil {
+		return err
+	}
+
+	return c.putStatement(log.Burner, log.Currency, &StatementEntry{
+		Kind:   StatementBurn,
+		Ref:    log.UUID,
+		Supply: -log.Count,
+	})
+}
+
 // saveReleaseLog
 func (c *ExchangeChaincode) putReleaseLog(log *ReleaseLog) error {
 	if log.UUID == "" {
 		log.UUID = GenerateUUID()
 	}
+	log.DocType = DocReleaseLog
 	r, err := json.Marshal(log)
 	if err != nil {
 		return err
@@ -275,7 +368,12 @@ func (c *ExchangeChaincode) putReleaseLog(log *ReleaseLog) error {
 	if err != nil {
 		return err
 	}
-	return nil
+
+	return c.putStatement(log.Releaser, log.Currency, &StatementEntry{
+		Kind:   StatementRelease,
+		Ref:    log.UUID,
+		Supply: log.Count,
+	})
 }
 
 func (c *ExchangeChaincode) getReleaseLog(key string) (*ReleaseLog, error) {
@@ -283,8 +381,11 @@ func (c *ExchangeChaincode) getReleaseLog(key string) (*ReleaseLog, error) {
 	if err != nil {
 		return nil, err
 	}
+	if logByte == nil {
+		return nil, nil
+	}
 
-	var log *ReleaseLog
+	log := new(ReleaseLog)
 	err = json.Unmarshal(logByte, log)
 	if err != nil {
 		return nil, err
@@ -300,7 +401,7 @@ func (c *ExchangeChaincode) getMyReleaseLog(owner string) ([]*ReleaseLog, error)
 
 	var logs []*ReleaseLog
 	for _, v := range bb {
-		var log *ReleaseLog
+		log := new(ReleaseLog)
 		err = json.Unmarshal(v, log)
 		if err != nil {
 			return nil, err
@@ -313,19 +414,27 @@ func (c *ExchangeChaincode) getMyReleaseLog(owner string) ([]*ReleaseLog, error)
 }
 
 type AssignLog struct {
+	DocType    string `json:"docType,omitempty"`
 	UUID       string `json:"uuid"`
 	Currency   string `json:"currency"`
 	FromUser   string `json:"fromUser"`
 	ToUser     string `json:"toUser"`
 	Count      int64  `json:"count"`
 	AssignTime int64  `json:"assignTime"`
+
+	VestingUUID string `json:"vestingUUID,omitempty"`
+	ReversalOf  string `json:"reversalOf,omitempty"` // the reversed assign log, the count is negative
+	ReversedBy  string `json:"reversedBy,omitempty"`
+	Reason      string `json:"reason,omitempty"`
 }
 
 // saveAssignLog
 func (c *ExchangeChaincode) putAssignLog(log *AssignLog) error {
-	if log.UUID == "" {
+	isNew := log.UUID == ""
+	if isNew {
 		log.UUID = GenerateUUID()
 	}
+	log.DocType = DocAssignLog
 	r, err := json.Marshal(log)
 	if err != nil {
 		return err
@@ -345,9 +454,55 @@ func (c *ExchangeChaincode) putAssignLog(log *AssignLog) error {
 	if err != nil {
 		return err
 	}
+
+	if isNew {
+		err = c.putStatement(log.ToUser, log.Currency, &StatementEntry{
+			Kind:         StatementAssign,
+			Ref:          log.UUID,
+			Counterparty: log.FromUser,
+			Available:    log.Count,
+		})
+		if err != nil {
+			return err
+		}
+	}
 	return nil
 }
 
+// getAssignLog get an assign log, nil if the key holds another record.
+// Logs written before docType are recognized by the AssignLog~to~uuid index.
+func (c *ExchangeChaincode) getAssignLog(key string) (*AssignLog, error) {
+	logByte, err := c.stub.GetState(key)
+	if err != nil {
+		return nil, err
+	}
+	if logByte == nil {
+		return nil, nil
+	}
+
+	log := new(AssignLog)
+	err = json.Unmarshal(logByte, log)
+	if err != nil {
+		return nil, nil
+	}
+	if log.UUID != key || (log.DocType != "" && log.DocType != DocAssignLog) {
+		return nil, nil
+	}
+
+	indexKey, err := c.stub.CreateCompositeKey("AssignLog~to~uuid", []string{log.ToUser, log.UUID})
+	if err != nil {
+		return nil, err
+	}
+	indexed, err := c.stub.GetState(indexKey)
+	if err != nil {
+		return nil, err
+	}
+	if indexed == nil {
+		return nil, nil
+	}
+	return log, nil
+}
+
 func (c *ExchangeChaincode) getFromAssignLog(owner string) ([]*AssignLog, error) {
 	bb, err := c.getCompositeValue("AssignLog~from~uuid", []string{owner}, 1)
 	if err != nil {
@@ -356,7 +511,7 @@ func (c *ExchangeChaincode) getFromAssignLog(owner string) ([]*AssignLog, error)
 
 	var logs []*AssignLog
 	for _, v := range bb {
-		var log *AssignLog
+		log := new(AssignLog)
 		err = json.Unmarshal(v, log)
 		if err != nil {
 			return nil, err
@@ -375,7 +530,7 @@ func (c *ExchangeChaincode) getToAssignLog(owner string) ([]*AssignLog, error) {
 
 	var logs []*AssignLog
 	for _, v := range bb {
-		var log *AssignLog
+		log := new(AssignLog)
 		err = json.Unmarshal(v, log)
 		if err != nil {
 			return nil, err
@@ -386,7 +541,90 @@ func (c *ExchangeChaincode) getToAssignLog(owner string) ([]*AssignLog, error) {
 	return logs, nil
 }
 
+type TransferLog struct {
+	DocType      string `json:"docType,omitempty"`
+	UUID         string `json:"uuid"`
+	Currency     string `json:"currency"`
+	FromUser     string `json:"fromUser"`
+	ToUser       string `json:"toUser"`
+	Count        int64  `json:"count"`
+	Memo         string `json:"memo"`
+	TransferTime int64  `json:"transferTime"`
+}
+
+// putTransferLog
+func (c *ExchangeChaincode) putTransferLog(log *TransferLog) error {
+	if log.UUID == "" {
+		log.UUID = GenerateUUID()
+	}
+	log.DocType = DocTransferLog
+	r, err := json.Marshal(log)
+	if err != nil {
+		return err
+	}
+
+	err = c.stub.PutState(log.UUID, r)
+	if err != nil {
+		return err
+	}
+
+	err = c.putCompositeValue("TransferLog~from~uuid", []string{log.FromUser, log.UUID})
+	if err != nil {
+		return err
+	}
+
+	err = c.putCompositeValue("TransferLog~to~uuid", []string{log.ToUser, log.UUID})
+	if err != nil {
+		return err
+	}
+
+	err = c.putStatement(log.FromUser, log.Currency, &StatementEntry{
+		Kind:         StatementTransfer,
+		Ref:          log.UUID,
+		Counterparty: log.ToUser,
+		Available:    -log.Count,
+	})
+	if err != nil {
+		return err
+	}
+
+	return c.putStatement(log.ToUser, log.Currency, &StatementEntry{
+		Kind:         StatementTransfer,
+		Ref:          log.UUID,
+		Counterparty: log.FromUser,
+		Available:    log.Count,
+	})
+}
+
+func (c *ExchangeChaincode) getTransferLogs(indexName, owner string) ([]*TransferLog, error) {
+	bb, err := c.getCompositeValue(indexName, []string{owner}, 1)
+	if err != nil {
+		return nil, err
+	}
+
+	var logs []*TransferLog
+	for _, v := range bb {
+		log := new(TransferLog)
+		err = json.Unmarshal(v, log)
+		if err != nil {
+			return nil, err
+		}
+
+		logs = append(logs, log)
+	}
+	return logs, nil
+}
+
+func (c *ExchangeChaincode) getFromTransferLog(owner string) ([]*TransferLog, error) {
+	return c.getTransferLogs("TransferLog~from~uuid", owner)
+}
+
+func (c *ExchangeChaincode) getToTransferLog(owner string) ([]*TransferLog, error) {
+	return c.getTransferLogs("TransferLog~to~uuid", owner)
+}
+
 type LockLog struct {
+	DocType   string `json:"docType,omitempty"`
 	UUID      string `json:"uuid"`
 	Owner     string `json:"owner"`
 	Currency  string `json:"currency"`
@@ -394,12 +632,14 @@ type LockLog struct {
 	IsLock    bool   `json:"isLock"`
 	LockCount int64  `json:"lockCount"`
 	LockTime  int64  `json:"lockTime"`
+	Nonce     int64  `json:"nonce,omitempty"`
 }
 
 func (c *ExchangeChaincode) putLockLog(log *LockLog) error {
 	if log.UUID == "" {
 		log.UUID = GenerateUUID()
 	}
+	log.DocType = DocLockLog
 	r, err := json.Marshal(log)
 	if err != nil {
 		return err
@@ -415,7 +655,17 @@ func (c *ExchangeChaincode) putLockLog(log *LockLog) error {
 		return err
 	}
 
-	return nil
+	entry := &StatementEntry{
+		Kind:      StatementLock,
+		Ref:       log.Order,
+		Available: -log.LockCount,
+		Locked:    log.LockCount,
+	}
+	if !log.IsLock {
+		entry.Kind = StatementUnlock
+		entry.Available, entry.Locked = log.LockCount, -log.LockCount
+	}
+	return c.putStatement(log.Owner, log.Currency, entry)
 }
 
 // getLockLog getLockLog
@@ -424,9 +674,12 @@ func (c *ExchangeChaincode) getLockLog(key string) (*LockLog, error) {
 	if err != nil {
 		return nil, err
 	}
+	if logByte == nil {
+		return nil, nil
+	}
 
-	var log *LockLog
-	err = json.Unmarshal(logByte, logByte)
+	log := new(LockLog)
+	err = json.Unmarshal(logByte, log)
 	if err != nil {
 		return nil, err
 	}
@@ -440,8 +693,11 @@ func (c *ExchangeChaincode) getLockLogByParm(owner, currency, order string, islo
 	if err != nil {
 		return nil, err
 	}
+	if len(bb) == 0 {
+		return nil, nil
+	}
 
-	var log *LockLog
+	log := new(LockLog)
 	err = json.Unmarshal(bb[0], log)
 	if err != nil {
 		return nil, err
@@ -450,6 +706,7 @@ func (c *ExchangeChaincode) getLockLogByParm(owner, currency, order string, islo
 }
 
 type Order struct {
+	DocType      string `json:"docType,omitempty"`
 	UUID         string `json:"uuid"`
 	Account      string `json:"account"`
 	SrcCurrency  string `json:"srcCurrency"`
@@ -465,45 +722,50 @@ type Order struct {
 	RawUUID      string `json:"rawUUID"`
 	Metadata     string `json:"metadata"`
 	FinalCost    int64  `json:"finalCost"`
+
+	// raw order signed by the account holder, checked by exchange
+	Terms     *OrderTerms `json:"terms,omitempty"`
+	Signature string      `json:"signature,omitempty"`
 }
 
 // putTxLog
 func (c *ExchangeChaincode) putTxLog(buyOrder, sellOrder *Order) error {
-	buyJson, err := json.Marshal(buyOrder)
-	if err != nil {
-		return err
-	}
-	sellJson, err := json.Marshal(sellOrder)
+	err := c.putOrderLog(buyOrder)
 	if err != nil {
 		return err
 	}
 
-	err = c.stub.PutState(buyOrder.UUID, buyJson)
+	err = c.putOrderLog(sellOrder)
 	if err != nil {
 		return err
 	}
 
-	err = c.stub.PutState(sellOrder.UUID, sellJson)
+	err = c.putCompositeValue("Order~uuid", []string{buyOrder.UUID})
 	if err != nil {
 		return err
 	}
 
-	indexName := "Order~owner~src~des~raw~uuid"
-	err = c.putCompositeValue(indexName, []string{buyOrder.Account, buyOrder.SrcCurrency, buyOrder.DesCurrency, buyOrder.RawUUID, buyOrder.UUID})
+	err = c.putFillStatements(buyOrder, sellOrder.Account)
 	if err != nil {
 		return err
 	}
+	return c.putFillStatements(sellOrder, buyOrder.Account)
+}
 
-	err = c.putCompositeValue(indexName, []string{sellOrder.Account, sellOrder.SrcCurrency, sellOrder.DesCurrency, sellOrder.RawUUID, sellOrder.UUID})
+// putOrderLog store a filled order, indexed by owner for the lock accounting of its raw order
+func (c *ExchangeChaincode) putOrderLog(order *Order) error {
+	order.DocType = DocOrder
+	orderJson, err := json.Marshal(order)
 	if err != nil {
 		return err
 	}
 
-	err = c.putCompositeValue("Order~uuid", []string{buyOrder.UUID})
+	err = c.stub.PutState(order.UUID, orderJson)
 	if err != nil {
 		return err
 	}
-	return nil
+
+	return c.putCompositeValue("Order~owner~src~des~raw~uuid", []string{order.Account, order.SrcCurrency, order.DesCurrency, order.RawUUID, order.UUID})
 }
 
 // getTxLog
@@ -512,8 +774,11 @@ func (c *ExchangeChaincode) getTxLog(key string) (*Order, error) {
 	if err != nil {
 		return nil, err
 	}
+	if orderByte == nil {
+		return nil, nil
+	}
 
-	var order *Order
+	order := new(Order)
 	err = json.Unmarshal(orderByte, order)
 	if err != nil {
 		return nil, err
@@ -530,7 +795,7 @@ func (c *ExchangeChaincode) getTXs(owner, srcCurrency, desCurrency, rawOrder str
 
 	var orders []*Order
 	for _, v := range bb {
-		var order *Order
+		order := new(Order)
 		err = json.Unmarshal(v, order)
 		if err != nil {
 			return nil, err
@@ -541,6 +806,40 @@ func (c *ExchangeChaincode) getTXs(owner, srcCurrency, desCurrency, rawOrder str
 	return orders, nil
 }
 
+// RawFills fills of a raw order so far
+type RawFills struct {
+	FinalCost int64 `json:"finalCost"`
+	DesCount  int64 `json:"desCount"`
+	Fills     int64 `json:"fills"`
+}
+
+// getRawFills sum the fills of the raw order, with the sealed fills in a confidential exchange.
+// checkSealKey makes sure the sealed fills are opened with the key they are sealed with.
+func (c *ExchangeChaincode) getRawFills(owner, srcCurrency, desCurrency, rawOrder string) (*RawFills, error) {
+	txs, err := c.getTXs(owner, srcCurrency, desCurrency, rawOrder)
+	if err != nil {
+		return nil, err
+	}
+
+	fills := new(RawFills)
+	for _, tx := range txs {
+		fills.FinalCost += tx.FinalCost
+		fills.DesCount += tx.DesCount
+		fills.Fills++
+	}
+
+	if c.sealKey != nil {
+		sealed, err := c.getSealedFills(c.sealKey, owner, srcCurrency, desCurrency, rawOrder)
+		if err != nil {
+			return nil, err
+		}
+		fills.FinalCost += sealed.FinalCost
+		fills.DesCount += sealed.DesCount
+		fills.Fills += sealed.Fills
+	}
+	return fills, nil
+}
+
 func (c *ExchangeChaincode) getAllTxLog() ([]*Order, error) {
 	bb, err := c.getCompositeValue("Order~uuid", nil, 0)
 	if err != nil {
@@ -549,7 +848,7 @@ func (c *ExchangeChaincode) getAllTxLog() ([]*Order, error) {
 
 	var orders []*Order
 	for _, v := range bb {
-		var order *Order
+		order := new(Order)
 		err = json.Unmarshal(v, order)
 		if err != nil {
 			return nil, err
@@ -0,0 +1,284 @@
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"math"
+	"strconv"
+	"time"
+	"unicode/utf8"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	pb "github.com/hyperledger/fabric/protos/peer"
+)
+
+const (
+	StatementAssign   = "assign"
+	StatementTransfer = "transfer"
+	StatementLock     = "lock"
+	StatementUnlock   = "unlock"
+	StatementFill     = "fill"
+	StatementSettle   = "settle"
+	StatementRelease  = "release"
+	StatementBurn     = "burn"
+	StatementFiat     = "fiat"
+	StatementFreeze   = "freeze"
+)
+
+// StatementEntry movement of an asset, stored under the time-keyed index Statement~owner~currency~time~tx~seq
+type StatementEntry struct {
+	DocType      string `json:"docType,omitempty"`
+	Time         int64  `json:"time"`
+	Kind         string `json:"kind"`
+	Action       string `json:"action,omitempty"`
+	Ref          string `json:"ref"` // uuid of the log, order, htlc or escrow
+	Counterparty string `json:"counterparty,omitempty"`
+	Available    int64  `json:"available"`   // delta of Asset.Count
+	Locked       int64  `json:"locked"`      // delta of Asset.LockCount
+	Frozen       int64  `json:"frozen"`      // delta of Asset.FreezeCount
+	Withdrawing  int64  `json:"withdrawing"` // delta of Asset.WithdrawCount
+	Supply       int64  `json:"supply,omitempty"`
+
+	// running balance after the entry, filled by queryStatement
+	Balance         int64 `json:"balance"`
+	LockBalance     int64 `json:"lockBalance"`
+	FreezeBalance   int64 `json:"freezeBalance"`
+	WithdrawBalance int64 `json:"withdrawBalance"`
+}
+
+// Statement statement of an asset between two times
+type Statement struct {
+	Owner    string            `json:"owner"`
+	Currency string            `json:"currency"`
+	From     int64             `json:"from"`
+	To       int64             `json:"to"`
+	Opening  StatementEntry    `json:"opening"`
+	Closing  StatementEntry    `json:"closing"`
+	Entries  []*StatementEntry `json:"entries"`
+}
+
+// putStatement index a movement of the asset of owner at the tx time
+func (c *ExchangeChaincode) putStatement(owner, currency string, entry *StatementEntry) error {
+	entry.Time = c.txTime()
+	c.statementSeq++
+	key, err := c.stub.CreateCompositeKey("Statement~owner~currency~time~tx~seq", []string{owner, currency, fmt.Sprintf("%020d", c.txTimeNano()), c.stub.GetTxID(), fmt.Sprintf("%06d", c.statementSeq)})
+	if err != nil {
+		return err
+	}
+
+	entry.DocType = DocStatement
+	r, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	return c.stub.PutState(key, r)
+}
+
+// putFillStatements index the movements of one side of a fill, the counterparty is empty for a sealed fill
+func (c *ExchangeChaincode) putFillStatements(order *Order, counterparty string) error {
+	err := c.putStatement(order.Account, order.SrcCurrency, &StatementEntry{
+		Kind:         StatementFill,
+		Ref:          order.UUID,
+		Counterparty: counterparty,
+		Locked:       -order.FinalCost,
+	})
+	if err != nil {
+		return err
+	}
+
+	return c.putStatement(order.Account, order.DesCurrency, &StatementEntry{
+		Kind:         StatementFill,
+		Ref:          order.UUID,
+		Counterparty: counterparty,
+		Available:    order.DesCount,
+	})
+}
+
+// putFiatStatement index the movement of a deposit or withdrawal step
+func (c *ExchangeChaincode) putFiatStatement(log *FiatLog) error {
+	entry := &StatementEntry{
+		Kind:   StatementFiat,
+		Action: log.Action,
+		Ref:    log.UUID,
+	}
+	switch log.Action {
+	case FiatActionDeposit:
+		entry.Available = log.Amount
+	case FiatActionWithdraw:
+		entry.Available, entry.Withdrawing = -log.Amount, log.Amount
+	case FiatActionConfirm:
+		entry.Withdrawing = -log.Amount
+	case FiatActionReject:
+		entry.Available, entry.Withdrawing = log.Amount, -log.Amount
+	}
+	return c.putStatement(log.Owner, log.Currency, entry)
+}
+
+// getStatement statement of the asset. The opening balance is the asset less the entries since from,
+// so the movements before the statements were indexed are part of it.
+func (c *ExchangeChaincode) getStatement(owner, currency string, from, to int64) (*Statement, error) {
+	asset, err := c.getOwnerOneAsset(owner, currency)
+	if err != nil {
+		return nil, err
+	}
+	if asset == nil {
+		asset = new(Asset)
+	}
+
+	prefix, err := c.stub.CreateCompositeKey("Statement~owner~currency~time~tx~seq", []string{owner, currency})
+	if err != nil {
+		return nil, err
+	}
+	startKey := prefix
+	if from > 0 && from <= math.MaxInt64/int64(time.Second) {
+		startKey, err = c.stub.CreateCompositeKey("Statement~owner~currency~time~tx~seq", []string{owner, currency, fmt.Sprintf("%020d", from*int64(time.Second))})
+		if err != nil {
+			return nil, err
+		}
+	}
+	resultsIterator, err := c.stub.GetStateByRange(startKey, prefix+string(utf8.MaxRune))
+	if err != nil {
+		return nil, err
+	}
+	defer resultsIterator.Close()
+
+	statement := &Statement{
+		Owner:    owner,
+		Currency: currency,
+		From:     from,
+		To:       to,
+		Entries:  []*StatementEntry{},
+	}
+	statement.Opening.Balance = asset.Count
+	statement.Opening.LockBalance = asset.LockCount
+	statement.Opening.FreezeBalance = asset.FreezeCount
+	statement.Opening.WithdrawBalance = asset.WithdrawCount
+	for resultsIterator.HasNext() {
+		_, value, err := resultsIterator.Next()
+		if err != nil {
+			return nil, err
+		}
+
+		entry := new(StatementEntry)
+		err = json.Unmarshal(value, entry)
+		if err != nil {
+			return nil, err
+		}
+		if entry.Time < from {
+			continue
+		}
+
+		statement.Opening.Balance -= entry.Available
+		statement.Opening.LockBalance -= entry.Locked
+		statement.Opening.FreezeBalance -= entry.Frozen
+		statement.Opening.WithdrawBalance -= entry.Withdrawing
+		if entry.Time <= to {
+			statement.Entries = append(statement.Entries, entry)
+		}
+	}
+
+	balance := statement.Opening
+	for _, entry := range statement.Entries {
+		balance.Balance += entry.Available
+		balance.LockBalance += entry.Locked
+		balance.FreezeBalance += entry.Frozen
+		balance.WithdrawBalance += entry.Withdrawing
+		entry.Balance = balance.Balance
+		entry.LockBalance = balance.LockBalance
+		entry.FreezeBalance = balance.FreezeBalance
+		entry.WithdrawBalance = balance.WithdrawBalance
+	}
+	statement.Closing = balance
+
+	statement.Opening.Kind = "opening"
+	statement.Opening.Time = from
+	statement.Closing.Kind = "closing"
+	statement.Closing.Time = to
+	return statement, nil
+}
+
+// csv render the statement as csv, the opening and closing balances are the first and last rows
+func (s *Statement) csv() ([]byte, error) {
+	buf := new(bytes.Buffer)
+	w := csv.NewWriter(buf)
+
+	err := w.Write([]string{"time", "kind", "action", "ref", "counterparty", "available", "locked", "frozen", "withdrawing", "supply", "balance", "lockBalance", "freezeBalance", "withdrawBalance"})
+	if err != nil {
+		return nil, err
+	}
+
+	rows := append([]*StatementEntry{&s.Opening}, s.Entries...)
+	rows = append(rows, &s.Closing)
+	for _, v := range rows {
+		err = w.Write([]string{
+			strconv.FormatInt(v.Time, 10),
+			v.Kind,
+			v.Action,
+			v.Ref,
+			v.Counterparty,
+			strconv.FormatInt(v.Available, 10),
+			strconv.FormatInt(v.Locked, 10),
+			strconv.FormatInt(v.Frozen, 10),
+			strconv.FormatInt(v.Withdrawing, 10),
+			strconv.FormatInt(v.Supply, 10),
+			strconv.FormatInt(v.Balance, 10),
+			strconv.FormatInt(v.LockBalance, 10),
+			strconv.FormatInt(v.FreezeBalance, 10),
+			strconv.FormatInt(v.WithdrawBalance, 10),
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	w.Flush()
+	if err = w.Error(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// queryStatement
+// args: owner, currency id, from, to[, format (json|csv)]
+func (c *ExchangeChaincode) queryStatement() pb.Response {
+	myLogger.Debug("queryStatement...")
+
+	if len(c.args) != 4 && len(c.args) != 5 {
+		return shim.Error("Incorrect number of arguments. Expecting 4 or 5")
+	}
+
+	from, err := strconv.ParseInt(c.args[2], 10, 64)
+	if err != nil {
+		return shim.Error("The from time must be an unix timestamp")
+	}
+	to, err := strconv.ParseInt(c.args[3], 10, 64)
+	if err != nil || to < from {
+		return shim.Error("The to time must be an unix timestamp >= from")
+	}
+	format := "json"
+	if len(c.args) == 5 {
+		format = c.args[4]
+	}
+
+	statement, err := c.getStatement(c.args[0], c.args[1], from, to)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	var payload []byte
+	switch format {
+	case "json":
+		payload, err = json.Marshal(statement)
+	case "csv":
+		payload, err = statement.csv()
+	default:
+		return shim.Error(fmt.Sprintf("Unknown format [%s]", format))
+	}
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	return shim.Success(payload)
+}
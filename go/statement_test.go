@@ -0,0 +1,41 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestStatementOpening(t *testing.T) {
+	e := newTestEnv(t)
+	e.now = 1500000000
+	e.openAccount("alice")
+	e.openAccount("bob")
+	e.fund("alice", "EUR", 100)
+
+	// a balance from before the statements were indexed
+	e.stub.MockTransactionStart("legacy")
+	asset := e.asset("alice", "EUR")
+	asset.Count += 50
+	err := (&ExchangeChaincode{stub: e.stub}).putAsset(asset)
+	e.stub.MockTransactionEnd("legacy")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	e.now += 100
+	e.mustInvoke("alice", "transfer", "EUR", "bob", "30", "rent")
+	e.now += 100
+	e.mustInvoke("alice", "transfer", "EUR", "bob", "20", "rent")
+
+	statement := new(Statement)
+	err = json.Unmarshal(e.mustInvoke("alice", "queryStatement", "alice", "EUR", itoa(e.now-150), itoa(e.now-50)), statement)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if statement.Opening.Balance != 150 || statement.Closing.Balance != 120 || len(statement.Entries) != 1 {
+		t.Fatalf("statement: %+v", statement)
+	}
+	if statement.Entries[0].Balance != 120 {
+		t.Fatalf("running balance: %+v", statement.Entries[0])
+	}
+}
@@ -2,25 +2,88 @@ package main
 
 import (
 	"crypto/rand"
+	"crypto/x509"
 	"encoding/base64"
+	"encoding/pem"
+	"errors"
 	"fmt"
 	"io"
+	"time"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/hyperledger/fabric/msp"
+	pb "github.com/hyperledger/fabric/protos/peer"
 )
 
-func dealParam(function string, args []string) (string, []string) {
-	functionB, err := base64.StdEncoding.DecodeString(function)
-	if err != nil {
-		return function, args
+const (
+	// ArgEncodingRaw args are passed as plain strings
+	ArgEncodingRaw = "raw"
+	// ArgEncodingBase64 function name and args are base64 encoded
+	ArgEncodingBase64 = "base64"
+	// ArgEncodingProtobuf function name and args are packed in a marshalled pb.ChaincodeInput
+	ArgEncodingProtobuf = "protobuf"
+)
+
+// decodeArgs decodes the invoke args according to the envelope flag carried by the first arg.
+// Args without a known flag are treated as raw: function, args...
+// With a flag the layout is:
+//
+//	raw, function, args...
+//	base64, base64(function), base64(args)...
+//	protobuf, marshalled pb.ChaincodeInput{function, args...}
+//
+// The flags are reserved: raw, base64 and protobuf can't be used as function names.
+func decodeArgs(input [][]byte) (string, []string, error) {
+	if len(input) == 0 {
+		return "", nil, errors.New("Missing function name")
 	}
-	for k, v := range args {
-		argB, err := base64.StdEncoding.DecodeString(v)
+
+	var err error
+	encoding := string(input[0])
+	switch encoding {
+	case ArgEncodingRaw:
+		input = input[1:]
+	case ArgEncodingBase64:
+		input, err = dealParam(input[1:])
 		if err != nil {
-			return function, args
+			return "", nil, err
 		}
-		args[k] = string(argB)
+	case ArgEncodingProtobuf:
+		if len(input) != 2 {
+			return "", nil, errors.New("Incorrect number of arguments. Expecting 1 protobuf payload")
+		}
+		var ci pb.ChaincodeInput
+		err = proto.Unmarshal(input[1], &ci)
+		if err != nil {
+			return "", nil, fmt.Errorf("Failed unmarshalling protobuf args: [%s]", err)
+		}
+		input = ci.Args
+	}
+
+	if len(input) == 0 {
+		return "", nil, fmt.Errorf("Missing function name in %s args", encoding)
 	}
 
-	return string(functionB), args
+	args := make([]string, 0, len(input)-1)
+	for _, v := range input[1:] {
+		args = append(args, string(v))
+	}
+
+	return string(input[0]), args, nil
+}
+
+// dealParam decodes base64 encoded function name and args
+func dealParam(input [][]byte) ([][]byte, error) {
+	output := make([][]byte, 0, len(input))
+	for k, v := range input {
+		b, err := base64.StdEncoding.DecodeString(string(v))
+		if err != nil {
+			return nil, fmt.Errorf("Failed decoding base64 arg %d: [%s]", k, err)
+		}
+		output = append(output, b)
+	}
+
+	return output, nil
 }
 
 // GenerateBytesUUID returns a UUID based on RFC 4122 returning the generated bytes
@@ -49,3 +112,49 @@ func GenerateUUID() string {
 func idBytesToStr(id []byte) string {
 	return fmt.Sprintf("%x-%x-%x-%x-%x", id[0:4], id[4:6], id[6:8], id[8:10], id[10:])
 }
+
+// txTime returns the tx timestamp in unix seconds, the local time is used when the stub has none
+func (c *ExchangeChaincode) txTime() int64 {
+	ts, err := c.stub.GetTxTimestamp()
+	if err != nil || ts == nil {
+		return time.Now().Unix()
+	}
+	return ts.Seconds
+}
+
+// txTimeNano returns the tx timestamp in unix nanoseconds, used to order records written in the same second
+func (c *ExchangeChaincode) txTimeNano() int64 {
+	ts, err := c.stub.GetTxTimestamp()
+	if err != nil || ts == nil {
+		return time.Now().UnixNano()
+	}
+	return ts.Seconds*int64(time.Second) + int64(ts.Nanos)
+}
+
+// getCaller returns the identity of the tx creator as "mspid/common name"
+func (c *ExchangeChaincode) getCaller() (string, error) {
+	creator, err := c.stub.GetCreator()
+	if err != nil {
+		return "", fmt.Errorf("Failed retrieving creator: [%s]", err)
+	}
+	if len(creator) == 0 {
+		return "", errors.New("The creator of the tx is empty")
+	}
+
+	sid := new(msp.SerializedIdentity)
+	err = proto.Unmarshal(creator, sid)
+	if err != nil {
+		return "", fmt.Errorf("Failed unmarshalling creator: [%s]", err)
+	}
+
+	block, _ := pem.Decode(sid.IdBytes)
+	if block == nil {
+		return "", errors.New("The creator certificate is not PEM encoded")
+	}
+	cert, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		return "", fmt.Errorf("Failed parsing creator certificate: [%s]", err)
+	}
+
+	return sid.Mspid + "/" + cert.Subject.CommonName, nil
+}
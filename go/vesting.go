@@ -0,0 +1,321 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"strconv"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	pb "github.com/hyperledger/fabric/protos/peer"
+)
+
+// Vesting vesting schedule of an assignment, the unvested amount stays reserved out of Currency.LeftCount
+type Vesting struct {
+	DocType    string `json:"docType,omitempty"`
+	UUID       string `json:"uuid"`
+	Currency   string `json:"currency"`
+	Issuer     string `json:"issuer"`
+	Owner      string `json:"owner"`
+	Total      int64  `json:"total"`
+	Claimed    int64  `json:"claimed"`
+	Revoked    int64  `json:"revoked"`
+	Start      int64  `json:"start"`
+	Cliff      int64  `json:"cliff"`    // seconds after start
+	Duration   int64  `json:"duration"` // seconds after start
+	Interval   int64  `json:"interval"` // seconds between unlocks
+	RevokeTime int64  `json:"revokeTime"`
+}
+
+// vestedAt the amount vested at time t
+func (v *Vesting) vestedAt(t int64) int64 {
+	elapsed := t - v.Start
+	vested := int64(0)
+	if elapsed >= v.Duration {
+		vested = v.Total
+	} else if elapsed >= v.Cliff {
+		// Total * unlocked / Duration overflows int64 for large supplies, the quotient is at most Total
+		unlocked := elapsed / v.Interval * v.Interval
+		q := new(big.Int).Mul(big.NewInt(v.Total), big.NewInt(unlocked))
+		vested = q.Quo(q, big.NewInt(v.Duration)).Int64()
+	}
+
+	if vested > v.Total-v.Revoked {
+		vested = v.Total - v.Revoked
+	}
+	return vested
+}
+
+func (c *ExchangeChaincode) putVesting(vesting *Vesting) error {
+	if vesting.UUID == "" {
+		vesting.UUID = GenerateUUID()
+	}
+	vesting.DocType = DocVesting
+	r, err := json.Marshal(vesting)
+	if err != nil {
+		return err
+	}
+
+	err = c.stub.PutState(vesting.UUID, r)
+	if err != nil {
+		return err
+	}
+
+	err = c.putCompositeValue("Vesting~owner~uuid", []string{vesting.Owner, vesting.UUID})
+	if err != nil {
+		return err
+	}
+
+	err = c.putCompositeValue("Vesting~currency~uuid", []string{vesting.Currency, vesting.UUID})
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+func (c *ExchangeChaincode) getVesting(key string) (*Vesting, error) {
+	vestingByte, err := c.stub.GetState(key)
+	if err != nil {
+		return nil, err
+	}
+	if vestingByte == nil {
+		return nil, nil
+	}
+
+	vesting := new(Vesting)
+	err = json.Unmarshal(vestingByte, vesting)
+	if err != nil {
+		return nil, err
+	}
+	return vesting, nil
+}
+
+func (c *ExchangeChaincode) getOwnerVesting(owner string) ([]*Vesting, error) {
+	bb, err := c.getCompositeValue("Vesting~owner~uuid", []string{owner}, 1)
+	if err != nil {
+		return nil, err
+	}
+
+	var vestings []*Vesting
+	for _, v := range bb {
+		vesting := new(Vesting)
+		err = json.Unmarshal(v, vesting)
+		if err != nil {
+			return nil, err
+		}
+		vestings = append(vestings, vesting)
+	}
+	return vestings, nil
+}
+
+func (c *ExchangeChaincode) getVestingByID(id string) (*Vesting, error) {
+	vesting, err := c.getVesting(id)
+	if err != nil {
+		return nil, fmt.Errorf("Failed retrieving vesting [%s]: [%s]", id, err)
+	}
+	if vesting == nil || vesting.UUID == "" {
+		return nil, fmt.Errorf("The vesting [%s] does not exist", id)
+	}
+	return vesting, nil
+}
+
+// assignVested assign currency vesting linearly after a cliff, claimed by claimVested.
+// Requires the creator or an admin, or the issuers when the currency has an issuer set.
+// args: currency id, owner, total, cliff, duration, interval (seconds)
+func (c *ExchangeChaincode) assignVested() pb.Response {
+	myLogger.Debug("Assign Vested Currency...")
+
+	if len(c.args) != 6 {
+		return shim.Error("Incorrect number of arguments. Expecting 6")
+	}
+
+	var nums [4]int64
+	for i := range nums {
+		n, err := strconv.ParseInt(c.args[i+2], 10, 64)
+		if err != nil || n < 0 {
+			return shim.Error("The total, cliff, duration and interval must be >= 0")
+		}
+		nums[i] = n
+	}
+	vesting := &Vesting{
+		Currency: c.args[0],
+		Owner:    c.args[1],
+		Total:    nums[0],
+		Cliff:    nums[1],
+		Duration: nums[2],
+		Interval: nums[3],
+	}
+	if vesting.Total <= 0 {
+		return shim.Error("The vesting total must be > 0")
+	}
+	if vesting.Duration <= 0 || vesting.Cliff > vesting.Duration {
+		return shim.Error("The vesting duration must be > 0 and not shorter than the cliff")
+	}
+	if vesting.Interval <= 0 || vesting.Interval > vesting.Duration {
+		return shim.Error("The vesting interval must be between 1 and the duration")
+	}
+
+	curr, err := c.getCurrencyByName(vesting.Currency)
+	if err != nil {
+		myLogger.Errorf("assignVested error1:%s", err)
+		return shim.Error(fmt.Sprintf("Failed retrieving currency [%s]: [%s]", vesting.Currency, err))
+	}
+	if curr == nil {
+		return shim.Error(fmt.Sprintf("The currency [%s] does not exist", vesting.Currency))
+	}
+	err = curr.checkOpen(PauseIssuance)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if curr.IssuerThreshold > 0 && !c.proposalApproved {
+		return c.proposeIssuance(curr, "assignVested")
+	}
+	if !c.proposalApproved {
+		_, err = c.checkCreatorOrAdmin(curr)
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+	}
+	if curr.LeftCount < vesting.Total {
+		return shim.Error(fmt.Sprintf("The left count [%d] of currency [%s] is insufficient", curr.LeftCount, vesting.Currency))
+	}
+
+	err, _ = c.checkAccountActive(vesting.Owner)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	vesting.Issuer = curr.Creator
+	vesting.Start = c.txTime()
+	err = c.putVesting(vesting)
+	if err != nil {
+		myLogger.Errorf("assignVested error2:%s", err)
+		return shim.Error(err.Error())
+	}
+
+	curr.LeftCount -= vesting.Total
+	err = c.putCurrency(curr)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	myLogger.Debug("Assign Vested Currency...done")
+	return shim.Success([]byte(vesting.UUID))
+}
+
+// claimVested credit the vested but unclaimed amount to the owner
+// args: vesting id
+func (c *ExchangeChaincode) claimVested() pb.Response {
+	myLogger.Debug("Claim Vested Currency...")
+
+	if len(c.args) != 1 {
+		return shim.Error("Incorrect number of arguments. Expecting 1")
+	}
+
+	vesting, err := c.getVestingByID(c.args[0])
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	now := c.txTime()
+	claimable := vesting.vestedAt(now) - vesting.Claimed
+	if claimable <= 0 {
+		return shim.Error(fmt.Sprintf("Nothing of vesting [%s] is claimable", vesting.UUID))
+	}
+
+	err, _ = c.checkAccountActive(vesting.Owner)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	asset, err := c.getOwnerOneAsset(vesting.Owner, vesting.Currency)
+	if err != nil {
+		myLogger.Errorf("claimVested error1:%s", err)
+		return shim.Error(fmt.Sprintf("Failed retrieving asset [%s] of the user: [%s]", vesting.Currency, err))
+	}
+	if asset == nil {
+		asset = &Asset{
+			Owner:    vesting.Owner,
+			Currency: vesting.Currency,
+		}
+	}
+	asset.Count = asset.Count + claimable
+	err = c.putAsset(asset)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	err = c.putAssignLog(&AssignLog{
+		Currency:    vesting.Currency,
+		FromUser:    vesting.Issuer,
+		ToUser:      vesting.Owner,
+		Count:       claimable,
+		AssignTime:  now,
+		VestingUUID: vesting.UUID,
+	})
+	if err != nil {
+		myLogger.Errorf("claimVested error2:%s", err)
+		return shim.Error(err.Error())
+	}
+
+	vesting.Claimed = vesting.Claimed + claimable
+	err = c.putVesting(vesting)
+	if err != nil {
+		myLogger.Errorf("claimVested error3:%s", err)
+		return shim.Error(err.Error())
+	}
+
+	myLogger.Debug("Claim Vested Currency...done")
+	return shim.Success([]byte(strconv.FormatInt(claimable, 10)))
+}
+
+// revokeVested stop a vesting and return the unvested amount to the currency, requires the creator or an admin
+// args: vesting id
+func (c *ExchangeChaincode) revokeVested() pb.Response {
+	myLogger.Debug("Revoke Vested Currency...")
+
+	if len(c.args) != 1 {
+		return shim.Error("Incorrect number of arguments. Expecting 1")
+	}
+
+	vesting, err := c.getVestingByID(c.args[0])
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if vesting.RevokeTime != 0 {
+		return shim.Error(fmt.Sprintf("The vesting [%s] is revoked", vesting.UUID))
+	}
+
+	curr, err := c.getCurrencyByName(vesting.Currency)
+	if err != nil {
+		myLogger.Errorf("revokeVested error1:%s", err)
+		return shim.Error(fmt.Sprintf("Failed retrieving currency [%s]: [%s]", vesting.Currency, err))
+	}
+	if curr == nil {
+		return shim.Error(fmt.Sprintf("The currency [%s] does not exist", vesting.Currency))
+	}
+
+	_, err = c.checkCreatorOrAdmin(curr)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	now := c.txTime()
+	unvested := vesting.Total - vesting.vestedAt(now)
+	vesting.Revoked = unvested
+	vesting.RevokeTime = now
+	err = c.putVesting(vesting)
+	if err != nil {
+		myLogger.Errorf("revokeVested error2:%s", err)
+		return shim.Error(err.Error())
+	}
+
+	curr.LeftCount += unvested
+	err = c.putCurrency(curr)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	myLogger.Debug("Revoke Vested Currency...done")
+	return shim.Success([]byte(strconv.FormatInt(unvested, 10)))
+}
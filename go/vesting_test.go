@@ -0,0 +1,93 @@
+package main
+
+import (
+	"math"
+	"testing"
+)
+
+func TestVestingLifecycle(t *testing.T) {
+	e := newTestEnv(t)
+	e.openAccount("alice")
+	e.openAccount("mallory")
+	e.mustInvoke("root", "create", "EUR", "1000", "")
+	c := &ExchangeChaincode{stub: e.stub}
+	leftCount := func() int64 {
+		curr, err := c.getCurrencyByName("EUR")
+		if err != nil {
+			t.Fatal(err)
+		}
+		return curr.LeftCount
+	}
+
+	e.now = 1000
+	e.mustFail("mallory", "assignVested", "EUR", "mallory", "500", "30", "100", "10")
+	id := string(e.mustInvoke("root", "assignVested", "EUR", "alice", "1000", "30", "100", "10"))
+	if got := leftCount(); got != 0 {
+		t.Fatalf("EUR left count after assignVested: got %d, expecting 0", got)
+	}
+
+	// nothing vests before the cliff, then by interval
+	e.now = 1029
+	e.mustFail("alice", "claimVested", id)
+	e.now = 1045
+	if got := string(e.mustInvoke("alice", "claimVested", id)); got != "400" {
+		t.Fatalf("claimed %s, expecting 400", got)
+	}
+	if got := e.asset("alice", "EUR").Count; got != 400 {
+		t.Fatalf("alice EUR: got %d, expecting 400", got)
+	}
+
+	// the unvested amount returns to the currency
+	e.mustFail("mallory", "revokeVested", id)
+	if got := string(e.mustInvoke("root", "revokeVested", id)); got != "600" {
+		t.Fatalf("revoked %s, expecting 600", got)
+	}
+	if got := leftCount(); got != 600 {
+		t.Fatalf("EUR left count after revokeVested: got %d, expecting 600", got)
+	}
+	e.mustFail("root", "revokeVested", id)
+
+	e.now = 2000
+	e.mustFail("alice", "claimVested", id)
+	if got := e.asset("alice", "EUR").Count; got != 400 {
+		t.Fatalf("alice EUR after revoke: got %d, expecting 400", got)
+	}
+}
+
+func TestVestedAt(t *testing.T) {
+	v := &Vesting{Total: 1000, Start: 100, Cliff: 30, Duration: 100, Interval: 10}
+
+	for _, c := range []struct {
+		t, vested int64
+	}{
+		{50, 0},    // before start
+		{129, 0},   // before the cliff
+		{130, 300}, // at the cliff
+		{145, 400}, // between two unlocks
+		{199, 900},
+		{200, 1000},
+		{500, 1000},
+	} {
+		if got := v.vestedAt(c.t); got != c.vested {
+			t.Errorf("vestedAt(%d): got %d, expecting %d", c.t, got, c.vested)
+		}
+	}
+
+	v.Revoked = 600
+	if got := v.vestedAt(500); got != 400 {
+		t.Errorf("vestedAt after revoke: got %d, expecting 400", got)
+	}
+}
+
+func TestVestedAtLargeSupply(t *testing.T) {
+	// Total * elapsed overflows int64
+	year := int64(365 * 24 * 3600)
+	v := &Vesting{Total: math.MaxInt64 / 2, Start: 0, Duration: 4 * year, Interval: 1}
+
+	if got, want := v.vestedAt(2*year), v.Total/2; got != want {
+		t.Fatalf("got %d, expecting %d", got, want)
+	}
+	if got := v.vestedAt(4*year - 1); got <= 0 || got >= v.Total {
+		t.Fatalf("got %d, expecting between 0 and %d", got, v.Total)
+	}
+}